@@ -14,6 +14,8 @@ type CaddyHandler struct {
 	next         httpserver.Handler
 	config       *login.Config
 	loginHandler *login.Handler
+	// access rules of the require and allow directives
+	rules []accessRule
 }
 
 // Create the handler
@@ -29,6 +31,10 @@ func NewCaddyHandler(next httpserver.Handler, loginHandler *login.Handler, confi
 func (h *CaddyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) (int, error) {
 	// Fetch jwt token. If valid set a Caddy replacer for {user}
 	userInfo, valid := h.loginHandler.GetToken(r)
+	if !valid && h.loginHandler.HasRevokedToken(r) {
+		// remove the revoked token, so that it is not accepted by other middleware, e.g. the caddy jwt plugin
+		r = withoutToken(r, h.config.CookieName)
+	}
 	if valid {
 		// let upstream middleware (e.g. fastcgi and cgi) know about authenticated
 		// user; this replaces the request with a wrapped instance
@@ -42,5 +48,24 @@ func (h *CaddyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) (int, e
 		h.loginHandler.ServeHTTP(w, r)
 		return 0, nil
 	}
+	if h.denied(w, r, userInfo, valid) {
+		return 0, nil
+	}
 	return h.next.ServeHTTP(w, r)
 }
+
+// Returns a copy of the request without the jwt, i.e. the named cookie and a bearer token
+func withoutToken(r *http.Request, name string) *http.Request {
+	cookies := r.Cookies()
+	r = r.Clone(r.Context())
+	r.Header.Del("Cookie")
+	if login.BearerToken(r) != "" {
+		r.Header.Del("Authorization")
+	}
+	for _, c := range cookies {
+		if c.Name != name {
+			r.AddCookie(c)
+		}
+	}
+	return r
+}
@@ -3,6 +3,7 @@ package caddy
 import (
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -94,3 +95,109 @@ func Test_ServeHTTP_login(t *testing.T) {
 		t.Errorf("Expected returned status code to be %d, got %d", 0, status)
 	}
 }
+
+// Tests that a revoked token is not passed to the next handler
+func Test_ServeHTTP_RevokedToken(t *testing.T) {
+	configh := login.DefaultConfig()
+	configh.Backends = login.Options{"simple": {"bob": "secret"}}
+	loginh, err := login.NewHandler(configh)
+	if err != nil {
+		t.Errorf("Expected nil error, got: %v", err)
+	}
+	// Login and revoke the token
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "/login", strings.NewReader("username=bob&password=secret"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	loginh.ServeHTTP(w, r)
+	cookie := &http.Cookie{Name: "jwt_token", Value: w.Body.String()}
+	r, _ = http.NewRequest("POST", "/login/revoke", nil)
+	r.AddCookie(cookie)
+	loginh.ServeHTTP(httptest.NewRecorder(), r)
+	var nextCalled bool
+	h := &CaddyHandler{
+		next: httpserver.HandlerFunc(func(w http.ResponseWriter, r *http.Request) (int, error) {
+			nextCalled = true
+			if _, err := r.Cookie("jwt_token"); err == nil {
+				t.Errorf("Expected the revoked token to be removed from the request")
+			}
+			if c, err := r.Cookie("other"); err != nil || c.Value != "value" {
+				t.Errorf("Expected other cookies to be kept")
+			}
+			return http.StatusOK, nil
+		}),
+		config:       configh,
+		loginHandler: loginh,
+	}
+	r, _ = http.NewRequest("GET", "/", nil)
+	r.AddCookie(cookie)
+	r.AddCookie(&http.Cookie{Name: "other", Value: "value"})
+	if _, err := h.ServeHTTP(httptest.NewRecorder(), r); err != nil {
+		t.Errorf("Expected nil error, got: %v", err)
+	}
+	if !nextCalled {
+		t.Errorf("Expected the next handler to be called")
+	}
+}
+
+func Test_ServeHTTP_Rules(t *testing.T) {
+	configh := login.DefaultConfig()
+	configh.Backends = login.Options{"simple": {"bob": "secret"}}
+	loginh, err := login.NewHandler(configh)
+	if err != nil {
+		t.Errorf("Expected nil error, got: %v", err)
+	}
+	h := &CaddyHandler{
+		next: httpserver.HandlerFunc(func(w http.ResponseWriter, r *http.Request) (int, error) {
+			return http.StatusOK, nil
+		}),
+		config:       configh,
+		loginHandler: loginh,
+		rules: []accessRule{
+			{path: "/admin", requirements: login.AccessRequirements{Groups: []string{"admins"}}},
+			{path: "/private"},
+			{path: "/private/public", anonymous: true},
+		},
+	}
+	userInfo := model.UserInfo{Sub: "bob", Expiry: time.Now().Add(time.Minute).Unix()}
+	validToken, err := jwt.NewWithClaims(jwt.SigningMethodHS512, userInfo).SignedString([]byte(configh.JwtSecret))
+	if err != nil {
+		t.Errorf("Expected nil error, got: %v", err)
+	}
+	for _, test := range []struct {
+		path           string
+		accept         string
+		authenticated  bool
+		expectedStatus int
+	}{
+		{"/", "", false, http.StatusOK},
+		{"/private/public/index.html", "", false, http.StatusOK},
+		{"/private/index.html", "text/html", false, http.StatusSeeOther},
+		{"/private/index.html", "application/json", false, http.StatusUnauthorized},
+		{"/private/index.html", "", true, http.StatusOK},
+		{"/admin", "", true, http.StatusForbidden},
+	} {
+		r, _ := http.NewRequest("GET", test.path, nil)
+		r.Header.Set("Accept", test.accept)
+		if test.authenticated {
+			r.Header.Set("Authorization", "Bearer "+validToken)
+		}
+		w := httptest.NewRecorder()
+		status, err := h.ServeHTTP(w, r)
+		if err != nil {
+			t.Errorf("Expected nil error, got: %v", err)
+		}
+		if status == 0 {
+			status = w.Code
+		}
+		if status != test.expectedStatus {
+			t.Errorf("Expected status %v for %v, got: %v", test.expectedStatus, test.path, status)
+		}
+	}
+	r, _ := http.NewRequest("GET", "/private/index.html?x=1", nil)
+	r.Header.Set("Accept", "text/html")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if location := w.Header().Get("Location"); location != "/login?backTo=%2Fprivate%2Findex.html%3Fx%3D1" {
+		t.Errorf("Expected redirect to the login form, got: %v", location)
+	}
+}
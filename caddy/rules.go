@@ -0,0 +1,88 @@
+package caddy
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/caddyserver/caddy/caddyhttp/httpserver"
+	"github.com/pchchv/logsrv/login"
+	"github.com/pchchv/logsrv/model"
+)
+
+// Directives of the access rules within the login block
+const (
+	// require <path> [groups=..] [domain=..] [origin=..]
+	requireDirective = "require"
+	// allow <path>
+	allowDirective = "allow"
+)
+
+// Access rule for the requests below a path.
+// A rule requires a valid token, unless anonymous access is allowed.
+type accessRule struct {
+	path         string
+	anonymous    bool
+	requirements login.AccessRequirements
+}
+
+func isRuleDirective(name string) bool {
+	return name == requireDirective || name == allowDirective
+}
+
+// Parses the arguments of a require or allow directive
+func parseRule(directive string, args []string) (accessRule, error) {
+	if len(args) == 0 || !strings.HasPrefix(args[0], "/") {
+		return accessRule{}, fmt.Errorf("%v needs an absolute path as first argument", directive)
+	}
+	rule := accessRule{path: args[0]}
+	if directive == allowDirective {
+		if len(args) > 1 {
+			return accessRule{}, fmt.Errorf("%v takes only a path", directive)
+		}
+		rule.anonymous = true
+		return rule, nil
+	}
+	var err error
+	rule.requirements, err = login.ParseAccessRequirements(args[1:])
+	return rule, err
+}
+
+// Returns the rule with the longest path, which matches the request path
+func matchRule(rules []accessRule, requestPath string) (accessRule, bool) {
+	var match accessRule
+	found := false
+	for _, rule := range rules {
+		if httpserver.Path(requestPath).Matches(rule.path) && (!found || len(rule.path) > len(match.path)) {
+			match = rule
+			found = true
+		}
+	}
+	return match, found
+}
+
+// Checks the access rules for the request and writes the response, if the access is denied.
+// Unauthenticated browsers are redirected to the login form, other clients get 401.
+func (h *CaddyHandler) denied(w http.ResponseWriter, r *http.Request, userInfo model.UserInfo, valid bool) bool {
+	rule, found := matchRule(h.rules, r.URL.Path)
+	if !found || rule.anonymous {
+		return false
+	}
+	if !valid {
+		if strings.Contains(r.Header.Get("Accept"), "text/html") {
+			loginURL := h.config.LoginPath + "?" + url.Values{h.config.RedirectQueryParameter: {r.URL.RequestURI()}}.Encode()
+			w.Header().Set("Location", loginURL)
+			w.WriteHeader(http.StatusSeeOther)
+			return true
+		}
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return true
+	}
+	if !rule.requirements.Allows(userInfo) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return true
+	}
+	return false
+}
@@ -16,6 +16,7 @@ import (
 	// Import all backends, packaged with the caddy plugin
 	_ "github.com/pchchv/logsrv/htpasswd"
 	_ "github.com/pchchv/logsrv/httpupstream"
+	_ "github.com/pchchv/logsrv/ldap"
 	_ "github.com/pchchv/logsrv/oauth2"
 	_ "github.com/pchchv/logsrv/osiam"
 )
@@ -35,10 +36,17 @@ func setup(c *caddy.Controller) error {
 	}
 	for c.Next() {
 		args := c.RemainingArgs()
-		config, err := parseConfig(c)
+		config, rules, err := parseConfig(c)
 		if err != nil {
 			return err
 		}
+		// the trusted proxies and the audit log are process wide, like the logger
+		if err := logging.SetTrustedProxies(config.TrustedProxies); err != nil {
+			return err
+		}
+		if err := setAuditLog(config); err != nil {
+			return err
+		}
 		if config.Template != "" && !filepath.IsAbs(config.Template) {
 			config.Template = filepath.Join(httpserver.GetConfig(c).Root, config.Template)
 		}
@@ -50,15 +58,32 @@ func setup(c *caddy.Controller) error {
 		if err != nil {
 			return err
 		}
+		// releases the store, e.g. the lock of a bolt file, also on a restart
+		c.OnShutdown(loginHandler.Close)
 		httpserver.GetConfig(c).AddMiddleware(func(next httpserver.Handler) httpserver.Handler {
-			return NewCaddyHandler(next, loginHandler, config)
+			h := NewCaddyHandler(next, loginHandler, config)
+			h.rules = rules
+			return h
 		})
 	}
 	return nil
 }
 
-func parseConfig(c *caddy.Controller) (*login.Config, error) {
+// Opens the audit log, if configured. The audit log of the last directive with audit_log is used.
+func setAuditLog(config *login.Config) error {
+	if config.AuditLog == "" {
+		return nil
+	}
+	auditLog, err := logging.OpenAuditLog(config.AuditLog, config.AuditLogMaxSize, config.AuditLogMaxBackups)
+	if err != nil {
+		return err
+	}
+	return logging.SetAuditLog(auditLog)
+}
+
+func parseConfig(c *caddy.Controller) (*login.Config, []accessRule, error) {
 	cfg := login.DefaultConfig()
+	rules := []accessRule{}
 	cfg.Host = ""
 	cfg.Port = ""
 	cfg.LogLevel = ""
@@ -71,24 +96,32 @@ func parseConfig(c *caddy.Controller) (*login.Config, error) {
 		// the replacement supports both, for backwards compatibility
 		name := strings.Replace(c.Val(), "_", "-", -1)
 		args := c.RemainingArgs()
+		if isRuleDirective(name) {
+			rule, err := parseRule(name, args)
+			if err != nil {
+				return cfg, nil, fmt.Errorf("Invalid rule: %v (%v:%v)", err, c.File(), c.Line())
+			}
+			rules = append(rules, rule)
+			continue
+		}
 		if len(args) != 1 {
-			return cfg, fmt.Errorf("Wrong number of arguments for %v: %v (%v:%v)", name, args, c.File(), c.Line())
+			return cfg, nil, fmt.Errorf("Wrong number of arguments for %v: %v (%v:%v)", name, args, c.File(), c.Line())
 		}
 		value := args[0]
 		f := fs.Lookup(name)
 		if f == nil {
-			return cfg, fmt.Errorf("Unknown parameter for login directive: %v (%v:%v)", name, c.File(), c.Line())
+			return cfg, nil, fmt.Errorf("Unknown parameter for login directive: %v (%v:%v)", name, c.File(), c.Line())
 		}
 		err := f.Value.Set(value)
 		if err != nil {
-			return cfg, fmt.Errorf("Invalid value for parameter %v: %v (%v:%v)", name, value, c.File(), c.Line())
+			return cfg, nil, fmt.Errorf("Invalid value for parameter %v: %v (%v:%v)", name, value, c.File(), c.Line())
 		}
 		if name == "jwt-secret" {
 			secretProvidedByConfig = true
 		}
 	}
 	if err := cfg.ResolveFileReferences(); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	secretFromEnv, secretFromEnvWasSetBefore := os.LookupEnv("JWT_SECRET")
 	if !secretProvidedByConfig && secretFromEnvWasSetBefore {
@@ -99,5 +132,5 @@ func parseConfig(c *caddy.Controller) (*login.Config, error) {
 		// but do not change a environment variable, which somebody has set it.
 		os.Setenv("JWT_SECRET", cfg.JwtSecret)
 	}
-	return cfg, nil
+	return cfg, rules, nil
 }
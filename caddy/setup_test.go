@@ -9,6 +9,7 @@ import (
 
 	"github.com/caddyserver/caddy"
 	"github.com/caddyserver/caddy/caddyhttp/httpserver"
+	"github.com/pchchv/logsrv/logging"
 	"github.com/pchchv/logsrv/login"
 	. "github.com/stretchr/testify/assert"
 )
@@ -238,3 +239,52 @@ func TestSetup_RelativeFiles(t *testing.T) {
 	Equal(t, filepath.FromSlash(root+"/myTemplate.tpl"), middleware.config.Template)
 	Equal(t, "redirectDomains.txt", middleware.config.RedirectHostFile)
 }
+
+func TestSetup_AuditLog(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "audit.log")
+	c := caddy.NewTestController("http", `login {
+		simple bob=secret
+		audit_log `+file+`
+		}`)
+	NoError(t, setup(c))
+	defer logging.SetAuditLog(nil)
+	logging.Audit(nil, logging.AuditEvent{Event: logging.AuditLogin, Subject: "bob", Outcome: logging.AuditSuccess})
+	b, err := os.ReadFile(file)
+	NoError(t, err)
+	Contains(t, string(b), `"event":"login"`)
+}
+
+func TestSetup_Rules(t *testing.T) {
+	caddyfile := `logsrv {
+		simple bob=secret
+		require /admin groups=admins,ops
+		require /api domain=example.com origin=simple
+		allow /api/public
+		}`
+	c := caddy.NewTestController("http", caddyfile)
+	NoError(t, setup(c))
+	mids := httpserver.GetConfig(c).Middleware()
+	if len(mids) == 0 {
+		t.Errorf("no middlewares created")
+	}
+	middleware := mids[len(mids)-1](nil).(*CaddyHandler)
+	Equal(t, []accessRule{
+		{path: "/admin", requirements: login.AccessRequirements{Groups: []string{"admins", "ops"}}},
+		{path: "/api", requirements: login.AccessRequirements{Domains: []string{"example.com"}, Origins: []string{"simple"}}},
+		{path: "/api/public", anonymous: true},
+	}, middleware.rules)
+
+	for _, caddyfile := range []string{
+		`logsrv {
+			require admin
+		}`,
+		`logsrv {
+			allow /public groups=admins
+		}`,
+		`logsrv {
+			require /admin role=admin
+		}`,
+	} {
+		Error(t, setup(caddy.NewTestController("http", caddyfile)), caddyfile)
+	}
+}
@@ -0,0 +1,306 @@
+// Package caddy2 provides logsrv as http handler module for Caddy v2
+package caddy2
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/pchchv/logsrv/logging"
+	"github.com/pchchv/logsrv/login"
+
+	// Import all backends, packaged with the caddy module
+	_ "github.com/pchchv/logsrv/htpasswd"
+	_ "github.com/pchchv/logsrv/httpupstream"
+	_ "github.com/pchchv/logsrv/ldap"
+	_ "github.com/pchchv/logsrv/oauth2"
+	_ "github.com/pchchv/logsrv/osiam"
+)
+
+func init() {
+	caddy.RegisterModule(Middleware{})
+	httpcaddyfile.RegisterHandlerDirective("login", parseCaddyfile)
+}
+
+// The stores of files are shared by the handlers with the same store file.
+// Caddy provisions a new config, before it cleans up the previous one,
+// so a second store on the file would see stale entries or, for bolt, wait for the lock of the file.
+var stores = caddy.NewUsagePool()
+
+// Middleware serves the login resource and makes the user of a valid token
+// available to other handlers by the placeholders {http.auth.user.*}
+type Middleware struct {
+	// The parameters of logsrv with the names of the command line flags,
+	// where '_' can be used instead of '-', e.g. {"login_path": "/login", "simple": "bob=secret"}.
+	// Repeatable parameters take a list, e.g. {"oidc": ["name=a,..", "name=b,.."]}
+	Options map[string]OptionValues `json:"options,omitempty"`
+
+	config  *login.Config
+	handler *login.Handler
+}
+
+// OptionValues are the values of a parameter, in JSON a single string or a list of strings
+type OptionValues []string
+
+// UnmarshalJSON accepts a string or a list of strings
+func (v *OptionValues) UnmarshalJSON(b []byte) error {
+	var value string
+	if err := json.Unmarshal(b, &value); err == nil {
+		*v = OptionValues{value}
+		return nil
+	}
+	var values []string
+	if err := json.Unmarshal(b, &values); err != nil {
+		return fmt.Errorf("option has to be a string or a list of strings: %v", err)
+	}
+	*v = values
+	return nil
+}
+
+// MarshalJSON returns a single value as string
+func (v OptionValues) MarshalJSON() ([]byte, error) {
+	if len(v) == 1 {
+		return json.Marshal(v[0])
+	}
+	return json.Marshal([]string(v))
+}
+
+// CaddyModule returns the Caddy module information
+func (Middleware) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.login",
+		New: func() caddy.Module { return new(Middleware) },
+	}
+}
+
+// Provision maps the options onto the login config and creates the login handler
+func (m *Middleware) Provision(ctx caddy.Context) error {
+	config, err := configFromOptions(m.Options)
+	if err != nil {
+		return err
+	}
+	// the trusted proxies and the audit log are process wide, like the logger
+	if err := logging.SetTrustedProxies(config.TrustedProxies); err != nil {
+		return err
+	}
+	if config.AuditLog != "" {
+		// the audit log of the last provisioned handler with audit_log is used
+		auditLog, err := logging.OpenAuditLog(config.AuditLog, config.AuditLogMaxSize, config.AuditLogMaxBackups)
+		if err != nil {
+			return err
+		}
+		if err := logging.SetAuditLog(auditLog); err != nil {
+			return err
+		}
+	}
+	store, err := sharedStore(config)
+	if err != nil {
+		return err
+	}
+	m.config = config
+	m.handler, err = login.NewHandlerWithStore(config, store)
+	if err != nil {
+		store.Close()
+	}
+	return err
+}
+
+// Cleanup closes the store of the handler, when the config is unloaded
+func (m *Middleware) Cleanup() error {
+	if m.handler == nil {
+		return nil
+	}
+	return m.handler.Close()
+}
+
+// Returns the store of the config, where the stores of files are taken from the pool
+func sharedStore(config *login.Config) (login.Store, error) {
+	if config.Store == "" || config.Store == "memory" {
+		return login.NewStore(config)
+	}
+	key := config.Store + ":" + config.StoreFile
+	value, _, err := stores.LoadOrNew(key, func() (caddy.Destructor, error) {
+		store, err := login.NewStore(config)
+		if err != nil {
+			return nil, err
+		}
+		return storeDestructor{store}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &pooledStore{Store: value.(storeDestructor).Store, key: key}, nil
+}
+
+// A store of the pool, which is closed after the last handler using it
+type storeDestructor struct {
+	login.Store
+}
+
+func (d storeDestructor) Destruct() error {
+	return d.Store.Close()
+}
+
+// The store of one handler, which releases the store of the pool on close
+type pooledStore struct {
+	login.Store
+	key string
+}
+
+func (s *pooledStore) Close() error {
+	_, err := stores.Delete(s.key)
+	return err
+}
+
+// Validate checks the provisioned config
+func (m *Middleware) Validate() error {
+	if m.config == nil || m.handler == nil {
+		return fmt.Errorf("login handler is not provisioned")
+	}
+	if !strings.HasPrefix(m.config.LoginPath, "/") {
+		return fmt.Errorf("login_path has to be an absolute path, but was %q", m.config.LoginPath)
+	}
+	if m.config.JwtExpiry <= 0 {
+		return fmt.Errorf("jwt_expiry has to be positive, but was %v", m.config.JwtExpiry)
+	}
+	return nil
+}
+
+func (m *Middleware) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	userInfo, valid := m.handler.GetToken(r)
+	if !valid && m.handler.HasRevokedToken(r) {
+		// remove the revoked token, so that it is not accepted by other handlers
+		r = withoutToken(r, m.config.CookieName)
+	}
+	if valid {
+		if repl, ok := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer); ok {
+			repl.Set("http.auth.user.id", userInfo.Sub)
+			repl.Set("http.auth.user.email", userInfo.Email)
+			repl.Set("http.auth.user.name", userInfo.Name)
+			repl.Set("http.auth.user.origin", userInfo.Origin)
+			repl.Set("http.auth.user.groups", strings.Join(userInfo.Groups, ","))
+		}
+	}
+	if strings.HasPrefix(r.URL.Path, m.config.LoginPath) {
+		m.handler.ServeHTTP(w, r)
+		return nil
+	}
+	return next.ServeHTTP(w, r)
+}
+
+// UnmarshalCaddyfile sets up the handler from Caddyfile tokens. Syntax:
+//
+//	login {
+//	    <parameter> <value>
+//	    ...
+//	}
+//
+// Repeatable parameters, e.g. oidc, saml or backends, can be given multiple times.
+func (m *Middleware) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+		for d.NextBlock(0) {
+			name := d.Val()
+			var value string
+			if !d.Args(&value) {
+				return d.ArgErr()
+			}
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+			if m.Options == nil {
+				m.Options = map[string]OptionValues{}
+			}
+			m.Options[name] = append(m.Options[name], value)
+		}
+	}
+	return nil
+}
+
+func parseCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	m := new(Middleware)
+	err := m.UnmarshalCaddyfile(h.Dispenser)
+	return m, err
+}
+
+// Maps the options onto the login config by the command line flags.
+// Like the Caddy v1 plugin, the jwt secret is shared with other handlers by the environment variable JWT_SECRET:
+// a secret of the environment is used, unless one is configured, and the secret is exported, if the variable is not set.
+func configFromOptions(options map[string]OptionValues) (*login.Config, error) {
+	cfg := login.DefaultConfig()
+	cfg.Host = ""
+	cfg.Port = ""
+	cfg.LogLevel = ""
+	fs := flag.NewFlagSet("logsrv-config", flag.ContinueOnError)
+	cfg.ConfigureFlagSet(fs)
+	// sorted for deterministic errors on multiple invalid options
+	names := make([]string, 0, len(options))
+	for name := range options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	secretProvidedByConfig := false
+	for _, name := range names {
+		flagName := strings.Replace(name, "_", "-", -1)
+		f := fs.Lookup(flagName)
+		if f == nil {
+			return nil, fmt.Errorf("unknown parameter for login: %v", name)
+		}
+		for _, value := range options[name] {
+			if err := f.Value.Set(value); err != nil {
+				return nil, fmt.Errorf("invalid value for parameter %v: %v", name, value)
+			}
+		}
+		if flagName == "jwt-secret" || flagName == "jwt-secret-file" {
+			secretProvidedByConfig = true
+		}
+	}
+	if err := cfg.ResolveFileReferences(); err != nil {
+		return nil, err
+	}
+	secretFromEnv, secretFromEnvWasSetBefore := os.LookupEnv("JWT_SECRET")
+	if !secretProvidedByConfig && secretFromEnvWasSetBefore {
+		cfg.JwtSecret = secretFromEnv
+	}
+	if !secretFromEnvWasSetBefore {
+		// populate the secret to other handlers and instances,
+		// but do not change an environment variable, which somebody has set
+		os.Setenv("JWT_SECRET", cfg.JwtSecret)
+	}
+	return cfg, nil
+}
+
+// Returns a copy of the request without the jwt, i.e. the named cookie and a bearer token
+func withoutToken(r *http.Request, name string) *http.Request {
+	cookies := r.Cookies()
+	r = r.Clone(r.Context())
+	r.Header.Del("Cookie")
+	if login.BearerToken(r) != "" {
+		r.Header.Del("Authorization")
+	}
+	for _, c := range cookies {
+		if c.Name != name {
+			r.AddCookie(c)
+		}
+	}
+	return r
+}
+
+// Interface guards
+var (
+	_ caddy.Provisioner           = (*Middleware)(nil)
+	_ caddy.Validator             = (*Middleware)(nil)
+	_ caddy.CleanerUpper          = (*Middleware)(nil)
+	_ caddyhttp.MiddlewareHandler = (*Middleware)(nil)
+	_ caddyfile.Unmarshaler       = (*Middleware)(nil)
+)
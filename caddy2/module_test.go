@@ -0,0 +1,281 @@
+package caddy2
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/pchchv/logsrv/logging"
+	"github.com/pchchv/logsrv/login"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware_CaddyModule(t *testing.T) {
+	info := Middleware{}.CaddyModule()
+	Equal(t, caddy.ModuleID("http.handlers.login"), info.ID)
+	IsType(t, &Middleware{}, info.New())
+}
+
+func TestMiddleware_UnmarshalCaddyfile(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`login {
+		simple bob=secret
+		login_path /auth/login
+		jwt_expiry 2h
+	}`)
+	m := Middleware{}
+	NoError(t, m.UnmarshalCaddyfile(d))
+	Equal(t, map[string]OptionValues{
+		"simple":     {"bob=secret"},
+		"login_path": {"/auth/login"},
+		"jwt_expiry": {"2h"},
+	}, m.Options)
+}
+
+func TestMiddleware_UnmarshalCaddyfile_RepeatedParameters(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`login {
+		simple bob=secret
+		github name=gh1,client_id=a,client_secret=b
+		github name=gh2,client_id=c,client_secret=d
+	}`)
+	m := Middleware{}
+	NoError(t, m.UnmarshalCaddyfile(d))
+	Equal(t, OptionValues{"name=gh1,client_id=a,client_secret=b", "name=gh2,client_id=c,client_secret=d"}, m.Options["github"])
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	NoError(t, m.Provision(ctx))
+	Equal(t, map[string]string{"provider": "github", "client_id": "a", "client_secret": "b"}, m.config.Oauth["gh1"])
+	Equal(t, map[string]string{"provider": "github", "client_id": "c", "client_secret": "d"}, m.config.Oauth["gh2"])
+}
+
+func TestOptionValues_JSON(t *testing.T) {
+	m := Middleware{}
+	NoError(t, json.Unmarshal([]byte(`{"options": {"simple": "bob=secret", "oidc": ["name=a", "name=b"]}}`), &m))
+	Equal(t, map[string]OptionValues{
+		"simple": {"bob=secret"},
+		"oidc":   {"name=a", "name=b"},
+	}, m.Options)
+
+	b, err := json.Marshal(m)
+	NoError(t, err)
+	JSONEq(t, `{"options": {"simple": "bob=secret", "oidc": ["name=a", "name=b"]}}`, string(b))
+
+	Error(t, json.Unmarshal([]byte(`{"options": {"simple": 42}}`), &Middleware{}))
+}
+
+func TestMiddleware_UnmarshalCaddyfile_Errors(t *testing.T) {
+	for _, input := range []string{
+		`login /path {
+			simple bob=secret
+		}`,
+		`login {
+			simple
+		}`,
+		`login {
+			simple bob=secret alice=secret
+		}`,
+	} {
+		m := Middleware{}
+		Error(t, m.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)), input)
+	}
+}
+
+func TestMiddleware_Provision(t *testing.T) {
+	m := provisioned(t, map[string]string{
+		"simple":           "bob=secret",
+		"login_path":       "/auth/login",
+		"jwt_expiry":       "2h",
+		"cookie-name":      "token",
+		"cookie_http_only": "false",
+	})
+	NoError(t, m.Validate())
+	Equal(t, "/auth/login", m.config.LoginPath)
+	Equal(t, 2*time.Hour, m.config.JwtExpiry)
+	Equal(t, "token", m.config.CookieName)
+	False(t, m.config.CookieHTTPOnly)
+	Equal(t, login.Options{"simple": map[string]string{"bob": "secret"}}, m.config.Backends)
+	Equal(t, "", m.config.Host)
+}
+
+func TestMiddleware_Provision_SecretFromEnv(t *testing.T) {
+	defer os.Unsetenv("JWT_SECRET")
+
+	// the secret is exported for other handlers
+	os.Unsetenv("JWT_SECRET")
+	m := provisioned(t, map[string]string{"simple": "bob=secret"})
+	Equal(t, login.DefaultConfig().JwtSecret, m.config.JwtSecret)
+	Equal(t, m.config.JwtSecret, os.Getenv("JWT_SECRET"))
+
+	// a secret of the environment is used by all instances
+	os.Setenv("JWT_SECRET", "shared")
+	m = provisioned(t, map[string]string{"simple": "bob=secret"})
+	Equal(t, "shared", m.config.JwtSecret)
+
+	// a configured secret wins, but does not change the environment
+	m = provisioned(t, map[string]string{"simple": "bob=secret", "jwt_secret": "own"})
+	Equal(t, "own", m.config.JwtSecret)
+	Equal(t, "shared", os.Getenv("JWT_SECRET"))
+}
+
+func TestMiddleware_Provision_AuditLog(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "audit.log")
+	m := provisioned(t, map[string]string{"simple": "bob=secret", "audit_log": file})
+	defer logging.SetAuditLog(nil)
+	r := httptest.NewRequest("POST", "/login", strings.NewReader("username=bob&password=wrong"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	NoError(t, m.ServeHTTP(httptest.NewRecorder(), withReplacer(r), failingNext(t)))
+	b, err := os.ReadFile(file)
+	NoError(t, err)
+	Contains(t, string(b), `"event":"login","outcome":"failure"`)
+}
+
+func TestMiddleware_Provision_SharedStore(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "store.db")
+	options := map[string]string{"simple": "bob=secret", "store": "bolt", "store_file": file}
+	// the next config is provisioned, while the previous one still holds the store
+	previous := provisioned(t, options)
+	next := provisioned(t, options)
+	NoError(t, previous.Cleanup())
+	_, loaded, err := stores.LoadOrNew("bolt:"+file, func() (caddy.Destructor, error) {
+		return nil, errors.New("store was closed")
+	})
+	NoError(t, err)
+	True(t, loaded)
+	_, err = stores.Delete("bolt:" + file)
+	NoError(t, err)
+
+	// the file is released with the last handler
+	NoError(t, next.Cleanup())
+	s, err := login.NewBoltStore(file)
+	NoError(t, err)
+	NoError(t, s.Close())
+	NoError(t, (&Middleware{}).Cleanup())
+}
+
+func TestMiddleware_Provision_Errors(t *testing.T) {
+	for _, options := range []map[string]string{
+		{"simple": "bob=secret", "unknown": "value"},
+		{"simple": "bob=secret", "jwt_expiry": "soon"},
+		{"simple": "bob=secret", "jwt_secret_file": "/does/not/exist"},
+		// no backend
+		{"login_path": "/login"},
+	} {
+		ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+		m := Middleware{Options: optionValues(options)}
+		Error(t, m.Provision(ctx), options)
+		cancel()
+	}
+}
+
+func TestMiddleware_Validate(t *testing.T) {
+	Error(t, (&Middleware{}).Validate())
+	m := provisioned(t, map[string]string{"simple": "bob=secret", "login_path": "login"})
+	Error(t, m.Validate())
+	m = provisioned(t, map[string]string{"simple": "bob=secret", "jwt_expiry": "0s"})
+	Error(t, m.Validate())
+}
+
+func TestMiddleware_ServeHTTP(t *testing.T) {
+	m := provisioned(t, map[string]string{"simple": "bob=secret", "cookie_secure": "false"})
+	// login
+	recorder := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/login", strings.NewReader("username=bob&password=secret"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	NoError(t, m.ServeHTTP(recorder, withReplacer(r), failingNext(t)))
+	Equal(t, 200, recorder.Code)
+	token := recorder.Body.String()
+
+	// requests with the token get the placeholders
+	r = withReplacer(httptest.NewRequest("GET", "/app", nil))
+	r.AddCookie(&http.Cookie{Name: m.config.CookieName, Value: token})
+	called := false
+	NoError(t, m.ServeHTTP(httptest.NewRecorder(), r, caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		called = true
+		repl := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+		user, _ := repl.Get("http.auth.user.id")
+		Equal(t, "bob", user)
+		origin, _ := repl.Get("http.auth.user.origin")
+		Equal(t, "simple", origin)
+		return nil
+	})))
+	True(t, called)
+
+	// requests without a token are passed without placeholders
+	r = withReplacer(httptest.NewRequest("GET", "/app", nil))
+	NoError(t, m.ServeHTTP(httptest.NewRecorder(), r, caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		_, exist := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer).Get("http.auth.user.id")
+		False(t, exist)
+		return nil
+	})))
+}
+
+func TestMiddleware_ServeHTTP_RevokedToken(t *testing.T) {
+	m := provisioned(t, map[string]string{"simple": "bob=secret"})
+	recorder := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/login", strings.NewReader("username=bob&password=secret"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	NoError(t, m.ServeHTTP(recorder, withReplacer(r), failingNext(t)))
+	token := recorder.Body.String()
+	r = withReplacer(httptest.NewRequest("POST", "/login/revoke", strings.NewReader("token="+token)))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder = httptest.NewRecorder()
+	NoError(t, m.ServeHTTP(recorder, r, failingNext(t)))
+	Equal(t, 200, recorder.Code)
+
+	r = withReplacer(httptest.NewRequest("GET", "/app", nil))
+	r.AddCookie(&http.Cookie{Name: m.config.CookieName, Value: token})
+	r.AddCookie(&http.Cookie{Name: "other", Value: "value"})
+	NoError(t, m.ServeHTTP(httptest.NewRecorder(), r, caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		_, err := r.Cookie(m.config.CookieName)
+		Equal(t, http.ErrNoCookie, err)
+		c, err := r.Cookie("other")
+		NoError(t, err)
+		Equal(t, "value", c.Value)
+		return nil
+	})))
+
+	// a revoked bearer token is removed as well
+	r = withReplacer(httptest.NewRequest("GET", "/app", nil))
+	r.Header.Set("Authorization", "Bearer "+token)
+	NoError(t, m.ServeHTTP(httptest.NewRecorder(), r, caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		Empty(t, r.Header.Get("Authorization"))
+		return nil
+	})))
+}
+
+func provisioned(t *testing.T, options map[string]string) *Middleware {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	m := &Middleware{Options: optionValues(options)}
+	NoError(t, m.Provision(ctx))
+	return m
+}
+
+func optionValues(options map[string]string) map[string]OptionValues {
+	values := map[string]OptionValues{}
+	for name, value := range options {
+		values[name] = OptionValues{value}
+	}
+	return values
+}
+
+func withReplacer(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), caddy.ReplacerCtxKey, caddy.NewReplacer()))
+}
+
+func failingNext(t *testing.T) caddyhttp.Handler {
+	return caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		t.Error("next handler must not be called")
+		return nil
+	})
+}
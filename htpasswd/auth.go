@@ -9,12 +9,14 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
 
 	auth "github.com/abbot/go-http-auth"
 	"github.com/pchchv/logsrv/logging"
+	"github.com/pchchv/logsrv/login"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -27,9 +29,13 @@ type File struct {
 
 // htpassword authenticater
 type Auth struct {
-	filenames  []File
-	userHash   map[string]string
+	filenames []File
+	userHash  map[string]string
+	// index of the file, which contains the hash of the user
+	userFile   map[string]int
 	muUserHash sync.RWMutex
+	// algorithm to replace weak hashes on login, or empty to keep them
+	rehash string
 }
 
 // Creates an htpassword authenticater
@@ -45,8 +51,17 @@ func NewAuth(filenames []string) (*Auth, error) {
 }
 
 func (a *Auth) parse() error {
+	a.muUserHash.Lock()
+	defer a.muUserHash.Unlock()
+	return a.parseLocked()
+}
+
+// Reads the htpasswd files, the caller has to hold the write lock
+func (a *Auth) parseLocked() error {
 	tmpUserHash := map[string]string{}
-	tmpFilenames := a.filenames
+	tmpUserFile := map[string]int{}
+	// the files are swapped as a copy, because reloadIfChanged reads them without the write lock
+	tmpFilenames := append([]File(nil), a.filenames...)
 	for i, filename := range a.filenames {
 		r, err := os.Open(filename.name)
 		if err != nil {
@@ -77,12 +92,12 @@ func (a *Auth) parse() error {
 				logging.Logger.Warnf("Found duplicate entry for user: (%v)", record[0])
 			}
 			tmpUserHash[record[0]] = record[1]
+			tmpUserFile[record[0]] = i
 		}
 	}
-	a.muUserHash.Lock()
 	a.userHash = tmpUserHash
+	a.userFile = tmpUserFile
 	a.filenames = tmpFilenames
-	a.muUserHash.Unlock()
 	return nil
 }
 
@@ -90,28 +105,174 @@ func (a *Auth) parse() error {
 func (a *Auth) Authenticate(username, password string) (bool, error) {
 	reloadIfChanged(a)
 	a.muUserHash.RLock()
-	defer a.muUserHash.RUnlock()
-	if hash, exist := a.userHash[username]; exist {
-		h := []byte(hash)
-		p := []byte(password)
-		if strings.HasPrefix(hash, "$2y$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2a$") {
-			matchErr := bcrypt.CompareHashAndPassword(h, p)
-			return (matchErr == nil), nil
-		}
-		if strings.HasPrefix(hash, "{SHA}") {
-			return compareSha(h, p), nil
-		}
-		if strings.HasPrefix(hash, "$apr1$") {
-			return compareMD5(h, p), nil
+	hash, exist := a.userHash[username]
+	a.muUserHash.RUnlock()
+	if !exist || isDisabled(hash) {
+		return false, nil
+	}
+	authenticated, known := compareHash([]byte(hash), []byte(password))
+	if !known {
+		return false, fmt.Errorf("unknown algorithm for user %q", username)
+	}
+	if authenticated && a.rehash != "" && isWeakHash(hash) {
+		if err := a.rehashUser(username, password, hash); err != nil {
+			logging.Logger.WithError(err).Warnf("Could not rehash the password of user %q", username)
 		}
+	}
+	return authenticated, nil
+}
+
+// Replaces the password of the user, if the old password matches.
+// The new hash uses the rehash algorithm, bcrypt by default.
+func (a *Auth) ChangePassword(username, oldPassword, newPassword string) (bool, error) {
+	reloadIfChanged(a)
+	a.muUserHash.RLock()
+	hash, exist := a.userHash[username]
+	a.muUserHash.RUnlock()
+	if !exist || isDisabled(hash) {
+		return false, nil
+	}
+	authenticated, known := compareHash([]byte(hash), []byte(oldPassword))
+	if !known {
 		return false, fmt.Errorf("unknown algorithm for user %q", username)
 	}
-	return false, nil
+	if !authenticated {
+		return false, nil
+	}
+	newHash, err := a.hashPassword(newPassword)
+	if err != nil {
+		return false, err
+	}
+	replaced, err := a.replaceHash(username, hash, newHash)
+	if err != nil {
+		return false, err
+	}
+	if !replaced {
+		return false, fmt.Errorf("password of user %q was changed concurrently", username)
+	}
+	return true, nil
+}
+
+// Compares the hash with the password.
+// Returns false as second value, if the algorithm of the hash is unknown.
+func compareHash(h, p []byte) (authenticated, known bool) {
+	hash := string(h)
+	switch {
+	case strings.HasPrefix(hash, "$2y$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2a$"):
+		return bcrypt.CompareHashAndPassword(h, p) == nil, true
+	case strings.HasPrefix(hash, "$argon2id$"):
+		return compareArgon2id(h, p), true
+	case strings.HasPrefix(hash, "$scrypt$"):
+		return compareScrypt(h, p), true
+	case strings.HasPrefix(hash, "$5$") || strings.HasPrefix(hash, "$6$"):
+		return compareShaCrypt(h, p), true
+	case strings.HasPrefix(hash, "{SHA}"):
+		return compareSha(h, p), true
+	case strings.HasPrefix(hash, "{SSHA}"):
+		return compareSsha(h, p), true
+	case strings.HasPrefix(hash, "$apr1$"):
+		return compareMD5(h, p), true
+	}
+	return false, false
+}
+
+// Replaces the weak hash of the user by a new hash
+func (a *Auth) rehashUser(username, password, weakHash string) error {
+	newHash, err := generateHash(a.rehash, password)
+	if err != nil {
+		return err
+	}
+	replaced, err := a.replaceHash(username, weakHash, newHash)
+	if replaced {
+		logging.Logger.Infof("Rehashed the password of user %q with %v", username, a.rehash)
+	}
+	return err
+}
+
+// Replaces the old hash of the user by the new hash in memory and in the file of the user.
+// It returns false, if the hash of the user is no longer the old hash, e.g. it was changed in the meantime.
+func (a *Auth) replaceHash(username, oldHash, newHash string) (bool, error) {
+	a.muUserHash.Lock()
+	defer a.muUserHash.Unlock()
+	i, exist := a.userFile[username]
+	if !exist || a.userHash[username] != oldHash {
+		return false, nil
+	}
+	replaced, err := rewriteFile(a.filenames[i].name, func(lines []string) ([]string, bool) {
+		replaced := false
+		for j, line := range lines {
+			if hash, ok := userLine(line, username); ok && hash == oldHash {
+				lines[j] = username + ":" + newHash
+				replaced = true
+			}
+		}
+		return lines, replaced
+	})
+	if err != nil || !replaced {
+		// without replacement, the file was changed by someone else
+		return false, err
+	}
+	if fileInfo, err := os.Stat(a.filenames[i].name); err == nil {
+		// the file does not need to be parsed again
+		files := append([]File(nil), a.filenames...)
+		files[i].modTime = fileInfo.ModTime()
+		a.filenames = files
+	}
+	a.userHash[username] = newHash
+	return true, nil
+}
+
+// Changes the lines of the file, which is locked against other writers meanwhile.
+// If the change reports a modification, the changed lines are written atomically.
+func rewriteFile(name string, change func(lines []string) ([]string, bool)) (bool, error) {
+	name, err := filepath.EvalSymlinks(name)
+	if err != nil {
+		return false, err
+	}
+	unlock, err := lockFile(name)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+	content, err := os.ReadFile(name)
+	if err != nil {
+		return false, err
+	}
+	lines, changed := change(strings.Split(string(content), "\n"))
+	if !changed {
+		return false, nil
+	}
+	return true, login.WriteFileAtomically(name, []byte(strings.Join(lines, "\n")))
+}
+
+// Returns the hash of the line, if it is the entry of the user
+func userLine(line, username string) (string, bool) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, username+":") {
+		return "", false
+	}
+	return strings.TrimSpace(line[len(username)+1:]), true
+}
+
+// Checks, if the user is in the htpasswd files and not disabled
+func (a *Auth) UserExists(username string) bool {
+	reloadIfChanged(a)
+	a.muUserHash.RLock()
+	defer a.muUserHash.RUnlock()
+	hash, exist := a.userHash[username]
+	return exist && !isDisabled(hash)
+}
+
+// Returns the files with their modification time of the last parsing
+func (a *Auth) files() []File {
+	a.muUserHash.RLock()
+	defer a.muUserHash.RUnlock()
+	return a.filenames
 }
 
 // Reload htpasswd file if it changed during current run
 func reloadIfChanged(a *Auth) {
-	for _, file := range a.filenames {
+	for _, file := range a.files() {
 		fileInfo, err := os.Stat(file.name)
 		if err != nil {
 			break
@@ -142,3 +303,15 @@ func compareMD5(hashedPassword, password []byte) bool {
 	salt := parts[2]
 	return 1 == subtle.ConstantTimeCompare(hashedPassword, auth.MD5Crypt(password, salt, magic))
 }
+
+// Checks, that the htpasswd files can still be read
+func (a *Auth) CheckHealth() error {
+	for _, file := range a.files() {
+		f, err := os.Open(file.name)
+		if err != nil {
+			return err
+		}
+		f.Close()
+	}
+	return nil
+}
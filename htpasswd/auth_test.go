@@ -2,6 +2,8 @@ package htpasswd
 
 import (
 	"os"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -12,6 +14,12 @@ import (
 const testfile = `bob-md5:$apr1$IDZSCL/o$N68zaFDDRivjour94OVeB.
 bob-bcrypt:$2y$05$Hw6y1sFwh6CdwiPOKFMYj..xVSQWI3wzyQvt5th392ig8RLmeLU.6
 bob-sha:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=
+bob-ssha:{SSHA}Wcm1xEisNjqp921ALcHfuQ7avFdzYWx0MTIzNA==
+bob-sha256:$5$abcdefgh$gruCpC7VkOTspMQTTSAR8mtlO9Upms.fwqE5y16JVM.
+bob-sha256-rounds:$5$rounds=1000$abcdefgh$XIppta5rT8zJUu4vAOKQXrwq9uY4Ijlz6VjyOmkx5i4
+bob-sha512:$6$abcdefgh$ltjgWl6579NluT/Vi1nwEvcil.G5Nbc4NiXZaNGStk8PSwGfQv72N2CKPPrVACtLtip/cZ/1GM/O6IND4WQhG.
+bob-argon2id:$argon2id$v=19$m=1024,t=1,p=1$c29tZXNhbHQxMjM0NTY3OA$e+6XUn4tZ1qj8Jp7tR6wuTLzkIsHGwPybdZNLeqcc8w
+bob-scrypt:$scrypt$ln=10,r=8,p=1$c2FsdHNhbHQxMjM0NTY3OA$VQ6wGxm7W7KL4CESjI3DP4XP8s9BuOl4CvP/IRuXBYk
 # a comment
 bob-foo:{fooo}sdcsdcsdc/BfQ=
 `
@@ -19,7 +27,7 @@ bob-foo:{fooo}sdcsdcsdc/BfQ=
 func TestAuth_Hashes(t *testing.T) {
 	auth, err := NewAuth(writeTmpfile(testfile))
 	NoError(t, err)
-	testUsers := []string{"bob-md5", "bob-bcrypt", "bob-sha"}
+	testUsers := []string{"bob-md5", "bob-bcrypt", "bob-sha", "bob-ssha", "bob-sha256", "bob-sha256-rounds", "bob-sha512", "bob-argon2id", "bob-scrypt"}
 	for _, name := range testUsers {
 		t.Run(name, func(t *testing.T) {
 			authenticated, err := auth.Authenticate(name, "secret")
@@ -122,6 +130,77 @@ func TestAuth_Hashes_UnknownAlgoError(t *testing.T) {
 	False(t, authenticated)
 }
 
+func Test_shaCrypt(t *testing.T) {
+	// test vectors of the specification
+	True(t, compareShaCrypt([]byte("$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5"), []byte("Hello world!")))
+	True(t, compareShaCrypt([]byte("$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1"), []byte("Hello world!")))
+	True(t, compareShaCrypt([]byte("$5$rounds=10000$saltstringsaltst$3xv.VbSHBb41AL9AvLeujZkZRBAwqFMz2.opqey6IcA"), []byte("Hello world!")))
+	False(t, compareShaCrypt([]byte("$5$rounds=x$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5"), []byte("Hello world!")))
+	False(t, compareShaCrypt([]byte("$5$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5"), []byte("Hello world!")))
+}
+
+func TestAuth_BadFormats(t *testing.T) {
+	a, err := NewAuth(writeTmpfile(`bob-argon2id:$argon2id$v=19$m=0,t=1,p=1$c29tZXNhbHQxMjM0NTY3OA$e+6XUn4tZ1qj8Jp7tR6wuTLzkIsHGwPybdZNLeqcc8w
+bob-scrypt:$scrypt$ln=99,r=8,p=1$c2FsdHNhbHQxMjM0NTY3OA$VQ6wGxm7W7KL4CESjI3DP4XP8s9BuOl4CvP/IRuXBYk
+bob-ssha:{SSHA}Wcm1x`))
+	NoError(t, err)
+	for _, name := range []string{"bob-argon2id", "bob-scrypt", "bob-ssha"} {
+		authenticated, err := a.Authenticate(name, "secret")
+		NoError(t, err, name)
+		False(t, authenticated, name)
+	}
+}
+
+func TestAuth_Rehash(t *testing.T) {
+	for _, algorithm := range []string{RehashBcrypt, RehashArgon2id} {
+		t.Run(algorithm, func(t *testing.T) {
+			files := writeTmpfile("# users\nbob:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\nalice:$apr1$IDZSCL/o$N68zaFDDRivjour94OVeB.\n")
+			NoError(t, os.Chmod(files[0], 0o640))
+			a, err := NewAuth(files)
+			NoError(t, err)
+			a.rehash = algorithm
+			// a wrong password does not change the hash
+			authenticated, err := a.Authenticate("bob", "XXXXX")
+			NoError(t, err)
+			False(t, authenticated)
+			Equal(t, "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=", a.userHash["bob"])
+			authenticated, err = a.Authenticate("bob", "secret")
+			NoError(t, err)
+			True(t, authenticated)
+			False(t, isWeakHash(a.userHash["bob"]))
+			content, err := os.ReadFile(files[0])
+			NoError(t, err)
+			Equal(t, "# users\nbob:"+a.userHash["bob"]+"\nalice:$apr1$IDZSCL/o$N68zaFDDRivjour94OVeB.\n", string(content))
+			fileInfo, err := os.Stat(files[0])
+			NoError(t, err)
+			Equal(t, os.FileMode(0o640), fileInfo.Mode().Perm())
+			// the new hash is accepted, also after a reload of the file
+			reloaded, err := NewAuth(files)
+			NoError(t, err)
+			for _, auth := range []*Auth{a, reloaded} {
+				authenticated, err = auth.Authenticate("bob", "secret")
+				NoError(t, err)
+				True(t, authenticated)
+				authenticated, err = auth.Authenticate("alice", "secret")
+				NoError(t, err)
+				True(t, authenticated)
+			}
+		})
+	}
+}
+
+func TestAuth_NoRehashByDefault(t *testing.T) {
+	files := writeTmpfile(`bob:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=`)
+	a, err := NewAuth(files)
+	NoError(t, err)
+	authenticated, err := a.Authenticate("bob", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+	content, err := os.ReadFile(files[0])
+	NoError(t, err)
+	Equal(t, `bob:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=`, string(content))
+}
+
 func writeTmpfile(contents ...string) []string {
 	var names []string
 	for _, curContent := range contents {
@@ -138,3 +217,82 @@ func writeTmpfile(contents ...string) []string {
 	}
 	return names
 }
+
+func TestAuth_ChangePassword(t *testing.T) {
+	files := writeTmpfile("bob:$apr1$IDZSCL/o$N68zaFDDRivjour94OVeB.\nalice:$apr1$IDZSCL/o$N68zaFDDRivjour94OVeB.\n")
+	a, err := NewAuth(files)
+	NoError(t, err)
+	changed, err := a.ChangePassword("bob", "XXXXX", "new-secret")
+	NoError(t, err)
+	False(t, changed)
+	changed, err = a.ChangePassword("unknown", "secret", "new-secret")
+	NoError(t, err)
+	False(t, changed)
+	changed, err = a.ChangePassword("bob", "secret", "new-secret")
+	NoError(t, err)
+	True(t, changed)
+	True(t, strings.HasPrefix(a.userHash["bob"], "$2a$"))
+	// the change is written to the file
+	reloaded, err := NewAuth(files)
+	NoError(t, err)
+	authenticated, err := reloaded.Authenticate("bob", "new-secret")
+	NoError(t, err)
+	True(t, authenticated)
+	authenticated, err = reloaded.Authenticate("bob", "secret")
+	NoError(t, err)
+	False(t, authenticated)
+	authenticated, err = reloaded.Authenticate("alice", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+}
+
+func TestAuth_ChangePassword_ConcurrentFileChange(t *testing.T) {
+	files := writeTmpfile("bob:$apr1$IDZSCL/o$N68zaFDDRivjour94OVeB.\n")
+	a, err := NewAuth(files)
+	NoError(t, err)
+	// the file is changed by someone else, without a change of the modification time
+	info, err := os.Stat(files[0])
+	NoError(t, err)
+	NoError(t, os.WriteFile(files[0], []byte("bob:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n"), 0o644))
+	NoError(t, os.Chtimes(files[0], info.ModTime(), info.ModTime()))
+	changed, err := a.ChangePassword("bob", "secret", "new-secret")
+	Error(t, err)
+	False(t, changed)
+	content, err := os.ReadFile(files[0])
+	NoError(t, err)
+	Equal(t, "bob:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n", string(content))
+}
+
+// Run with -race: the files are reloaded by readers, while the users are changed
+func TestAuth_ReloadConcurrently(t *testing.T) {
+	files := writeTmpfile("bob:$apr1$IDZSCL/o$N68zaFDDRivjour94OVeB.\n", "alice:$apr1$IDZSCL/o$N68zaFDDRivjour94OVeB.\n")
+	a, err := NewAuth(files)
+	NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				_, err := a.Authenticate("alice", "secret")
+				NoError(t, err)
+				a.UserExists("bob")
+				NoError(t, a.CheckHealth())
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for j := 0; j < 50; j++ {
+			// a new modification time forces the readers to parse the files again
+			modTime := time.Now().Add(time.Duration(j+1) * time.Second)
+			NoError(t, os.Chtimes(files[1], modTime, modTime))
+			NoError(t, a.SetDisabled("bob", j%2 == 0))
+		}
+	}()
+	wg.Wait()
+	True(t, a.UserExists("bob"))
+	True(t, a.UserExists("alice"))
+}
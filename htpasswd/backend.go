@@ -2,6 +2,7 @@ package htpasswd
 
 import (
 	"errors"
+	"fmt"
 	"strings"
 
 	"github.com/pchchv/logsrv/login"
@@ -19,7 +20,7 @@ func init() {
 	login.RegisterProvider(
 		&login.ProviderDescription{
 			Name:     ProviderName,
-			HelpText: "Htpasswd login backend opts: files=/path/to/pwdfile,/path/to/additionalfile",
+			HelpText: "Htpasswd login backend opts: file=/path/to/pwdfile;/path/to/additionalfile,rehash=bcrypt|argon2id",
 		},
 		BackendFactory)
 }
@@ -33,7 +34,17 @@ func BackendFactory(config map[string]string) (login.Backend, error) {
 	if len(files) == 0 {
 		return nil, errors.New(`missing parameter "file" for htpasswd provider`)
 	}
-	return NewBackend(files)
+	backend, err := NewBackend(files)
+	if err != nil {
+		return nil, err
+	}
+	if algorithm, exist := config["rehash"]; exist {
+		if algorithm != RehashBcrypt && algorithm != RehashArgon2id {
+			return nil, fmt.Errorf("unknown rehash algorithm %q for htpasswd provider, has to be %v or %v", algorithm, RehashBcrypt, RehashArgon2id)
+		}
+		backend.auth.rehash = algorithm
+	}
+	return backend, nil
 }
 
 // Creates a new Backend and verifies the parameters.
@@ -55,3 +66,46 @@ func (sb *Backend) Authenticate(username, password string) (bool, model.UserInfo
 	}
 	return false, model.UserInfo{}, err
 }
+
+// Checks, that the user is still in the htpasswd files
+func (sb *Backend) ValidateUser(userInfo model.UserInfo) (bool, error) {
+	if userInfo.Origin != ProviderName {
+		return true, nil
+	}
+	return sb.auth.UserExists(userInfo.Sub), nil
+}
+
+// Changes the password of the user in the htpasswd file, which contains the user
+func (sb *Backend) ChangePassword(username, oldPassword, newPassword string) (bool, error) {
+	return sb.auth.ChangePassword(username, oldPassword, newPassword)
+}
+
+// Returns the users of the htpasswd files
+func (sb *Backend) Users() ([]login.ManagedUser, error) {
+	return sb.auth.Users(), nil
+}
+
+// Adds the user to the first htpasswd file
+func (sb *Backend) AddUser(username, password string) error {
+	return sb.auth.AddUser(username, password)
+}
+
+// Replaces the password of the user without the old password
+func (sb *Backend) SetPassword(username, password string) error {
+	return sb.auth.SetPassword(username, password)
+}
+
+// Disables or enables the user
+func (sb *Backend) SetDisabled(username string, disabled bool) error {
+	return sb.auth.SetDisabled(username, disabled)
+}
+
+// Removes the user from the htpasswd files
+func (sb *Backend) DeleteUser(username string) error {
+	return sb.auth.DeleteUser(username)
+}
+
+// Checks, that the htpasswd files can be read
+func (sb *Backend) CheckHealth() error {
+	return sb.auth.CheckHealth()
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/pchchv/logsrv/login"
+	"github.com/pchchv/logsrv/model"
 	. "github.com/stretchr/testify/assert"
 )
 
@@ -48,6 +49,15 @@ func TestSetup_Error(t *testing.T) {
 	NotNil(t, p)
 	_, err := p(map[string]string{})
 	Error(t, err)
+	_, err = p(map[string]string{"file": writeTmpfile(testfile)[0], "rehash": "md5"})
+	Error(t, err)
+}
+
+func TestSetup_Rehash(t *testing.T) {
+	p, _ := login.GetProvider(ProviderName)
+	backend, err := p(map[string]string{"file": writeTmpfile(testfile)[0], "rehash": RehashArgon2id})
+	NoError(t, err)
+	Equal(t, RehashArgon2id, backend.(*Backend).auth.rehash)
 }
 
 func TestSimpleBackend_Authenticate(t *testing.T) {
@@ -67,6 +77,21 @@ func TestSimpleBackend_Authenticate(t *testing.T) {
 	NoError(t, err)
 }
 
+func TestBackend_ValidateUser(t *testing.T) {
+	backend, err := NewBackend(writeTmpfile(testfile))
+	NoError(t, err)
+	valid, err := backend.ValidateUser(model.UserInfo{Sub: "bob-bcrypt", Origin: ProviderName})
+	NoError(t, err)
+	True(t, valid)
+	valid, err = backend.ValidateUser(model.UserInfo{Sub: "unknown", Origin: ProviderName})
+	NoError(t, err)
+	False(t, valid)
+	// users of other backends are not checked
+	valid, err = backend.ValidateUser(model.UserInfo{Sub: "unknown", Origin: "github"})
+	NoError(t, err)
+	True(t, valid)
+}
+
 func modTime(f string) time.Time {
 	fileInfo, err := os.Stat(f)
 	if err != nil {
@@ -74,3 +99,12 @@ func modTime(f string) time.Time {
 	}
 	return fileInfo.ModTime()
 }
+
+func TestBackend_CheckHealth(t *testing.T) {
+	files := writeTmpfile(testfile)
+	backend, err := NewBackend(files)
+	NoError(t, err)
+	NoError(t, backend.CheckHealth())
+	NoError(t, os.Remove(files[0]))
+	Error(t, backend.CheckHealth())
+}
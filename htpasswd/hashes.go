@@ -0,0 +1,122 @@
+package htpasswd
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Algorithms for the rehash of weak hashes
+const (
+	RehashBcrypt   = "bcrypt"
+	RehashArgon2id = "argon2id"
+)
+
+// Parameters of new argon2id hashes, as recommended by RFC 9106 for memory constrained environments
+const (
+	argon2Time    = 3
+	argon2Memory  = 64 * 1024
+	argon2Threads = 4
+	argon2KeyLen  = 32
+	argon2SaltLen = 16
+)
+
+// Returns true for hashes, which should not be used any longer
+func isWeakHash(hash string) bool {
+	return strings.HasPrefix(hash, "{SHA}") || strings.HasPrefix(hash, "{SSHA}") || strings.HasPrefix(hash, "$apr1$")
+}
+
+// Creates a new hash of the password with the algorithm
+func generateHash(algorithm, password string) (string, error) {
+	switch algorithm {
+	case RehashBcrypt:
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		return string(hash), err
+	case RehashArgon2id:
+		salt := make([]byte, argon2SaltLen)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(password), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+			argon2.Version, argon2Memory, argon2Time, argon2Threads,
+			base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+	}
+	return "", fmt.Errorf("unknown rehash algorithm %q, has to be %v or %v", algorithm, RehashBcrypt, RehashArgon2id)
+}
+
+// Compares a {SSHA} hash, i.e. base64(sha1(password + salt) + salt)
+func compareSsha(hashedPassword, password []byte) bool {
+	decoded, err := base64.StdEncoding.DecodeString(string(hashedPassword[6:]))
+	if err != nil || len(decoded) <= sha1.Size {
+		return false
+	}
+	digest, salt := decoded[:sha1.Size], decoded[sha1.Size:]
+	d := sha1.New()
+	d.Write(password)
+	d.Write(salt)
+	return 1 == subtle.ConstantTimeCompare(digest, d.Sum(nil))
+}
+
+// Compares an $argon2id$v=19$m=65536,t=3,p=4$salt$hash hash
+func compareArgon2id(hashedPassword, password []byte) bool {
+	parts := strings.Split(string(hashedPassword), "$")
+	if len(parts) != 6 {
+		return false
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false
+	}
+	var memory, iterations uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &threads); err != nil || memory == 0 || iterations == 0 || threads == 0 {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return false
+	}
+	return 1 == subtle.ConstantTimeCompare(key, argon2.IDKey(password, salt, iterations, memory, threads, uint32(len(key))))
+}
+
+// Compares a $scrypt$ln=16,r=8,p=1$salt$hash hash in the format of passlib,
+// which uses base64 with '.' instead of '+' and without padding.
+func compareScrypt(hashedPassword, password []byte) bool {
+	parts := strings.Split(string(hashedPassword), "$")
+	if len(parts) != 5 {
+		return false
+	}
+	var ln, r, p int
+	if _, err := fmt.Sscanf(parts[2], "ln=%d,r=%d,p=%d", &ln, &r, &p); err != nil || ln < 1 || ln > 30 {
+		return false
+	}
+	salt, err := decodeAb64(parts[3])
+	if err != nil {
+		return false
+	}
+	key, err := decodeAb64(parts[4])
+	if err != nil || len(key) == 0 {
+		return false
+	}
+	derived, err := scrypt.Key(password, salt, 1<<ln, r, p, len(key))
+	if err != nil {
+		return false
+	}
+	return 1 == subtle.ConstantTimeCompare(key, derived)
+}
+
+func decodeAb64(s string) ([]byte, error) {
+	return base64.RawStdEncoding.DecodeString(strings.ReplaceAll(s, ".", "+"))
+}
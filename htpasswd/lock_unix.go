@@ -0,0 +1,25 @@
+//go:build unix
+
+package htpasswd
+
+import (
+	"os"
+	"syscall"
+)
+
+// Locks the file exclusively against other processes, e.g. a second instance of logsrv,
+// by an flock on a lock file next to it. The lock file is kept, because a removal would race with other lockers.
+func lockFile(name string) (unlock func(), err error) {
+	f, err := os.OpenFile(name+".lock", os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
@@ -0,0 +1,162 @@
+package htpasswd
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"hash"
+	"strconv"
+	"strings"
+)
+
+// SHA-crypt, as specified by Ulrich Drepper (https://www.akkadia.org/drepper/SHA-crypt.txt)
+const (
+	shaCryptRoundsDefault = 5000
+	shaCryptRoundsMin     = 1000
+	shaCryptRoundsMax     = 999999999
+	shaCryptSaltMax       = 16
+	shaCryptAlphabet      = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+)
+
+// Byte order of the final digest in the encoded hash, three bytes per group
+var (
+	sha256CryptOrder = [][]int{
+		{0, 10, 20}, {21, 1, 11}, {12, 22, 2}, {3, 13, 23}, {24, 4, 14},
+		{15, 25, 5}, {6, 16, 26}, {27, 7, 17}, {18, 28, 8}, {9, 19, 29}, {-1, 31, 30},
+	}
+	sha512CryptOrder = [][]int{
+		{0, 21, 42}, {22, 43, 1}, {44, 2, 23}, {3, 24, 45}, {25, 46, 4},
+		{47, 5, 26}, {6, 27, 48}, {28, 49, 7}, {50, 8, 29}, {9, 30, 51},
+		{31, 52, 10}, {53, 11, 32}, {12, 33, 54}, {34, 55, 13}, {56, 14, 35},
+		{15, 36, 57}, {37, 58, 16}, {59, 17, 38}, {18, 39, 60}, {40, 61, 19},
+		{62, 20, 41}, {-1, -1, 63},
+	}
+)
+
+// Compares a $5$ (SHA-256) or $6$ (SHA-512) hash with the password
+func compareShaCrypt(hashedPassword, password []byte) bool {
+	parts := strings.Split(string(hashedPassword), "$")
+	// "", "5", ["rounds=N",] salt, hash
+	if len(parts) != 4 && len(parts) != 5 {
+		return false
+	}
+	rounds := shaCryptRoundsDefault
+	if len(parts) == 5 {
+		if !strings.HasPrefix(parts[2], "rounds=") {
+			return false
+		}
+		r, err := strconv.Atoi(strings.TrimPrefix(parts[2], "rounds="))
+		if err != nil {
+			return false
+		}
+		rounds = r
+		if rounds < shaCryptRoundsMin {
+			rounds = shaCryptRoundsMin
+		}
+		if rounds > shaCryptRoundsMax {
+			rounds = shaCryptRoundsMax
+		}
+	}
+	salt := []byte(parts[len(parts)-2])
+	if len(salt) > shaCryptSaltMax {
+		salt = salt[:shaCryptSaltMax]
+	}
+	var encoded string
+	switch parts[1] {
+	case "5":
+		encoded = shaCrypt(sha256.New, sha256CryptOrder, password, salt, rounds)
+	case "6":
+		encoded = shaCrypt(sha512.New, sha512CryptOrder, password, salt, rounds)
+	default:
+		return false
+	}
+	return 1 == subtle.ConstantTimeCompare([]byte(parts[len(parts)-1]), []byte(encoded))
+}
+
+// Returns the encoded digest of the SHA-crypt algorithm
+func shaCrypt(newHash func() hash.Hash, order [][]int, password, salt []byte, rounds int) string {
+	b := newHash()
+	b.Write(password)
+	b.Write(salt)
+	b.Write(password)
+	digestB := b.Sum(nil)
+
+	a := newHash()
+	a.Write(password)
+	a.Write(salt)
+	a.Write(repeated(digestB, len(password)))
+	for i := len(password); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			a.Write(digestB)
+		} else {
+			a.Write(password)
+		}
+	}
+	digestA := a.Sum(nil)
+
+	dp := newHash()
+	for range password {
+		dp.Write(password)
+	}
+	p := repeated(dp.Sum(nil), len(password))
+
+	ds := newHash()
+	for i := 0; i < 16+int(digestA[0]); i++ {
+		ds.Write(salt)
+	}
+	s := repeated(ds.Sum(nil), len(salt))
+
+	digest := digestA
+	for i := 0; i < rounds; i++ {
+		c := newHash()
+		if i%2 != 0 {
+			c.Write(p)
+		} else {
+			c.Write(digest)
+		}
+		if i%3 != 0 {
+			c.Write(s)
+		}
+		if i%7 != 0 {
+			c.Write(p)
+		}
+		if i%2 != 0 {
+			c.Write(digest)
+		} else {
+			c.Write(p)
+		}
+		digest = c.Sum(nil)
+	}
+
+	var encoded strings.Builder
+	for _, group := range order {
+		var w uint
+		n := 0
+		for _, i := range group {
+			w <<= 8
+			if i >= 0 {
+				w |= uint(digest[i])
+				n++
+			}
+		}
+		// a group of n bytes is encoded with n+1 characters
+		for j := 0; j <= n; j++ {
+			encoded.WriteByte(shaCryptAlphabet[w&0x3f])
+			w >>= 6
+		}
+	}
+	return encoded.String()
+}
+
+// Returns the sequence repeated to the length n
+func repeated(sequence []byte, n int) []byte {
+	result := make([]byte, 0, n)
+	for len(result) < n {
+		l := len(sequence)
+		if missing := n - len(result); missing < l {
+			l = missing
+		}
+		result = append(result, sequence[:l]...)
+	}
+	return result
+}
@@ -0,0 +1,152 @@
+package htpasswd
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pchchv/logsrv/login"
+	"github.com/pkg/errors"
+)
+
+// Prefix of the hash of disabled users, like in /etc/shadow
+const disabledPrefix = "!"
+
+// Characters of new usernames. Separators, quotes, whitespace and comments
+// of the htpasswd format are not allowed, as well as a leading '!' or '-'.
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.@+-]{0,127}$`)
+
+func isDisabled(hash string) bool {
+	return strings.HasPrefix(hash, disabledPrefix)
+}
+
+// Returns a hash of the password with the rehash algorithm, bcrypt by default
+func (a *Auth) hashPassword(password string) (string, error) {
+	algorithm := a.rehash
+	if algorithm == "" {
+		algorithm = RehashBcrypt
+	}
+	return generateHash(algorithm, password)
+}
+
+// Returns the users of all files sorted by name
+func (a *Auth) Users() []login.ManagedUser {
+	reloadIfChanged(a)
+	a.muUserHash.RLock()
+	defer a.muUserHash.RUnlock()
+	users := make([]login.ManagedUser, 0, len(a.userHash))
+	for name, hash := range a.userHash {
+		users = append(users, login.ManagedUser{Name: name, Disabled: isDisabled(hash)})
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Name < users[j].Name })
+	return users
+}
+
+// Adds the user to the first file
+func (a *Auth) AddUser(username, password string) error {
+	if err := validUsername(username); err != nil {
+		return err
+	}
+	hash, err := a.hashPassword(password)
+	if err != nil {
+		return err
+	}
+	reloadIfChanged(a)
+	a.muUserHash.Lock()
+	defer a.muUserHash.Unlock()
+	if _, exist := a.userHash[username]; exist {
+		return errors.Wrap(login.ErrUserExists, username)
+	}
+	_, err = rewriteFile(a.filenames[0].name, func(lines []string) ([]string, bool) {
+		entry := username + ":" + hash
+		if last := len(lines) - 1; lines[last] == "" {
+			// keep the final newline
+			return append(lines[:last], entry, ""), true
+		}
+		return append(lines, entry), true
+	})
+	return a.reload(err)
+}
+
+// Replaces the password of the user, a disabled user stays disabled
+func (a *Auth) SetPassword(username, password string) error {
+	hash, err := a.hashPassword(password)
+	if err != nil {
+		return err
+	}
+	return a.changeUser(username, func(oldHash string) string {
+		if isDisabled(oldHash) {
+			return disabledPrefix + hash
+		}
+		return hash
+	})
+}
+
+// Disables or enables the user. The hash of a disabled user is kept with the prefix '!',
+// so that the old password is valid again after enabling the user.
+func (a *Auth) SetDisabled(username string, disabled bool) error {
+	return a.changeUser(username, func(hash string) string {
+		hash = strings.TrimPrefix(hash, disabledPrefix)
+		if disabled {
+			return disabledPrefix + hash
+		}
+		return hash
+	})
+}
+
+// Removes the user from all files
+func (a *Auth) DeleteUser(username string) error {
+	return a.changeUser(username, func(string) string {
+		return ""
+	})
+}
+
+// Changes the hash of the user in all files, which contain the user.
+// The entries are removed, if the change returns an empty hash.
+func (a *Auth) changeUser(username string, change func(hash string) string) error {
+	reloadIfChanged(a)
+	a.muUserHash.Lock()
+	defer a.muUserHash.Unlock()
+	if _, exist := a.userHash[username]; !exist {
+		return errors.Wrap(login.ErrUserNotFound, username)
+	}
+	for _, file := range a.filenames {
+		_, err := rewriteFile(file.name, func(lines []string) ([]string, bool) {
+			changed := false
+			result := lines[:0]
+			for _, line := range lines {
+				hash, ok := userLine(line, username)
+				if !ok {
+					result = append(result, line)
+					continue
+				}
+				changed = true
+				if newHash := change(hash); newHash != "" {
+					result = append(result, username+":"+newHash)
+				}
+			}
+			return result, changed
+		})
+		if err != nil {
+			return a.reload(err)
+		}
+	}
+	return a.reload(nil)
+}
+
+// Parses the files after a change, the caller has to hold the write lock.
+// Returns the error of the change or of the parsing.
+func (a *Auth) reload(err error) error {
+	if parseErr := a.parseLocked(); err == nil {
+		err = parseErr
+	}
+	return err
+}
+
+// The username is written to the file, so it must not break the format
+func validUsername(username string) error {
+	if !usernamePattern.MatchString(username) {
+		return errors.Wrapf(login.ErrInvalidUsername, "%q", username)
+	}
+	return nil
+}
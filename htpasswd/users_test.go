@@ -0,0 +1,95 @@
+package htpasswd
+
+import (
+	"os"
+	"testing"
+
+	"github.com/pchchv/logsrv/login"
+	"github.com/pkg/errors"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestAuth_ManageUsers(t *testing.T) {
+	files := writeTmpfile("# users\nbob:$apr1$IDZSCL/o$N68zaFDDRivjour94OVeB.\n")
+	auth, err := NewAuth(files)
+	NoError(t, err)
+
+	NoError(t, auth.AddUser("alice", "alice-secret"))
+	authenticated, err := auth.Authenticate("alice", "alice-secret")
+	NoError(t, err)
+	True(t, authenticated)
+	True(t, errors.Is(auth.AddUser("alice", "other"), login.ErrUserExists))
+	Equal(t, []login.ManagedUser{{Name: "alice"}, {Name: "bob"}}, auth.Users())
+
+	b, err := os.ReadFile(files[0])
+	NoError(t, err)
+	Regexp(t, "^# users\nbob:\\$apr1\\$IDZSCL/o\\$N68zaFDDRivjour94OVeB.\nalice:\\$2a\\$[^\n]+\n$", string(b))
+
+	NoError(t, auth.SetDisabled("bob", true))
+	authenticated, err = auth.Authenticate("bob", "secret")
+	NoError(t, err)
+	False(t, authenticated)
+	False(t, auth.UserExists("bob"))
+	Equal(t, []login.ManagedUser{{Name: "alice"}, {Name: "bob", Disabled: true}}, auth.Users())
+
+	// the password of a disabled user can be set, but the user stays disabled
+	NoError(t, auth.SetPassword("bob", "new-secret"))
+	authenticated, err = auth.Authenticate("bob", "new-secret")
+	NoError(t, err)
+	False(t, authenticated)
+
+	NoError(t, auth.SetDisabled("bob", false))
+	authenticated, err = auth.Authenticate("bob", "new-secret")
+	NoError(t, err)
+	True(t, authenticated)
+
+	NoError(t, auth.DeleteUser("alice"))
+	authenticated, err = auth.Authenticate("alice", "alice-secret")
+	NoError(t, err)
+	False(t, authenticated)
+	True(t, errors.Is(auth.DeleteUser("alice"), login.ErrUserNotFound))
+	True(t, errors.Is(auth.SetDisabled("alice", true), login.ErrUserNotFound))
+}
+
+func TestAuth_ManageUsers_TwoFiles(t *testing.T) {
+	files := writeTmpfile("bob:$apr1$IDZSCL/o$N68zaFDDRivjour94OVeB.\n", "alice:$apr1$IDZSCL/o$N68zaFDDRivjour94OVeB.\n")
+	auth, err := NewAuth(files)
+	NoError(t, err)
+
+	NoError(t, auth.SetDisabled("alice", true))
+	b, err := os.ReadFile(files[1])
+	NoError(t, err)
+	Equal(t, "alice:!$apr1$IDZSCL/o$N68zaFDDRivjour94OVeB.\n", string(b))
+
+	NoError(t, auth.AddUser("carol", "carol-secret"))
+	b, err = os.ReadFile(files[1])
+	NoError(t, err)
+	NotContains(t, string(b), "carol")
+}
+
+func TestAuth_AddUser_InvalidName(t *testing.T) {
+	auth, err := NewAuth(writeTmpfile("bob:$apr1$IDZSCL/o$N68zaFDDRivjour94OVeB.\n"))
+	NoError(t, err)
+
+	for _, name := range []string{"", "a:b", "a\nb", "a\rb", " bob", "bob ", "a b", "a\tb", "#bob", `"bob"`, `a"b`, "-bob", "!bob", "bob\x00", "bøb"} {
+		True(t, errors.Is(auth.AddUser(name, "secret"), login.ErrInvalidUsername), name)
+	}
+}
+
+func TestAuth_AddUser_Reparse(t *testing.T) {
+	files := writeTmpfile("bob:$apr1$IDZSCL/o$N68zaFDDRivjour94OVeB.\n")
+	auth, err := NewAuth(files)
+	NoError(t, err)
+
+	for _, name := range []string{"alice", "alice.smith", "alice@example.com", "a_b-c+d", "42"} {
+		NoError(t, auth.AddUser(name, "secret"), name)
+	}
+
+	// the file stays valid for a new reader
+	reparsed, err := NewAuth(files)
+	NoError(t, err)
+	Equal(t, auth.Users(), reparsed.Users())
+	authenticated, err := reparsed.Authenticate("alice@example.com", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+}
@@ -2,6 +2,7 @@ package httpupstream
 
 import (
 	"crypto/tls"
+	"fmt"
 	"net/http"
 	"net/url"
 	"time"
@@ -24,8 +25,7 @@ func NewAuth(upstream *url.URL, timeout time.Duration, skipverify bool) (*Auth,
 	return a, nil
 }
 
-// Authenticate the user
-func (a *Auth) Authenticate(username, password string) (bool, error) {
+func (a *Auth) client() *http.Client {
 	c := &http.Client{
 		Timeout: a.timeout,
 	}
@@ -34,12 +34,17 @@ func (a *Auth) Authenticate(username, password string) (bool, error) {
 			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
 		}
 	}
+	return c
+}
+
+// Authenticate the user
+func (a *Auth) Authenticate(username, password string) (bool, error) {
 	req, err := http.NewRequest("GET", a.upstream.String(), nil)
 	if err != nil {
 		return false, err
 	}
 	req.SetBasicAuth(username, password)
-	resp, err := c.Do(req)
+	resp, err := a.client().Do(req)
 	if err != nil {
 		return false, err
 	}
@@ -48,3 +53,17 @@ func (a *Auth) Authenticate(username, password string) (bool, error) {
 	}
 	return true, nil
 }
+
+// Checks, that the upstream answers without a server error.
+// The upstream is called without credentials, so that e.g. 401 is a valid answer.
+func (a *Auth) CheckHealth() error {
+	resp, err := a.client().Get(a.upstream.String())
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("upstream answered with http status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -78,3 +78,8 @@ func (sb *Backend) Authenticate(username, password string) (bool, model.UserInfo
 	}
 	return false, model.UserInfo{}, err
 }
+
+// Checks, that the upstream is reachable
+func (b *Backend) CheckHealth() error {
+	return b.auth.CheckHealth()
+}
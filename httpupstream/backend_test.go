@@ -98,3 +98,23 @@ func newTestServer() *httptest.Server {
 	}
 	return httptest.NewServer(http.HandlerFunc(passwordCheck))
 }
+
+func TestBackend_CheckHealth(t *testing.T) {
+	ts := newTestServer()
+	u, _ := url.Parse(ts.URL)
+	backend, err := NewBackend(u, time.Second, false)
+	NoError(t, err)
+	// 401 without credentials is an answer of the upstream
+	NoError(t, backend.CheckHealth())
+	ts.Close()
+	Error(t, backend.CheckHealth())
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer failing.Close()
+	u, _ = url.Parse(failing.URL)
+	backend, err = NewBackend(u, time.Second, false)
+	NoError(t, err)
+	Error(t, backend.CheckHealth())
+}
@@ -0,0 +1,262 @@
+package ldap
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/pchchv/logsrv/login"
+	"github.com/pchchv/logsrv/model"
+)
+
+const (
+	ProviderName      = "ldap"
+	defaultUserFilter = "(uid=%s)"
+	defaultTimeout    = 10 * time.Second
+)
+
+// Connection to the LDAP server, as far as it is used by the backend
+type Conn interface {
+	Bind(username, password string) error
+	Search(searchRequest *ldap.SearchRequest) (*ldap.SearchResult, error)
+	Close() error
+}
+
+// Settings of the LDAP backend
+type Config struct {
+	// URL of the server, e.g. ldaps://ldap.example.org:636
+	URL string
+	// DN and password of the service account for the user search.
+	// If empty, the search is done anonymously.
+	BindDN       string
+	BindPassword string
+	// Base DN for the user search
+	BaseDN string
+	// Filter for the user search, where %s is replaced by the escaped username
+	UserFilter string
+	// Attributes to fill the UserInfo from
+	GroupAttribute string
+	// If set, only the groups directly below this DN are used, by the value of their RDN, e.g. admins.
+	// Otherwise the full DNs of the groups are used, because the RDNs of groups in other OUs may be equal.
+	GroupBaseDN    string
+	EmailAttribute string
+	NameAttribute  string
+	StartTLS       bool
+	SkipVerify     bool
+	Timeout        time.Duration
+}
+
+// LDAP authentication backend
+type Backend struct {
+	config    Config
+	groupBase *ldap.DN
+	dial      func() (Conn, error)
+}
+
+func init() {
+	login.RegisterProvider(
+		&login.ProviderDescription{
+			Name:     ProviderName,
+			HelpText: "LDAP login backend opts: url=..,base_dn=..[,bind_dn=..,bind_password=..,user_filter=(uid=%s),group_attribute=memberOf,group_base_dn=..,email_attribute=mail,name_attribute=cn,start_tls=..,skipverify=..,timeout=..] (escape commas in DNs as '\\,')",
+		},
+		BackendFactory)
+}
+
+// Creates a LDAP backend
+func BackendFactory(config map[string]string) (login.Backend, error) {
+	cfg := Config{
+		URL:            config["url"],
+		BindDN:         config["bind_dn"],
+		BindPassword:   config["bind_password"],
+		BaseDN:         config["base_dn"],
+		GroupBaseDN:    config["group_base_dn"],
+		UserFilter:     defaultUserFilter,
+		GroupAttribute: "memberOf",
+		EmailAttribute: "mail",
+		NameAttribute:  "cn",
+		Timeout:        defaultTimeout,
+	}
+	if v, exist := config["user_filter"]; exist {
+		cfg.UserFilter = v
+	}
+	if v, exist := config["group_attribute"]; exist {
+		cfg.GroupAttribute = v
+	}
+	if v, exist := config["email_attribute"]; exist {
+		cfg.EmailAttribute = v
+	}
+	if v, exist := config["name_attribute"]; exist {
+		cfg.NameAttribute = v
+	}
+	var err error
+	if v, exist := config["start_tls"]; exist {
+		if cfg.StartTLS, err = strconv.ParseBool(v); err != nil {
+			return nil, fmt.Errorf(`invalid parameter value "%s" in "start_tls" ldap provider: %v`, v, err)
+		}
+	}
+	if v, exist := config["skipverify"]; exist {
+		if cfg.SkipVerify, err = strconv.ParseBool(v); err != nil {
+			return nil, fmt.Errorf(`invalid parameter value "%s" in "skipverify" ldap provider: %v`, v, err)
+		}
+	}
+	if v, exist := config["timeout"]; exist {
+		if cfg.Timeout, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf(`invalid parameter value "%s" in "timeout" ldap provider: %v`, v, err)
+		}
+	}
+	return NewBackend(cfg)
+}
+
+// Creates a new Backend and verifies the parameters
+func NewBackend(cfg Config) (*Backend, error) {
+	if cfg.URL == "" {
+		return nil, errors.New(`missing parameter "url" for ldap provider`)
+	}
+	if cfg.BaseDN == "" {
+		return nil, errors.New(`missing parameter "base_dn" for ldap provider`)
+	}
+	b := &Backend{config: cfg}
+	if cfg.GroupBaseDN != "" {
+		groupBase, err := ldap.ParseDN(cfg.GroupBaseDN)
+		if err != nil {
+			return nil, fmt.Errorf(`invalid parameter value "%s" in "group_base_dn" ldap provider: %v`, cfg.GroupBaseDN, err)
+		}
+		b.groupBase = groupBase
+	}
+	b.dial = b.dialServer
+	return b, nil
+}
+
+func (b *Backend) dialServer() (Conn, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: b.config.SkipVerify}
+	conn, err := ldap.DialURL(b.config.URL,
+		ldap.DialWithTLSConfig(tlsConfig),
+		ldap.DialWithDialer(&net.Dialer{Timeout: b.config.Timeout}))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(b.config.Timeout)
+	if b.config.StartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// Authenticate the user
+// by searching the entry with the service account and binding with the users DN afterwards
+func (b *Backend) Authenticate(username, password string) (bool, model.UserInfo, error) {
+	// an empty password would result in an unauthenticated bind, which always succeeds
+	if username == "" || password == "" {
+		return false, model.UserInfo{}, nil
+	}
+	conn, err := b.dial()
+	if err != nil {
+		return false, model.UserInfo{}, err
+	}
+	defer conn.Close()
+	entry, err := b.findUser(conn, username)
+	if err != nil || entry == nil {
+		return false, model.UserInfo{}, err
+	}
+	err = conn.Bind(entry.DN, password)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		return false, model.UserInfo{}, nil
+	}
+	if err != nil {
+		return false, model.UserInfo{}, fmt.Errorf("ldap bind as user failed: %v", err)
+	}
+	return true, model.UserInfo{
+		Origin: ProviderName,
+		Sub:    username,
+		Email:  entry.GetAttributeValue(b.config.EmailAttribute),
+		Name:   entry.GetAttributeValue(b.config.NameAttribute),
+		Groups: b.groupNames(entry.GetAttributeValues(b.config.GroupAttribute)),
+	}, nil
+}
+
+// Checks, that the user can still be found by the user search
+func (b *Backend) ValidateUser(userInfo model.UserInfo) (bool, error) {
+	if userInfo.Origin != ProviderName {
+		return true, nil
+	}
+	conn, err := b.dial()
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	entry, err := b.findUser(conn, userInfo.Sub)
+	return entry != nil, err
+}
+
+// Checks, that the server is reachable and the service account can bind
+func (b *Backend) CheckHealth() error {
+	conn, err := b.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if b.config.BindDN != "" {
+		if err := conn.Bind(b.config.BindDN, b.config.BindPassword); err != nil {
+			return fmt.Errorf("ldap bind with service account failed: %v", err)
+		}
+	}
+	return nil
+}
+
+// Searches the entry of the user with the service account.
+// The entry is nil, if the user does not exist.
+func (b *Backend) findUser(conn Conn, username string) (*ldap.Entry, error) {
+	if b.config.BindDN != "" {
+		if err := conn.Bind(b.config.BindDN, b.config.BindPassword); err != nil {
+			return nil, fmt.Errorf("ldap bind with service account failed: %v", err)
+		}
+	}
+	result, err := conn.Search(ldap.NewSearchRequest(
+		b.config.BaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, int(b.config.Timeout.Seconds()), false,
+		fmt.Sprintf(b.config.UserFilter, ldap.EscapeFilter(username)),
+		[]string{b.config.GroupAttribute, b.config.EmailAttribute, b.config.NameAttribute},
+		nil,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("ldap user search failed: %v", err)
+	}
+	if len(result.Entries) == 0 {
+		return nil, nil
+	}
+	if len(result.Entries) > 1 {
+		return nil, fmt.Errorf("ldap user search returned multiple entries for %q", username)
+	}
+	return result.Entries[0], nil
+}
+
+// Returns the groups of the user.
+// Without a group base DN, the values are used as they are.
+// Otherwise only the groups directly below the group base DN are used by the value of their RDN,
+// e.g. cn=admins,ou=groups,dc=example,dc=org becomes admins for the group base DN ou=groups,dc=example,dc=org.
+// Groups in other OUs, which may have the same RDN, are left out.
+func (b *Backend) groupNames(values []string) []string {
+	if len(values) == 0 {
+		return nil
+	}
+	if b.groupBase == nil {
+		return values
+	}
+	groups := make([]string, 0, len(values))
+	for _, v := range values {
+		dn, err := ldap.ParseDN(v)
+		if err != nil || len(dn.RDNs) != len(b.groupBase.RDNs)+1 || !b.groupBase.AncestorOfFold(dn) ||
+			len(dn.RDNs[0].Attributes) != 1 {
+			continue
+		}
+		groups = append(groups, dn.RDNs[0].Attributes[0].Value)
+	}
+	return groups
+}
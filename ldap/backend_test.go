@@ -0,0 +1,263 @@
+package ldap
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/pchchv/logsrv/login"
+	"github.com/pchchv/logsrv/model"
+	. "github.com/stretchr/testify/assert"
+)
+
+// In-process stand-in for a LDAP server, holding the entries by DN
+type fakeDirectory struct {
+	entries   map[string]fakeEntry
+	bindCalls []string
+	searchErr error
+}
+
+type fakeEntry struct {
+	password   string
+	attributes map[string][]string
+}
+
+type fakeConn struct {
+	dir    *fakeDirectory
+	closed bool
+}
+
+func (c *fakeConn) Bind(username, password string) error {
+	c.dir.bindCalls = append(c.dir.bindCalls, username)
+	if e, exist := c.dir.entries[username]; exist && e.password == password {
+		return nil
+	}
+	return ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New("invalid credentials"))
+}
+
+// Supports filters in the form (attribute=value) only
+func (c *fakeConn) Search(r *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	if c.dir.searchErr != nil {
+		return nil, c.dir.searchErr
+	}
+	pair := strings.SplitN(strings.Trim(r.Filter, "()"), "=", 2)
+	result := &ldap.SearchResult{}
+	for dn, e := range c.dir.entries {
+		if !strings.HasSuffix(dn, r.BaseDN) {
+			continue
+		}
+		for _, v := range e.attributes[pair[0]] {
+			if v == pair[1] {
+				result.Entries = append(result.Entries, ldap.NewEntry(dn, e.attributes))
+			}
+		}
+	}
+	return result, nil
+}
+
+func (c *fakeConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+func testDirectory() *fakeDirectory {
+	return &fakeDirectory{
+		entries: map[string]fakeEntry{
+			"cn=service,dc=example,dc=org": {password: "servicesecret"},
+			"uid=bob,ou=people,dc=example,dc=org": {
+				password: "secret",
+				attributes: map[string][]string{
+					"uid":      {"bob"},
+					"mail":     {"bob@example.org"},
+					"cn":       {"Bob Builder"},
+					"memberOf": {"cn=admins,ou=groups,dc=example,dc=org", "developers"},
+				},
+			},
+			"uid=alice,ou=people,dc=other,dc=org": {
+				password:   "secret",
+				attributes: map[string][]string{"uid": {"alice"}},
+			},
+		},
+	}
+}
+
+func testBackend(dir *fakeDirectory) (*Backend, *fakeConn) {
+	conn := &fakeConn{dir: dir}
+	b, err := NewBackend(Config{
+		URL:            "ldap://localhost",
+		BindDN:         "cn=service,dc=example,dc=org",
+		BindPassword:   "servicesecret",
+		BaseDN:         "dc=example,dc=org",
+		UserFilter:     defaultUserFilter,
+		GroupAttribute: "memberOf",
+		EmailAttribute: "mail",
+		NameAttribute:  "cn",
+	})
+	if err != nil {
+		panic(err)
+	}
+	b.dial = func() (Conn, error) {
+		return conn, nil
+	}
+	return b, conn
+}
+
+func TestSetupOneBackend(t *testing.T) {
+	p, exist := login.GetProvider(ProviderName)
+	True(t, exist)
+	NotNil(t, p)
+	backend, err := p(map[string]string{
+		"url":             "ldaps://ldap.example.org",
+		"base_dn":         "dc=example,dc=org",
+		"bind_dn":         "cn=service,dc=example,dc=org",
+		"bind_password":   "secret",
+		"user_filter":     "(sAMAccountName=%s)",
+		"group_attribute": "memberOf",
+		"start_tls":       "true",
+		"timeout":         "3s",
+	})
+	NoError(t, err)
+	b := backend.(*Backend)
+	Equal(t, "(sAMAccountName=%s)", b.config.UserFilter)
+	Equal(t, "mail", b.config.EmailAttribute)
+	True(t, b.config.StartTLS)
+	False(t, b.config.SkipVerify)
+}
+
+func TestSetupInvalidConfig(t *testing.T) {
+	p, _ := login.GetProvider(ProviderName)
+	for _, config := range []map[string]string{
+		{"base_dn": "dc=example,dc=org"},
+		{"url": "ldap://localhost"},
+		{"url": "ldap://localhost", "base_dn": "dc=example,dc=org", "start_tls": "maybe"},
+		{"url": "ldap://localhost", "base_dn": "dc=example,dc=org", "timeout": "soon"},
+		{"url": "ldap://localhost", "base_dn": "dc=example,dc=org", "group_base_dn": "groups"},
+	} {
+		_, err := p(config)
+		Error(t, err)
+	}
+}
+
+func TestBackend_Authenticate(t *testing.T) {
+	b, conn := testBackend(testDirectory())
+	authenticated, userInfo, err := b.Authenticate("bob", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+	Equal(t, model.UserInfo{
+		Origin: ProviderName,
+		Sub:    "bob",
+		Email:  "bob@example.org",
+		Name:   "Bob Builder",
+		Groups: []string{"cn=admins,ou=groups,dc=example,dc=org", "developers"},
+	}, userInfo)
+	Equal(t, []string{"cn=service,dc=example,dc=org", "uid=bob,ou=people,dc=example,dc=org"}, conn.dir.bindCalls)
+	True(t, conn.closed)
+}
+
+func TestBackend_AuthenticateFailures(t *testing.T) {
+	b, conn := testBackend(testDirectory())
+	for _, credentials := range [][]string{
+		{"bob", "wrong"},
+		{"unknown", "secret"},
+		// not below the base dn
+		{"alice", "secret"},
+		// no filter injection
+		{"*", "secret"},
+	} {
+		authenticated, userInfo, err := b.Authenticate(credentials[0], credentials[1])
+		NoError(t, err)
+		False(t, authenticated)
+		Equal(t, model.UserInfo{}, userInfo)
+	}
+	// an empty password must never reach the server
+	conn.dir.bindCalls = nil
+	authenticated, _, err := b.Authenticate("bob", "")
+	NoError(t, err)
+	False(t, authenticated)
+	Empty(t, conn.dir.bindCalls)
+}
+
+func TestBackend_AuthenticateErrors(t *testing.T) {
+	dir := testDirectory()
+	b, _ := testBackend(dir)
+	b.config.BindPassword = "wrong"
+	_, _, err := b.Authenticate("bob", "secret")
+	Error(t, err)
+	b, _ = testBackend(dir)
+	dir.searchErr = errors.New("server down")
+	_, _, err = b.Authenticate("bob", "secret")
+	Error(t, err)
+	b.dial = func() (Conn, error) {
+		return nil, errors.New("connection refused")
+	}
+	_, _, err = b.Authenticate("bob", "secret")
+	Error(t, err)
+}
+
+func TestBackend_ValidateUser(t *testing.T) {
+	dir := testDirectory()
+	b, conn := testBackend(dir)
+	valid, err := b.ValidateUser(model.UserInfo{Sub: "bob", Origin: ProviderName})
+	NoError(t, err)
+	True(t, valid)
+	True(t, conn.closed)
+	valid, err = b.ValidateUser(model.UserInfo{Sub: "alice", Origin: ProviderName})
+	NoError(t, err)
+	False(t, valid)
+	// users of other backends are not checked
+	valid, err = b.ValidateUser(model.UserInfo{Sub: "alice", Origin: "github"})
+	NoError(t, err)
+	True(t, valid)
+	dir.searchErr = errors.New("server down")
+	_, err = b.ValidateUser(model.UserInfo{Sub: "bob", Origin: ProviderName})
+	Error(t, err)
+}
+
+func TestBackend_groupNames(t *testing.T) {
+	b, _ := testBackend(testDirectory())
+	Nil(t, b.groupNames(nil))
+	// the full dns by default
+	Equal(t, []string{"CN=admins,OU=Groups,DC=corp,DC=example", "plain"},
+		b.groupNames([]string{"CN=admins,OU=Groups,DC=corp,DC=example", "plain"}))
+
+	b.config.GroupBaseDN = "ou=groups,dc=corp,dc=example"
+	b, err := NewBackend(b.config)
+	NoError(t, err)
+	Equal(t, []string{"admins", "developers"}, b.groupNames([]string{
+		"CN=admins,OU=Groups,DC=corp,DC=example",
+		"cn=developers,ou=groups,dc=corp,dc=example",
+		// groups of other OUs with the same name
+		"cn=admin,ou=apps,dc=corp,dc=example",
+		"cn=admin,ou=delegated,ou=groups,dc=corp,dc=example",
+		"plain",
+	}))
+}
+
+func TestBackend_Authenticate_GroupBaseDN(t *testing.T) {
+	b, conn := testBackend(testDirectory())
+	b.config.GroupBaseDN = "ou=groups,dc=example,dc=org"
+	b, err := NewBackend(b.config)
+	NoError(t, err)
+	b.dial = func() (Conn, error) {
+		return conn, nil
+	}
+	authenticated, userInfo, err := b.Authenticate("bob", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+	Equal(t, []string{"admins"}, userInfo.Groups)
+}
+
+func TestBackend_CheckHealth(t *testing.T) {
+	dir := testDirectory()
+	b, conn := testBackend(dir)
+	NoError(t, b.CheckHealth())
+	Equal(t, []string{"cn=service,dc=example,dc=org"}, dir.bindCalls)
+	True(t, conn.closed)
+	b.config.BindPassword = "wrong"
+	Error(t, b.CheckHealth())
+	b.dial = func() (Conn, error) {
+		return nil, errors.New("connection refused")
+	}
+	Error(t, b.CheckHealth())
+}
@@ -0,0 +1,103 @@
+package logging
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// Types of audit events
+const (
+	AuditLogin          = "login"
+	AuditLogout         = "logout"
+	AuditRefresh        = "refresh"
+	AuditOauthCallback  = "oauth_callback"
+	AuditSamlCallback   = "saml_callback"
+	AuditLockout        = "lockout"
+	AuditRevocation     = "revocation"
+	AuditPasswordChange = "password_change"
+	AuditAdmin          = "admin"
+	AuditTokenExchange  = "token_exchange"
+)
+
+// Outcomes of audit events
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+	AuditError   = "error"
+)
+
+// An entry of the audit log, which is written as one JSON line
+type AuditEvent struct {
+	Timestamp     time.Time `json:"@timestamp"`
+	Event         string    `json:"event"`
+	Outcome       string    `json:"outcome"`
+	Reason        string    `json:"reason,omitempty"`
+	Subject       string    `json:"sub,omitempty"`
+	Origin        string    `json:"origin,omitempty"`
+	RemoteIp      string    `json:"remote_ip,omitempty"`
+	UserAgent     string    `json:"user_agent,omitempty"`
+	CorrelationId string    `json:"correlation_id,omitempty"`
+}
+
+var (
+	auditMu     sync.Mutex
+	auditWriter io.WriteCloser
+)
+
+// Opens the target of the audit log, which is a file or syslog.
+// The target syslog writes to the local syslog daemon, syslog://host:port to a remote one by udp.
+// Files are rotated after maxSizeMB and the given number of old files is kept, 0 keeps all.
+func OpenAuditLog(target string, maxSizeMB, maxBackups int) (io.WriteCloser, error) {
+	if target == "syslog" || strings.HasPrefix(target, "syslog://") {
+		return openSyslog(strings.TrimPrefix(strings.TrimPrefix(target, "syslog"), "://"))
+	}
+	return &lumberjack.Logger{
+		Filename:   target,
+		MaxSize:    maxSizeMB,
+		MaxBackups: maxBackups,
+	}, nil
+}
+
+// Sets the writer of the audit log and closes the previous one.
+// The audit log is disabled with nil.
+func SetAuditLog(w io.WriteCloser) error {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	previous := auditWriter
+	auditWriter = w
+	if previous != nil {
+		return previous.Close()
+	}
+	return nil
+}
+
+// Writes the event to the audit log, completed by the client information of the request
+func Audit(r *http.Request, event AuditEvent) {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	if auditWriter == nil {
+		return
+	}
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+	if r != nil {
+		event.RemoteIp = GetRemoteIp(r)
+		event.UserAgent = r.Header.Get("User-Agent")
+		event.CorrelationId = GetCorrelationId(r.Header)
+	}
+	b, err := json.Marshal(event)
+	if err != nil {
+		Logger.WithError(err).Error("audit event not written")
+		return
+	}
+	if _, err := auditWriter.Write(append(b, '\n')); err != nil {
+		Logger.WithError(err).Error("audit event not written")
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !windows && !plan9
+
+package logging
+
+import (
+	"io"
+	"log/syslog"
+)
+
+// Opens the local syslog for an empty address, otherwise the syslog server at host:port by udp
+func openSyslog(address string) (io.WriteCloser, error) {
+	if address == "" {
+		return syslog.New(syslog.LOG_AUTH|syslog.LOG_INFO, "logsrv")
+	}
+	return syslog.Dial("udp", address, syslog.LOG_AUTH|syslog.LOG_INFO, "logsrv")
+}
@@ -0,0 +1,72 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type bufferCloser struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (b *bufferCloser) Close() error {
+	b.closed = true
+	return nil
+}
+
+func Test_Audit(t *testing.T) {
+	a := assert.New(t)
+	b := &bufferCloser{}
+	a.NoError(SetAuditLog(b))
+	defer SetAuditLog(nil)
+	r, _ := http.NewRequest("POST", "http://www.example.org/login", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	r.Header.Set("User-Agent", "curl/8.0")
+	r.Header.Set(CorrelationIdHeader, "abc")
+	// set by the client, not by a trusted proxy
+	r.Header.Set("X-Real-Ip", "10.0.0.1")
+	Audit(r, AuditEvent{Event: AuditLogin, Subject: "bob", Origin: "htpasswd", Outcome: AuditFailure, Reason: "wrong credentials"})
+	Audit(r, AuditEvent{Event: AuditLogout, Subject: "bob", Outcome: AuditSuccess})
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	a.Equal(2, len(lines))
+	event := AuditEvent{}
+	a.NoError(json.Unmarshal([]byte(lines[0]), &event))
+	a.False(event.Timestamp.IsZero())
+	a.Equal(AuditEvent{
+		Timestamp:     event.Timestamp,
+		Event:         AuditLogin,
+		Outcome:       AuditFailure,
+		Reason:        "wrong credentials",
+		Subject:       "bob",
+		Origin:        "htpasswd",
+		RemoteIp:      "192.0.2.1",
+		UserAgent:     "curl/8.0",
+		CorrelationId: "abc",
+	}, event)
+	a.Contains(lines[1], `"event":"logout"`)
+	// the previous writer is closed on a change
+	a.NoError(SetAuditLog(nil))
+	a.True(b.closed)
+	Audit(r, AuditEvent{Event: AuditLogin, Outcome: AuditSuccess})
+}
+
+func Test_OpenAuditLog_File(t *testing.T) {
+	a := assert.New(t)
+	file := filepath.Join(t.TempDir(), "audit.log")
+	w, err := OpenAuditLog(file, 1, 1)
+	a.NoError(err)
+	a.NoError(SetAuditLog(w))
+	Audit(nil, AuditEvent{Event: AuditRevocation, Subject: "bob", Outcome: AuditSuccess})
+	a.NoError(SetAuditLog(nil))
+	b, err := os.ReadFile(file)
+	a.NoError(err)
+	a.Contains(string(b), `"event":"revocation","outcome":"success","sub":"bob"`)
+}
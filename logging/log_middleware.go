@@ -4,8 +4,11 @@ import (
 	"fmt"
 	"net/http"
 	"runtime"
+	"strconv"
 	"strings"
 	"time"
+
+	"github.com/pchchv/logsrv/metrics"
 )
 
 type LogMiddleware struct {
@@ -25,6 +28,7 @@ func (mw *LogMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	defer func() {
 		if rec := recover(); rec != nil {
 			AccessError(r, start, fmt.Errorf("PANIC (%v): %v", identifyLogOrigin(), rec))
+			observeRequest(r, start, http.StatusInternalServerError)
 		}
 	}()
 
@@ -32,6 +36,28 @@ func (mw *LogMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	mw.Next.ServeHTTP(lrw, r)
 
 	Access(r, start, lrw.statusCode)
+	observeRequest(r, start, lrw.statusCode)
+}
+
+func observeRequest(r *http.Request, start time.Time, statusCode int) {
+	if statusCode == 0 {
+		// nothing written, so the server responds with 200
+		statusCode = http.StatusOK
+	}
+	method := metricMethod(r.Method)
+	metrics.HTTPRequests.WithLabelValues(method, strconv.Itoa(statusCode)).Inc()
+	metrics.HTTPRequestDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+}
+
+// Returns the method as label value. Unknown methods are "other",
+// because every client can send arbitrary methods, which would create unlimited metrics.
+func metricMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	return "other"
 }
 
 // Returns the location, where a panic was raised in the form package/subpackage.method:line
@@ -6,6 +6,8 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"github.com/pchchv/logsrv/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -66,3 +68,23 @@ func Test_LogMiddleware_Log_404(t *testing.T) {
 	a.Equal(404, data.ResponseStatus)
 	a.Equal("warning", data.Level)
 }
+
+func Test_LogMiddleware_Metrics(t *testing.T) {
+	a := assert.New(t)
+	Logger.Out = bytes.NewBuffer(nil)
+	notFound := metrics.HTTPRequests.WithLabelValues("GET", "404")
+	before := testutil.ToFloat64(notFound)
+	lm := NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(404)
+	}))
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+	lm.ServeHTTP(httptest.NewRecorder(), r)
+	a.Equal(before+1, testutil.ToFloat64(notFound))
+
+	// unknown methods share one label value
+	otherNotFound := metrics.HTTPRequests.WithLabelValues("other", "404")
+	before = testutil.ToFloat64(otherNotFound)
+	r, _ = http.NewRequest("FOO123", "http://www.example.org/foo", nil)
+	lm.ServeHTTP(httptest.NewRecorder(), r)
+	a.Equal(before+1, testutil.ToFloat64(otherNotFound))
+}
@@ -3,9 +3,11 @@ package logging
 import (
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -74,7 +76,7 @@ func access(r *http.Request, start time.Time, statusCode int, err error) *logrus
 	fields := logrus.Fields{
 		"type":       "access",
 		"@timestamp": start,
-		"remote_ip":  getRemoteIp(r),
+		"remote_ip":  GetRemoteIp(r),
 		"host":       r.Host,
 		"url":        url,
 		"method":     r.Method,
@@ -218,14 +220,68 @@ func ServerClosed(appName string) {
 	Logger.WithFields(fields).Infof("http server was closed: %v", appName)
 }
 
-func getRemoteIp(r *http.Request) string {
-	if r.Header.Get("X-Cluster-Client-Ip") != "" {
-		return r.Header.Get("X-Cluster-Client-Ip")
+var (
+	trustedProxiesMu sync.RWMutex
+	trustedProxies   []*net.IPNet
+)
+
+// Sets the comma separated networks (CIDR) or ips of the reverse proxies,
+// whose X-Cluster-Client-Ip and X-Real-Ip headers are trusted. An empty list trusts no proxy.
+func SetTrustedProxies(list string) error {
+	networks := []*net.IPNet{}
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return fmt.Errorf("invalid trusted proxy: %v", entry)
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return fmt.Errorf("invalid trusted proxy: %v", entry)
+		}
+		networks = append(networks, network)
+	}
+	trustedProxiesMu.Lock()
+	defer trustedProxiesMu.Unlock()
+	trustedProxies = networks
+	return nil
+}
+
+func isTrustedProxy(ip net.IP) bool {
+	trustedProxiesMu.RLock()
+	defer trustedProxiesMu.RUnlock()
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the ip of the client. The headers X-Cluster-Client-Ip and X-Real-Ip are only used,
+// if the request comes from a trusted proxy, because every client can set them.
+func GetRemoteIp(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		// no port, e.g. in tests or from other handlers
+		host = r.RemoteAddr
 	}
-	if r.Header.Get("X-Real-Ip") != "" {
-		return r.Header.Get("X-Real-Ip")
+	if ip := net.ParseIP(host); ip == nil || !isTrustedProxy(ip) {
+		return host
+	}
+	for _, header := range []string{"X-Cluster-Client-Ip", "X-Real-Ip"} {
+		if ip := net.ParseIP(strings.TrimSpace(r.Header.Get(header))); ip != nil {
+			return ip.String()
+		}
 	}
-	return strings.Split(r.RemoteAddr, ":")[0]
+	return host
 }
 
 func setCorrelationIds(fields logrus.Fields, h http.Header) {
@@ -318,28 +318,67 @@ func Test_Logger_Cacheinfo(t *testing.T) {
 
 func Test_Logger_GetRemoteIp1(t *testing.T) {
 	a := assert.New(t)
+	a.NoError(SetTrustedProxies("10.0.0.0/8"))
+	defer SetTrustedProxies("")
 	req, _ := http.NewRequest("GET", "test.com", nil)
-	req.Header["X-Cluster-Client-Ip"] = []string{"1234"}
-	ret := getRemoteIp(req)
-	a.Equal("1234", ret)
+	req.RemoteAddr = "10.1.2.3:4711"
+	req.Header["X-Cluster-Client-Ip"] = []string{"192.0.2.1"}
+	ret := GetRemoteIp(req)
+	a.Equal("192.0.2.1", ret)
 }
 
 func Test_Logger_GetRemoteIp2(t *testing.T) {
 	a := assert.New(t)
+	a.NoError(SetTrustedProxies("10.1.2.3, 2001:db8::1"))
+	defer SetTrustedProxies("")
 	req, _ := http.NewRequest("GET", "test.com", nil)
+	req.RemoteAddr = "[2001:db8::1]:4711"
+	req.Header["X-Real-Ip"] = []string{"192.0.2.1"}
+	ret := GetRemoteIp(req)
+	a.Equal("192.0.2.1", ret)
+	// invalid headers are ignored
 	req.Header["X-Real-Ip"] = []string{"1234"}
-	ret := getRemoteIp(req)
-	a.Equal("1234", ret)
+	a.Equal("2001:db8::1", GetRemoteIp(req))
 }
 
 func Test_Logger_GetRemoteIp3(t *testing.T) {
 	a := assert.New(t)
 	req, _ := http.NewRequest("GET", "test.com", nil)
 	req.RemoteAddr = "1234:80"
-	ret := getRemoteIp(req)
+	ret := GetRemoteIp(req)
 	a.Equal("1234", ret)
 }
 
+func Test_Logger_GetRemoteIp_UntrustedHeaders(t *testing.T) {
+	a := assert.New(t)
+	req, _ := http.NewRequest("GET", "test.com", nil)
+	req.RemoteAddr = "192.0.2.1:4711"
+	req.Header["X-Cluster-Client-Ip"] = []string{"10.0.0.1"}
+	req.Header["X-Real-Ip"] = []string{"10.0.0.2"}
+	a.Equal("192.0.2.1", GetRemoteIp(req))
+	a.NoError(SetTrustedProxies("10.0.0.0/8"))
+	defer SetTrustedProxies("")
+	a.Equal("192.0.2.1", GetRemoteIp(req))
+}
+
+func Test_Logger_GetRemoteIp_IPv6(t *testing.T) {
+	a := assert.New(t)
+	req, _ := http.NewRequest("GET", "test.com", nil)
+	req.RemoteAddr = "[2001:db8::1]:4711"
+	a.Equal("2001:db8::1", GetRemoteIp(req))
+	req.RemoteAddr = "[2001:db8::2]:4711"
+	a.Equal("2001:db8::2", GetRemoteIp(req))
+}
+
+func Test_Logger_SetTrustedProxies(t *testing.T) {
+	a := assert.New(t)
+	defer SetTrustedProxies("")
+	a.NoError(SetTrustedProxies(""))
+	a.NoError(SetTrustedProxies("10.0.0.0/8,192.0.2.1,::1"))
+	a.Error(SetTrustedProxies("10.0.0.0/33"))
+	a.Error(SetTrustedProxies("proxy.example.com"))
+}
+
 func logRecordFromBuffer(b *bytes.Buffer) *logReccord {
 	data := &logReccord{}
 	err := json.Unmarshal(b.Bytes(), data)
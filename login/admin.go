@@ -0,0 +1,323 @@
+package login
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/pchchv/logsrv/logging"
+	"github.com/pchchv/logsrv/model"
+	"github.com/pkg/errors"
+)
+
+// Resources of the admin api below the admin path
+const (
+	adminUsers    = "users"
+	adminClaims   = "claims"
+	adminSessions = "sessions"
+)
+
+// Body of the requests, which add or change users
+type adminUserRequest struct {
+	Name     string  `json:"name"`
+	Password *string `json:"password"`
+	Disabled *bool   `json:"disabled"`
+}
+
+func (h *Handler) isAdminPath(p string) bool {
+	return h.config.AdminPath != "" && (p == h.config.AdminPath || strings.HasPrefix(p, strings.TrimRight(h.config.AdminPath, "/")+"/"))
+}
+
+// Serves the admin api for the users of the admin group:
+// the users of the user managing backend, the entries of the user file and the sessions.
+// Changes need a JSON body, so that they can not be sent by other sites without CORS.
+func (h *Handler) handleAdmin(w http.ResponseWriter, r *http.Request) {
+	admin, valid := h.GetToken(r)
+	if !valid {
+		respondAdminError(w, 401, "Authentication required")
+		return
+	}
+	if !contains(admin.Groups, h.config.AdminGroup) {
+		logging.Application(r.Header).
+			WithField("username", admin.Sub).Warn("admin api access denied")
+		respondAdminError(w, 403, "Access denied")
+		return
+	}
+	if (r.Method == "POST" || r.Method == "PUT" || r.Method == "PATCH") &&
+		!strings.HasPrefix(r.Header.Get("Content-Type"), contentTypeJSON) {
+		respondAdminError(w, 415, "Content-Type has to be "+contentTypeJSON)
+		return
+	}
+	resource := strings.Trim(strings.TrimPrefix(r.URL.Path, strings.TrimRight(h.config.AdminPath, "/")), "/")
+	parts := strings.SplitN(resource, "/", 2)
+	name := ""
+	if len(parts) == 2 {
+		name = parts[1]
+	}
+	switch parts[0] {
+	case adminUsers:
+		h.handleAdminUsers(w, r, admin, name)
+	case adminClaims:
+		if name != "" {
+			respondAdminError(w, 404, "Not found")
+			return
+		}
+		h.handleAdminClaims(w, r, admin)
+	case adminSessions:
+		h.handleAdminSessions(w, r, admin, name)
+	default:
+		respondAdminError(w, 404, "Not found")
+	}
+}
+
+// Returns the first backend, which manages its users
+func (h *Handler) userManager() UserManager {
+	for _, b := range h.backends {
+		if manager, ok := b.(UserManager); ok {
+			return manager
+		}
+	}
+	return nil
+}
+
+func (h *Handler) handleAdminUsers(w http.ResponseWriter, r *http.Request, admin model.UserInfo, name string) {
+	manager := h.userManager()
+	if manager == nil {
+		respondAdminError(w, 404, "No backend with user management configured")
+		return
+	}
+	switch {
+	case r.Method == "GET" && name == "":
+		users, err := manager.Users()
+		if err != nil {
+			h.respondAdminFailure(w, r, admin, "list users", err)
+			return
+		}
+		respondAdminJSON(w, 200, users)
+	case r.Method == "POST" && name == "":
+		user := adminUserRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&user); err != nil || user.Password == nil {
+			respondAdminError(w, 400, "The user needs a name and a password")
+			return
+		}
+		if !h.checkAdminPassword(w, *user.Password) {
+			return
+		}
+		action := "add user " + user.Name
+		if err := manager.AddUser(user.Name, *user.Password); err != nil {
+			h.respondAdminFailure(w, r, admin, action, err)
+			return
+		}
+		h.auditAdmin(r, admin, logging.AuditSuccess, action)
+		respondAdminJSON(w, 201, ManagedUser{Name: user.Name})
+	case r.Method == "PATCH" && name != "":
+		user := adminUserRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+			respondAdminError(w, 400, "Invalid user")
+			return
+		}
+		if user.Password != nil {
+			if !h.checkAdminPassword(w, *user.Password) {
+				return
+			}
+			action := "change password of user " + name
+			if err := manager.SetPassword(name, *user.Password); err != nil {
+				h.respondAdminFailure(w, r, admin, action, err)
+				return
+			}
+			h.auditAdmin(r, admin, logging.AuditSuccess, action)
+		}
+		if user.Disabled != nil {
+			action := "enable user " + name
+			if *user.Disabled {
+				action = "disable user " + name
+			}
+			if err := manager.SetDisabled(name, *user.Disabled); err != nil {
+				h.respondAdminFailure(w, r, admin, action, err)
+				return
+			}
+			// the tokens of a disabled user must not be used any longer
+			if *user.Disabled && h.store != nil {
+				if err := h.revokeAllTokens(name); err != nil {
+					h.respondAdminFailure(w, r, admin, action, err)
+					return
+				}
+			}
+			h.auditAdmin(r, admin, logging.AuditSuccess, action)
+		}
+		w.WriteHeader(204)
+	case r.Method == "DELETE" && name != "":
+		action := "delete user " + name
+		if err := manager.DeleteUser(name); err != nil {
+			h.respondAdminFailure(w, r, admin, action, err)
+			return
+		}
+		if h.store != nil {
+			if err := h.revokeAllTokens(name); err != nil {
+				h.respondAdminFailure(w, r, admin, action, err)
+				return
+			}
+		}
+		h.auditAdmin(r, admin, logging.AuditSuccess, action)
+		w.WriteHeader(204)
+	default:
+		respondAdminError(w, 405, "Method not allowed")
+	}
+}
+
+// Checks a password, which is set by an admin, against the password policy
+func (h *Handler) checkAdminPassword(w http.ResponseWriter, password string) bool {
+	reason, err := h.checkPasswordPolicy(passwordChange{NewPassword: password})
+	if err != nil {
+		logging.Logger.WithError(err).Error()
+		respondAdminError(w, 500, "Internal Server Error")
+		return false
+	}
+	if reason != "" {
+		respondAdminError(w, 400, reason)
+		return false
+	}
+	return true
+}
+
+func (h *Handler) handleAdminClaims(w http.ResponseWriter, r *http.Request, admin model.UserInfo) {
+	if h.config.UserFile == "" || h.userFile == nil {
+		respondAdminError(w, 404, "No user file configured")
+		return
+	}
+	switch r.Method {
+	case "GET":
+		entries := h.userFile.entries()
+		result := make([]userFileEntry, len(entries))
+		for i, entry := range entries {
+			// the values of the YAML file have maps with interface keys, which can not be encoded as JSON
+			entry.Claims, _ = jsonValue(entry.Claims).(map[string]interface{})
+			result[i] = entry
+		}
+		respondAdminJSON(w, 200, result)
+	case "PUT":
+		entries := []userFileEntry{}
+		if err := json.NewDecoder(r.Body).Decode(&entries); err != nil {
+			respondAdminError(w, 400, "The user file has to be a list of entries")
+			return
+		}
+		action := "replace user file"
+		if err := h.userFile.setEntries(entries); err != nil {
+			h.respondAdminFailure(w, r, admin, action, err)
+			return
+		}
+		h.auditAdmin(r, admin, logging.AuditSuccess, action)
+		w.WriteHeader(204)
+	default:
+		respondAdminError(w, 405, "Method not allowed")
+	}
+}
+
+func (h *Handler) handleAdminSessions(w http.ResponseWriter, r *http.Request, admin model.UserInfo, id string) {
+	if !h.tracksSessions() {
+		respondAdminError(w, 404, "No store configured for sessions")
+		return
+	}
+	sub := r.URL.Query().Get("sub")
+	switch {
+	case r.Method == "GET" && id == "":
+		sessions, err := h.sessions(sub)
+		if err != nil {
+			h.respondAdminFailure(w, r, admin, "list sessions", err)
+			return
+		}
+		respondAdminJSON(w, 200, sessions)
+	case r.Method == "DELETE" && id != "":
+		s, exist, err := h.session(id)
+		if err != nil {
+			h.respondAdminFailure(w, r, admin, "revoke session "+id, err)
+			return
+		}
+		if !exist {
+			respondAdminError(w, 404, "Session not found")
+			return
+		}
+		action := fmt.Sprintf("revoke session %v of %v", s.ID, s.Sub)
+		if err := h.revokeToken(model.UserInfo{ID: s.ID, Sub: s.Sub, Expiry: s.Expiry}); err != nil {
+			h.respondAdminFailure(w, r, admin, action, err)
+			return
+		}
+		// otherwise the session would be continued by its refresh token
+		if s.RefreshFamily != "" {
+			if err := h.revokeRefreshFamily(s.RefreshFamily); err != nil {
+				h.respondAdminFailure(w, r, admin, action, err)
+				return
+			}
+		}
+		h.auditAdmin(r, admin, logging.AuditSuccess, action)
+		w.WriteHeader(204)
+	case r.Method == "DELETE" && sub != "":
+		action := "revoke all sessions of " + sub
+		if err := h.revokeAllTokens(sub); err != nil {
+			h.respondAdminFailure(w, r, admin, action, err)
+			return
+		}
+		h.auditAdmin(r, admin, logging.AuditSuccess, action)
+		w.WriteHeader(204)
+	default:
+		respondAdminError(w, 405, "Method not allowed")
+	}
+}
+
+// Responds the error of a backend or the store, where the errors of invalid requests are no server errors
+func (h *Handler) respondAdminFailure(w http.ResponseWriter, r *http.Request, admin model.UserInfo, action string, err error) {
+	switch {
+	case errors.Is(err, ErrUserNotFound):
+		respondAdminError(w, 404, "User not found")
+	case errors.Is(err, ErrUserExists):
+		h.auditAdmin(r, admin, logging.AuditFailure, action+": user exists already")
+		respondAdminError(w, 409, "User exists already")
+	case errors.Is(err, ErrInvalidUsername), errors.Is(err, errInvalidUserFileEntry):
+		h.auditAdmin(r, admin, logging.AuditFailure, action+": "+err.Error())
+		respondAdminError(w, 400, err.Error())
+	default:
+		logging.Application(r.Header).WithError(err).Error()
+		h.auditAdmin(r, admin, logging.AuditError, action+": "+err.Error())
+		respondAdminError(w, 500, "Internal Server Error")
+	}
+}
+
+func (h *Handler) auditAdmin(r *http.Request, admin model.UserInfo, outcome, action string) {
+	logging.Application(r.Header).
+		WithField("username", admin.Sub).Infof("admin api: %v (%v)", action, outcome)
+	logging.Audit(r, logging.AuditEvent{
+		Event: logging.AuditAdmin, Subject: admin.Sub, Origin: admin.Origin, Outcome: outcome, Reason: action})
+}
+
+func respondAdminJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v) // ignore error of encoding
+}
+
+func respondAdminError(w http.ResponseWriter, status int, message string) {
+	respondAdminJSON(w, status, map[string]string{"error": message})
+}
+
+// Converts the values of the YAML decoder to values, which can be encoded as JSON
+func jsonValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		return jsonValue(stringKeys(v))
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for k, nested := range v {
+			result[k] = jsonValue(nested)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, nested := range v {
+			result[i] = jsonValue(nested)
+		}
+		return result
+	}
+	return value
+}
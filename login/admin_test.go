@@ -0,0 +1,273 @@
+package login
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pchchv/logsrv/logging"
+	"github.com/pchchv/logsrv/model"
+	. "github.com/stretchr/testify/assert"
+)
+
+// Backend with users in memory, which can be managed by the admin api
+type userManagerTestBackend map[string]*ManagedUser
+
+func (b userManagerTestBackend) Authenticate(username, password string) (bool, model.UserInfo, error) {
+	return false, model.UserInfo{}, nil
+}
+
+func (b userManagerTestBackend) Users() ([]ManagedUser, error) {
+	users := []ManagedUser{}
+	for _, u := range b {
+		users = append(users, *u)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Name < users[j].Name })
+	return users, nil
+}
+
+func (b userManagerTestBackend) AddUser(username, password string) error {
+	if username == "" {
+		return ErrInvalidUsername
+	}
+	if _, exist := b[username]; exist {
+		return ErrUserExists
+	}
+	b[username] = &ManagedUser{Name: username}
+	return nil
+}
+
+func (b userManagerTestBackend) SetPassword(username, password string) error {
+	if _, exist := b[username]; !exist {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (b userManagerTestBackend) SetDisabled(username string, disabled bool) error {
+	u, exist := b[username]
+	if !exist {
+		return ErrUserNotFound
+	}
+	u.Disabled = disabled
+	return nil
+}
+
+func (b userManagerTestBackend) DeleteUser(username string) error {
+	if _, exist := b[username]; !exist {
+		return ErrUserNotFound
+	}
+	delete(b, username)
+	return nil
+}
+
+func adminTestHandler(t *testing.T) (*Handler, userManagerTestBackend, string) {
+	backend := userManagerTestBackend{"bob": {Name: "bob"}}
+	h := testHandler()
+	h.config.AdminPath = "/context/admin"
+	h.backends = append(h.backends, backend)
+	token, err := h.createToken(model.UserInfo{Sub: "admin", Groups: []string{"admin"}, Expiry: time.Now().Add(time.Minute).Unix()})
+	NoError(t, err)
+	return h, backend, "Authorization: Bearer " + token
+}
+
+func TestHandler_Admin_Access(t *testing.T) {
+	h, _, _ := adminTestHandler(t)
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/admin/users", ""))
+	Equal(t, 401, recorder.Code)
+
+	token, err := h.createToken(model.UserInfo{Sub: "bob", Groups: []string{"users"}, Expiry: time.Now().Add(time.Minute).Unix()})
+	NoError(t, err)
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/admin/users", "", "Authorization: Bearer "+token))
+	Equal(t, 403, recorder.Code)
+	Equal(t, `{"error":"Access denied"}`, strings.TrimSpace(recorder.Body.String()))
+
+	// without admin path, the admin api does not exist
+	h.config.AdminPath = ""
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/admin/users", ""))
+	Equal(t, 404, recorder.Code)
+}
+
+func TestHandler_Admin_Users(t *testing.T) {
+	b := &auditBuffer{}
+	NoError(t, logging.SetAuditLog(b))
+	defer logging.SetAuditLog(nil)
+	h, backend, bearer := adminTestHandler(t)
+
+	// writes need a JSON body
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/admin/users", "name=alice&password=alice-secret", TypeForm, bearer))
+	Equal(t, 415, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/admin/users", `{"name": "alice", "password": "short"}`, TypeJSON, bearer))
+	Equal(t, 400, recorder.Code)
+	Contains(t, recorder.Body.String(), "at least 8 characters")
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/admin/users", `{"name": "alice", "password": "alice-secret"}`, TypeJSON, bearer))
+	Equal(t, 201, recorder.Code)
+	Contains(t, backend, "alice")
+
+	e := logging.AuditEvent{}
+	NoError(t, json.Unmarshal([]byte(strings.TrimSpace(b.String())), &e))
+	Equal(t, logging.AuditAdmin, e.Event)
+	Equal(t, logging.AuditSuccess, e.Outcome)
+	Equal(t, "admin", e.Subject)
+	Equal(t, "add user alice", e.Reason)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/admin/users", `{"name": "alice", "password": "alice-secret"}`, TypeJSON, bearer))
+	Equal(t, 409, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("PATCH", "/context/admin/users/alice", `{"disabled": true}`, TypeJSON, bearer))
+	Equal(t, 204, recorder.Code)
+	True(t, backend["alice"].Disabled)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/admin/users", "", bearer))
+	Equal(t, 200, recorder.Code)
+	Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+	users := []ManagedUser{}
+	NoError(t, json.Unmarshal(recorder.Body.Bytes(), &users))
+	Equal(t, []ManagedUser{{Name: "alice", Disabled: true}, {Name: "bob"}}, users)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("PATCH", "/context/admin/users/carol", `{"password": "carol-secret"}`, TypeJSON, bearer))
+	Equal(t, 404, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("DELETE", "/context/admin/users/alice", "", bearer))
+	Equal(t, 204, recorder.Code)
+	NotContains(t, backend, "alice")
+}
+
+func TestHandler_Admin_Users_RevokesTokens(t *testing.T) {
+	h, _, bearer := adminTestHandler(t)
+	// tokens issued in the second of the revocation are revoked as well
+	token, err := h.createToken(model.UserInfo{Sub: "bob", IssuedAt: time.Now().Unix(), Expiry: time.Now().Add(time.Minute).Unix()})
+	NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("PATCH", "/context/admin/users/bob", `{"disabled": true}`, TypeJSON, bearer))
+	Equal(t, 204, recorder.Code)
+
+	_, valid := h.GetToken(req("GET", "/context/login", "", "Authorization: Bearer "+token))
+	False(t, valid)
+}
+
+func TestHandler_Admin_NoUserManager(t *testing.T) {
+	h, _, bearer := adminTestHandler(t)
+	h.backends = h.backends[:1]
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/admin/users", "", bearer))
+	Equal(t, 404, recorder.Code)
+}
+
+func TestHandler_Admin_Claims(t *testing.T) {
+	h, _, bearer := adminTestHandler(t)
+	file := filepath.Join(t.TempDir(), "users.yml")
+	NoError(t, os.WriteFile(file, []byte(claimsExample), 0600))
+	userFile, err := newUserClaimsFile(file)
+	NoError(t, err)
+	h.config.UserFile = file
+	h.userFile = userFile
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/admin/claims", "", bearer))
+	Equal(t, 200, recorder.Code)
+	entries := []map[string]interface{}{}
+	NoError(t, json.Unmarshal(recorder.Body.Bytes(), &entries))
+	Equal(t, 5, len(entries))
+	Equal(t, "bob", entries[0]["sub"])
+	Equal(t, map[string]interface{}{"role": "superAdmin"}, entries[0]["claims"])
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("PUT", "/context/admin/claims", `[{"sub": "bob", "totp_secret": "not base32!"}]`, TypeJSON, bearer))
+	Equal(t, 400, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("PUT", "/context/admin/claims", `[{"sub": "alice", "claims": {"role": "admin"}}]`, TypeJSON, bearer))
+	Equal(t, 204, recorder.Code)
+
+	reloaded, err := newUserClaimsFile(file)
+	NoError(t, err)
+	Equal(t, 1, len(reloaded.entries()))
+	Equal(t, "alice", reloaded.entries()[0].Sub)
+
+	claims, err := h.userFile.Claims(model.UserInfo{Sub: "alice"})
+	NoError(t, err)
+	Equal(t, "admin", claims.(customClaims)["role"])
+}
+
+func TestHandler_Admin_Sessions(t *testing.T) {
+	h, _, bearer := adminTestHandler(t)
+	_, err := h.createToken(model.UserInfo{Sub: "bob", Origin: "simple", Expiry: time.Now().Add(time.Minute).Unix()})
+	NoError(t, err)
+	_, err = h.createToken(model.UserInfo{Sub: "bob", Origin: "simple", Expiry: time.Now().Add(time.Minute).Unix()})
+	NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/admin/sessions", "", bearer))
+	Equal(t, 200, recorder.Code)
+	sessions := []session{}
+	NoError(t, json.Unmarshal(recorder.Body.Bytes(), &sessions))
+	Equal(t, 3, len(sessions))
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/admin/sessions?sub=bob", "", bearer))
+	sessions = []session{}
+	NoError(t, json.Unmarshal(recorder.Body.Bytes(), &sessions))
+	Equal(t, 2, len(sessions))
+	Equal(t, "simple", sessions[0].Origin)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("DELETE", "/context/admin/sessions/"+sessions[0].ID, "", bearer))
+	Equal(t, 204, recorder.Code)
+	revoked, err := h.isRevoked(model.UserInfo{ID: sessions[0].ID, Sub: "bob"})
+	NoError(t, err)
+	True(t, revoked)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("DELETE", "/context/admin/sessions/"+sessions[0].ID, "", bearer))
+	Equal(t, 404, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("DELETE", "/context/admin/sessions?sub=bob", "", bearer))
+	Equal(t, 204, recorder.Code)
+	remaining, err := h.sessions("bob")
+	NoError(t, err)
+	Equal(t, 0, len(remaining))
+}
+
+func TestHandler_Admin_Sessions_RevokesRefreshFamily(t *testing.T) {
+	h, _, bearer := adminTestHandler(t)
+	h.config.RefreshTokenExpiry = time.Hour
+	_, refreshToken := loginWithRefresh(t, h)
+	// the rotated refresh token stays in the family of the login
+	recorder := refresh(h, refreshToken)
+	Equal(t, 200, recorder.Code)
+	refreshToken = findCookie(recorder, h.config.RefreshCookieName).Value
+
+	sessions, err := h.sessions("bob")
+	NoError(t, err)
+	Equal(t, 2, len(sessions))
+	NotEmpty(t, sessions[0].RefreshFamily)
+	Equal(t, sessions[1].RefreshFamily, sessions[0].RefreshFamily)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("DELETE", "/context/admin/sessions/"+sessions[0].ID, "", bearer))
+	Equal(t, 204, recorder.Code)
+	Equal(t, 403, refresh(h, refreshToken).Code)
+}
@@ -1,6 +1,9 @@
 package login
 
-import "github.com/pchchv/logsrv/model"
+import (
+	"github.com/pchchv/logsrv/model"
+	"github.com/pkg/errors"
+)
 
 // Logsrv authentication extension
 type Backend interface {
@@ -10,3 +13,50 @@ type Backend interface {
 	// The error parameter is nil, unless a communication error with the backend occurred
 	Authenticate(username, password string) (bool, model.UserInfo, error)
 }
+
+// Optional interface for backends, which can check a user without the password,
+// e.g. before a token is issued for a refresh token.
+type UserValidator interface {
+	// ValidateUser returns false, if the user is no longer valid, e.g. deleted or locked.
+	// Users of other backends are reported as valid.
+	ValidateUser(userInfo model.UserInfo) (bool, error)
+}
+
+// Optional interface for backends and the user claims provider, which depend on a file or a server,
+// e.g. for the readiness probe.
+type HealthChecker interface {
+	// CheckHealth returns an error, if the backend can not serve logins, e.g. the server is not reachable.
+	CheckHealth() error
+}
+
+// Optional interface for backends, which store the passwords of their users, e.g. in a file.
+type PasswordChanger interface {
+	// ChangePassword replaces the password of the user, if the old password matches.
+	// It returns false, if the user is unknown or the old password does not match.
+	ChangePassword(username, oldPassword, newPassword string) (bool, error)
+}
+
+// Returned by a UserManager for a user, which is not found, exists already or can not be stored
+var (
+	ErrUserNotFound    = errors.New("user not found")
+	ErrUserExists      = errors.New("user exists already")
+	ErrInvalidUsername = errors.New("invalid username")
+)
+
+// A user of a UserManager
+type ManagedUser struct {
+	Name     string `json:"name"`
+	Disabled bool   `json:"disabled"`
+}
+
+// Optional interface for backends, which store their users themselves, e.g. in a file.
+// It is used by the admin API.
+type UserManager interface {
+	// Users returns all users sorted by name
+	Users() ([]ManagedUser, error)
+	AddUser(username, password string) error
+	SetPassword(username, password string) error
+	// SetDisabled disables or enables a user, disabled users can not log in
+	SetDisabled(username string, disabled bool) error
+	DeleteUser(username string) error
+}
@@ -0,0 +1,112 @@
+package login
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Options of a backend, which are used for the routing and are not passed to the provider
+const (
+	backendMatchOption   = "match"
+	backendOnErrorOption = "on_error"
+
+	onErrorFail = "fail"
+	onErrorNext = "next"
+)
+
+// Decides, which users are authenticated by a backend of the chain
+// and how an error of the backend is handled.
+type backendRoute struct {
+	name string
+	// patterns for the username, e.g. *@corp.example
+	include []string
+	// patterns for the username, which are given with a leading '!'
+	exclude []string
+	// try the next backend on an error instead of failing the login
+	nextOnError bool
+}
+
+// Takes the routing options out of the backend options
+func newBackendRoute(name string, opts map[string]string) (backendRoute, map[string]string, error) {
+	route := backendRoute{name: name}
+	providerOpts := map[string]string{}
+	for k, v := range opts {
+		providerOpts[k] = v
+	}
+	if match, exist := providerOpts[backendMatchOption]; exist {
+		delete(providerOpts, backendMatchOption)
+		for _, pattern := range strings.Split(match, ";") {
+			pattern = strings.TrimSpace(pattern)
+			exclude := strings.HasPrefix(pattern, "!")
+			pattern = strings.TrimPrefix(pattern, "!")
+			if pattern == "" {
+				continue
+			}
+			if _, err := path.Match(pattern, ""); err != nil {
+				return route, nil, errors.Errorf("invalid pattern %q in %q of backend %v", pattern, backendMatchOption, name)
+			}
+			if exclude {
+				route.exclude = append(route.exclude, pattern)
+			} else {
+				route.include = append(route.include, pattern)
+			}
+		}
+	}
+	if onError, exist := providerOpts[backendOnErrorOption]; exist {
+		delete(providerOpts, backendOnErrorOption)
+		switch onError {
+		case onErrorFail:
+		case onErrorNext:
+			route.nextOnError = true
+		default:
+			return route, nil, errors.Errorf("invalid value %q of %q for backend %v, expected %v or %v",
+				onError, backendOnErrorOption, name, onErrorFail, onErrorNext)
+		}
+	}
+	return route, providerOpts, nil
+}
+
+// A user matches, if no include pattern is given or one of them matches, and no exclude pattern matches
+func (route backendRoute) matches(username string) bool {
+	for _, pattern := range route.exclude {
+		if matched, _ := path.Match(pattern, username); matched {
+			return false
+		}
+	}
+	if len(route.include) == 0 {
+		return true
+	}
+	for _, pattern := range route.include {
+		if matched, _ := path.Match(pattern, username); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the names of the backends in the order of the chain.
+// The backends in the order come first, all others follow sorted by name.
+func backendOrder(backends Options, order string) ([]string, error) {
+	names := []string{}
+	seen := map[string]bool{}
+	for _, name := range splitList(order) {
+		if _, exist := backends[name]; !exist {
+			return nil, errors.Errorf("backend %v of the backend order is not configured", name)
+		}
+		if !seen[name] {
+			names = append(names, name)
+			seen[name] = true
+		}
+	}
+	rest := []string{}
+	for name := range backends {
+		if !seen[name] {
+			rest = append(rest, name)
+		}
+	}
+	sort.Strings(rest)
+	return append(names, rest...), nil
+}
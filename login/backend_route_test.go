@@ -0,0 +1,148 @@
+package login
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/pchchv/logsrv/model"
+	. "github.com/stretchr/testify/assert"
+)
+
+type routeTestBackend struct {
+	users map[string]string
+	err   error
+	calls *[]string
+	name  string
+}
+
+func (b routeTestBackend) Authenticate(username, password string) (bool, model.UserInfo, error) {
+	*b.calls = append(*b.calls, b.name)
+	if b.err != nil {
+		return false, model.UserInfo{}, b.err
+	}
+	if p, exist := b.users[username]; exist && p == password {
+		return true, model.UserInfo{Sub: username, Origin: b.name}, nil
+	}
+	return false, model.UserInfo{}, nil
+}
+
+func Test_newBackendRoute(t *testing.T) {
+	route, opts, err := newBackendRoute("ldap", map[string]string{
+		"url":      "ldap://localhost",
+		"match":    "*@corp.example; !admin@corp.example",
+		"on_error": "next",
+	})
+	NoError(t, err)
+	Equal(t, backendRoute{
+		name:        "ldap",
+		include:     []string{"*@corp.example"},
+		exclude:     []string{"admin@corp.example"},
+		nextOnError: true,
+	}, route)
+	Equal(t, map[string]string{"url": "ldap://localhost"}, opts)
+
+	route, opts, err = newBackendRoute("simple", map[string]string{"bob": "secret"})
+	NoError(t, err)
+	Equal(t, backendRoute{name: "simple"}, route)
+	Equal(t, map[string]string{"bob": "secret"}, opts)
+
+	_, _, err = newBackendRoute("ldap", map[string]string{"on_error": "ignore"})
+	Error(t, err)
+	_, _, err = newBackendRoute("ldap", map[string]string{"match": "[a-"})
+	Error(t, err)
+}
+
+func Test_backendRoute_matches(t *testing.T) {
+	route := backendRoute{include: []string{"*@corp.example", "bob"}, exclude: []string{"admin@*"}}
+	True(t, route.matches("alice@corp.example"))
+	True(t, route.matches("bob"))
+	False(t, route.matches("alice@other.example"))
+	False(t, route.matches("admin@corp.example"))
+	True(t, backendRoute{}.matches("anyone"))
+	False(t, backendRoute{exclude: []string{"*@corp.example"}}.matches("alice@corp.example"))
+}
+
+func Test_backendOrder(t *testing.T) {
+	backends := Options{"simple": {}, "htpasswd": {}, "ldap": {}}
+	order, err := backendOrder(backends, "")
+	NoError(t, err)
+	Equal(t, []string{"htpasswd", "ldap", "simple"}, order)
+	order, err = backendOrder(backends, "simple, ldap")
+	NoError(t, err)
+	Equal(t, []string{"simple", "ldap", "htpasswd"}, order)
+	_, err = backendOrder(backends, "osiam")
+	Error(t, err)
+}
+
+func TestHandler_authenticate_Chain(t *testing.T) {
+	calls := []string{}
+	corp := routeTestBackend{name: "ldap", users: map[string]string{"alice@corp.example": "secret"}, calls: &calls}
+	local := routeTestBackend{name: "htpasswd", users: map[string]string{"bob": "secret", "alice@corp.example": "local"}, calls: &calls}
+	h := testHandler()
+	h.backends = []Backend{corp, local}
+	h.routingRules = []backendRoute{
+		{name: "ldap", include: []string{"*@corp.example"}},
+		{name: "htpasswd", exclude: []string{"*@corp.example"}},
+	}
+
+	authenticated, userInfo, err := h.authenticate("alice@corp.example", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+	Equal(t, "ldap", userInfo.Origin)
+	Equal(t, []string{"ldap"}, calls)
+
+	// corp users are not authenticated by the local backend
+	calls = calls[:0]
+	authenticated, _, err = h.authenticate("alice@corp.example", "local")
+	NoError(t, err)
+	False(t, authenticated)
+	Equal(t, []string{"ldap"}, calls)
+
+	calls = calls[:0]
+	authenticated, userInfo, err = h.authenticate("bob", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+	Equal(t, "htpasswd", userInfo.Origin)
+	Equal(t, []string{"htpasswd"}, calls)
+}
+
+func TestHandler_authenticate_OnError(t *testing.T) {
+	calls := []string{}
+	failing := routeTestBackend{name: "ldap", err: errors.New("connection refused"), calls: &calls}
+	local := routeTestBackend{name: "htpasswd", users: map[string]string{"bob": "secret"}, calls: &calls}
+	h := testHandler()
+	h.backends = []Backend{failing, local}
+
+	// fail closed by default
+	h.routingRules = []backendRoute{{name: "ldap"}, {name: "htpasswd"}}
+	_, _, err := h.authenticate("bob", "secret")
+	Error(t, err)
+	Equal(t, []string{"ldap"}, calls)
+
+	// fall through to the next backend
+	h.routingRules = []backendRoute{{name: "ldap", nextOnError: true}, {name: "htpasswd"}}
+	calls = calls[:0]
+	authenticated, userInfo, err := h.authenticate("bob", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+	Equal(t, "bob", userInfo.Sub)
+	Equal(t, []string{"ldap", "htpasswd"}, calls)
+
+	// the error is reported, if no other backend authenticated the user
+	_, _, err = h.authenticate("bob", "wrong")
+	EqualError(t, err, "connection refused")
+}
+
+func TestHandler_NewFromConfig_BackendOrder(t *testing.T) {
+	config := testConfig()
+	config.Backends = Options{"simple": {"bob": "secret", "match": "bob", "on_error": "next"}}
+	h, err := NewHandler(config)
+	NoError(t, err)
+	Equal(t, []backendRoute{{name: "simple", include: []string{"bob"}, nextOnError: true}}, h.routingRules)
+	// the routing options are no users of the simple backend
+	Equal(t, map[string]string{"bob": "secret"}, h.backends[0].(*SimpleBackend).userPassword)
+
+	config.BackendOrder = "htpasswd"
+	_, err = NewHandler(config)
+	Error(t, err)
+}
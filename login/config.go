@@ -16,6 +16,7 @@ import (
 
 // Config for the loginsrv handler
 type Config struct {
+	ConfigFile             string
 	Host                   string
 	Port                   string
 	LogLevel               string
@@ -23,7 +24,11 @@ type Config struct {
 	JwtSecret              string
 	JwtSecretFile          string
 	JwtAlgo                string
+	JwtVerifyKeys          string
 	JwtExpiry              time.Duration
+	JwtIssuer              string
+	JwtAudience            string
+	JwtLeeway              time.Duration
 	JwtRefreshes           int
 	SuccessURL             string
 	Redirect               bool
@@ -38,19 +43,78 @@ type Config struct {
 	CookieDomain           string
 	CookieHTTPOnly         bool
 	CookieSecure           bool
+	TokenLookup            string
 	Backends               Options
+	BackendOrder           string
 	Oauth                  Options
+	Saml                   Options
 	GracePeriod            time.Duration
 	UserFile               string
 	UserEndpoint           string
 	UserEndpointToken      string
 	UserEndpointTimeout    time.Duration
+	TOTPFile               string
+	TrustedProxies         string
+	LoginRateIP            int
+	LoginRateUser          int
+	LockoutThreshold       int
+	LockoutDuration        time.Duration
+	PasswordMinLength      int
+	PasswordBreachedFile   string
+	AdminPath              string
+	AdminGroup             string
+	Store                  string
+	StoreFile              string
+	ServerSessions         bool
+	TokenExchangeAudiences string
+	TokenExchangeClaims    string
+	TokenExchangeExpiry    time.Duration
+	TokenExchangeClients   Options
+	RefreshTokenExpiry     time.Duration
+	RefreshCookieName      string
+	MetricsPath            string
+	AuditLog               string
+	AuditLogMaxSize        int
+	AuditLogMaxBackups     int
 }
 
 // Configuration structure for oauth and backend provider
 // key is the providername, value is a options map
 type Options map[string]map[string]string
 
+// Keys of the options of providers and clients, which hold secrets
+var secretOptionKeys = []string{"secret", "client_secret", "bind_password", "password"}
+
+// Returns a copy of the config for logging, in which the jwt secret
+// and the secrets of the backends, oauth providers, saml and token exchange clients are masked
+func (c *Config) Redacted() Config {
+	redacted := *c
+	redacted.JwtSecret = "..."
+	redacted.Backends = c.Backends.redacted()
+	redacted.Oauth = c.Oauth.redacted()
+	redacted.Saml = c.Saml.redacted()
+	redacted.TokenExchangeClients = c.TokenExchangeClients.redacted()
+	return redacted
+}
+
+// Returns a deep copy with masked secrets, all options of the simple backend are passwords
+func (o Options) redacted() Options {
+	if o == nil {
+		return nil
+	}
+	redacted := Options{}
+	for name, opts := range o {
+		redacted[name] = map[string]string{}
+		for k, v := range opts {
+			if name == SimpleProviderName || contains(secretOptionKeys, k) {
+				v = "..."
+			}
+			redacted[name][k] = v
+		}
+	}
+	return redacted
+}
+
 var jwtDefaultSecret string
 
 const envPrefix = "LOGSRV_"
@@ -69,10 +133,49 @@ func (c *Config) addOauthOpts(providerName, optsKvList string) error {
 	if err != nil {
 		return err
 	}
+	// a name option configures a named instance of the provider,
+	// so that e.g. multiple oidc issuers can be used side by side
+	if name, exist := opts["name"]; exist {
+		delete(opts, "name")
+		opts["provider"] = providerName
+		c.Oauth[name] = opts
+		return nil
+	}
 	c.Oauth[providerName] = opts
 	return nil
 }
 
+// Adds the options for a SAML identity provider in the form of name=..,key=value,key=value...
+// The name is the last segment of its path, e.g. /login/saml/corp for name=corp.
+func (c *Config) addSamlOpts(optsKvList string) error {
+	opts, err := parseOptions(optsKvList)
+	if err != nil {
+		return err
+	}
+	name, exist := opts["name"]
+	if !exist {
+		return errors.New("missing name of the saml identity provider name=...")
+	}
+	delete(opts, "name")
+	c.Saml[name] = opts
+	return nil
+}
+
+// Adds a client of the token exchange in the form of id=..,secret=..[,audiences=a;b]
+func (c *Config) addTokenExchangeClientOpts(optsKvList string) error {
+	opts, err := parseOptions(optsKvList)
+	if err != nil {
+		return err
+	}
+	id, exist := opts["id"]
+	if !exist {
+		return errors.New("missing id of the token exchange client id=...")
+	}
+	delete(opts, "id")
+	c.TokenExchangeClients[id] = opts
+	return nil
+}
+
 // Adds the options for a provider in the form of key=value,key=value...
 func (c *Config) addBackendOpts(providerName, optsKvList string) error {
 	opts, err := parseOptions(optsKvList)
@@ -98,6 +201,7 @@ func (c *Config) ResolveFileReferences() error {
 
 // Adds all flags to the supplied flag set
 func (c *Config) ConfigureFlagSet(f *flag.FlagSet) {
+	f.StringVar(&c.ConfigFile, "config", c.ConfigFile, "A YAML or TOML config file. Environment variables and flags take precedence over the file")
 	f.StringVar(&c.Host, "host", c.Host, "The host to listen on")
 	f.StringVar(&c.Port, "port", c.Port, "The port to listen on")
 	f.StringVar(&c.LogLevel, "log-level", c.LogLevel, "The log level")
@@ -105,11 +209,16 @@ func (c *Config) ConfigureFlagSet(f *flag.FlagSet) {
 	f.StringVar(&c.JwtSecret, "jwt-secret", c.JwtSecret, "The secret to sign the jwt token")
 	f.StringVar(&c.JwtSecretFile, "jwt-secret-file", c.JwtSecretFile, "Path to a file containing the secret to sign the jwt token (overrides jwt-secret)")
 	f.StringVar(&c.JwtAlgo, "jwt-algo", c.JwtAlgo, "The singing algorithm to use (ES256, ES384, ES512, RS256, RS384, RS512, HS256, HS384, HS512")
+	f.StringVar(&c.JwtVerifyKeys, "jwt-verify-keys", c.JwtVerifyKeys, "A directory or ';' separated list of key files, which are accepted for verification only, e.g. the previous keys on a key rotation")
 	f.DurationVar(&c.JwtExpiry, "jwt-expiry", c.JwtExpiry, "The expiry duration for the jwt token, e.g. 2h or 3h30m")
+	f.StringVar(&c.JwtIssuer, "jwt-issuer", c.JwtIssuer, "The iss claim of issued tokens, which is required for accepted tokens")
+	f.StringVar(&c.JwtAudience, "jwt-audience", c.JwtAudience, "The aud claim of issued tokens, which is required for accepted tokens")
+	f.DurationVar(&c.JwtLeeway, "jwt-leeway", c.JwtLeeway, "The leeway for the clock skew on the validation of exp, nbf and iat, e.g. 30s")
 	f.IntVar(&c.JwtRefreshes, "jwt-refreshes", c.JwtRefreshes, "The maximum amount of jwt refreshes. 0 by Default")
 	f.StringVar(&c.CookieName, "cookie-name", c.CookieName, "The name of the jwt cookie")
 	f.BoolVar(&c.CookieHTTPOnly, "cookie-http-only", c.CookieHTTPOnly, "Set the cookie with the http only flag")
 	f.BoolVar(&c.CookieSecure, "cookie-secure", c.CookieSecure, "Set the cookie with the secure flag")
+	f.StringVar(&c.TokenLookup, "token-lookup", c.TokenLookup, "Comma separated sources of the jwt in the order, in which they are searched: cookie and header (Authorization: Bearer)")
 	f.DurationVar(&c.CookieExpiry, "cookie-expiry", c.CookieExpiry, "The expiry duration for the cookie, e.g. 2h or 3h30m. Default is browser session")
 	f.StringVar(&c.CookieDomain, "cookie-domain", c.CookieDomain, "The optional domain parameter for the cookie")
 	f.StringVar(&c.SuccessURL, "success-url", c.SuccessURL, "The url to redirect after login")
@@ -120,11 +229,35 @@ func (c *Config) ConfigureFlagSet(f *flag.FlagSet) {
 	f.StringVar(&c.LogoutURL, "logout-url", c.LogoutURL, "The url or path to redirect after logout")
 	f.StringVar(&c.Template, "template", c.Template, "An alternative template for the login form")
 	f.StringVar(&c.LoginPath, "login-path", c.LoginPath, "The path of the login resource")
+	f.StringVar(&c.BackendOrder, "backend-order", c.BackendOrder, "Comma separated backends in the order, in which they are tried. Other backends follow sorted by name")
 	f.DurationVar(&c.GracePeriod, "grace-period", c.GracePeriod, "Graceful shutdown grace period")
 	f.StringVar(&c.UserFile, "user-file", c.UserFile, "A YAML file with user specific data for the tokens")
 	f.StringVar(&c.UserEndpoint, "user-endpoint", c.UserEndpoint, "URL of an endpoint providing user specific data for the tokens")
 	f.StringVar(&c.UserEndpointToken, "user-endpoint-token", c.UserEndpointToken, "Authentication token used when communicating with the user endpoint")
 	f.DurationVar(&c.UserEndpointTimeout, "user-endpoint-timeout", c.UserEndpointTimeout, "Timeout used when communicating with the user endpoint")
+	f.StringVar(&c.TOTPFile, "totp-file", c.TOTPFile, "A YAML file with the TOTP secrets of users, which need a second factor, e.g. 'bob: JBSWY3DPEHPK3PXP'")
+	f.StringVar(&c.TrustedProxies, "trusted-proxies", c.TrustedProxies, "Comma separated networks (CIDR) or ips of reverse proxies, whose X-Real-Ip and X-Cluster-Client-Ip headers are trusted for the client ip")
+	f.IntVar(&c.LoginRateIP, "login-rate-ip", c.LoginRateIP, "The maximum login attempts per minute and ip, 0 disables the limit")
+	f.IntVar(&c.LoginRateUser, "login-rate-user", c.LoginRateUser, "The maximum login attempts per minute and username, 0 disables the limit")
+	f.IntVar(&c.LockoutThreshold, "lockout-threshold", c.LockoutThreshold, "The failed logins, after which a user is locked out, 0 disables the lockout")
+	f.DurationVar(&c.LockoutDuration, "lockout-duration", c.LockoutDuration, "The duration of the first lockout, doubled with every further failed login")
+	f.IntVar(&c.PasswordMinLength, "password-min-length", c.PasswordMinLength, "The minimum length of a new password on a password change")
+	f.StringVar(&c.PasswordBreachedFile, "password-breached-file", c.PasswordBreachedFile, "A file with breached passwords or their SHA-1 hashes, one per line, which are rejected on a password change")
+	f.StringVar(&c.AdminPath, "admin-path", c.AdminPath, "The path of the admin api for users and sessions, empty disables the admin api")
+	f.StringVar(&c.AdminGroup, "admin-group", c.AdminGroup, "The group, which is required for the admin api")
+	f.StringVar(&c.Store, "store", c.Store, "The store for server side state like token revocations (memory, file, bolt)")
+	f.StringVar(&c.StoreFile, "store-file", c.StoreFile, "The file of the file or bolt store")
+	f.BoolVar(&c.ServerSessions, "server-sessions", c.ServerSessions, "Keep the jwt in the store and set only an opaque session id as cookie")
+	f.StringVar(&c.TokenExchangeAudiences, "token-exchange-audiences", c.TokenExchangeAudiences, "Comma separated audiences, for which tokens can be exchanged. Empty disables the token exchange")
+	f.StringVar(&c.TokenExchangeClaims, "token-exchange-claims", c.TokenExchangeClaims, "Comma separated claims, which are copied into exchanged tokens in addition to sub")
+	f.DurationVar(&c.TokenExchangeExpiry, "token-exchange-expiry", c.TokenExchangeExpiry, "The expiry of exchanged tokens, which never outlive the original token")
+	f.Var(wrapFunc(c.addTokenExchangeClientOpts), "token-exchange-client", "Client of the token exchange in the form: id=..[,secret=..][,origin=..][,audiences=a;b], where the origin is the backend of the service account of the client for actor tokens and the audiences are those of exchanged tokens, which the client may exchange again. Can be repeated")
+	f.DurationVar(&c.RefreshTokenExpiry, "refresh-token-expiry", c.RefreshTokenExpiry, "The expiry duration of refresh tokens, e.g. 720h. Refresh tokens are disabled by default")
+	f.StringVar(&c.RefreshCookieName, "refresh-cookie-name", c.RefreshCookieName, "The name of the refresh token cookie")
+	f.StringVar(&c.AuditLog, "audit-log", c.AuditLog, "A file for the audit log in JSON lines, 'syslog' for the local syslog or 'syslog://host:port' for a remote one. Empty disables the audit log")
+	f.IntVar(&c.AuditLogMaxSize, "audit-log-max-size", c.AuditLogMaxSize, "The size in megabytes, after which the audit log file is rotated")
+	f.IntVar(&c.AuditLogMaxBackups, "audit-log-max-backups", c.AuditLogMaxBackups, "The number of rotated audit log files to keep, 0 keeps all")
+	f.StringVar(&c.MetricsPath, "metrics-path", c.MetricsPath, "The path of the prometheus metrics, e.g. /metrics. The metrics need no authentication, so the path should be blocked by the proxy in front. Empty disables the metrics endpoint (default)")
 	// the backends is deprecated, but we support it for backwards compatibility
 	deprecatedBackends := wrapFunc(func(optsKvList string) error {
 		logging.Logger.Warn("DEPRECATED: '-backend' is no longer supported. Please set the backends by explicit parameters")
@@ -150,6 +283,7 @@ func (c *Config) ConfigureFlagSet(f *flag.FlagSet) {
 			f.Var(setter, pName, "Oauth config in the form: client_id=..,client_secret=..[,scope=..,][redirect_uri=..]")
 		}(pName)
 	}
+	f.Var(wrapFunc(c.addSamlOpts), "saml", "SAML identity provider in the form: name=..,idp_metadata_url=..,base_url=..[,binding=redirect|post][,entity_id=..,acs_url=..][,cert=..,key=..]. Can be repeated")
 	// One option for each backend provider
 	for _, pName := range ProviderList() {
 		func(pName string) {
@@ -171,6 +305,9 @@ func DefaultConfig() *Config {
 		JwtSecret:              jwtDefaultSecret,
 		JwtAlgo:                "HS512",
 		JwtExpiry:              24 * time.Hour,
+		JwtIssuer:              "",
+		JwtAudience:            "",
+		JwtLeeway:              0,
 		JwtRefreshes:           0,
 		SuccessURL:             "/",
 		Redirect:               true,
@@ -182,13 +319,39 @@ func DefaultConfig() *Config {
 		CookieName:             "jwt_token",
 		CookieHTTPOnly:         true,
 		CookieSecure:           true,
+		TokenLookup:            "cookie,header",
 		Backends:               Options{},
+		BackendOrder:           "",
 		Oauth:                  Options{},
+		Saml:                   Options{},
 		GracePeriod:            5 * time.Second,
 		UserFile:               "",
 		UserEndpoint:           "",
 		UserEndpointToken:      "",
 		UserEndpointTimeout:    5 * time.Second,
+		TOTPFile:               "",
+		TrustedProxies:         "",
+		LoginRateIP:            0,
+		LoginRateUser:          0,
+		LockoutThreshold:       0,
+		LockoutDuration:        time.Minute,
+		PasswordMinLength:      8,
+		PasswordBreachedFile:   "",
+		AdminPath:              "",
+		AdminGroup:             "admin",
+		Store:                  "memory",
+		StoreFile:              "",
+		ServerSessions:         false,
+		TokenExchangeAudiences: "",
+		TokenExchangeClaims:    "name,email,origin,domain,groups",
+		TokenExchangeExpiry:    5 * time.Minute,
+		TokenExchangeClients:   Options{},
+		RefreshTokenExpiry:     0,
+		RefreshCookieName:      "refresh_token",
+		MetricsPath:            "",
+		AuditLog:               "",
+		AuditLogMaxSize:        100,
+		AuditLogMaxBackups:     10,
 	}
 }
 
@@ -202,10 +365,21 @@ func ReadConfig() *Config {
 	return c
 }
 
+// Reads the config from the config file, the environment and the args, e.g. to reload the config
+func ReadConfigFromArgs(args []string) (*Config, error) {
+	return readConfig(flag.NewFlagSet("logsrv", flag.ContinueOnError), args)
+}
+
 func readConfig(f *flag.FlagSet, args []string) (*Config, error) {
 	config := DefaultConfig()
 	config.ConfigureFlagSet(f)
-	// Fist use the environment settings
+	// The config file has the lowest precedence
+	if file := configFileFromArgs(args); file != "" {
+		if err := config.ReadConfigFile(f, file); err != nil {
+			return nil, err
+		}
+	}
+	// Then use the environment settings
 	f.VisitAll(func(f *flag.Flag) {
 		if val, isPresent := os.LookupEnv(envName(f.Name)); isPresent {
 			err := f.Value.Set(val)
@@ -238,9 +412,11 @@ func envName(flagName string) string {
 	return envPrefix + strings.Replace(strings.ToUpper(flagName), "-", "_", -1)
 }
 
+// Parses options in the form key1=value1,key2=value2.
+// A comma within a value can be escaped as '\,', e.g. for LDAP DNs.
 func parseOptions(b string) (map[string]string, error) {
 	opts := map[string]string{}
-	pairs := strings.Split(b, ",")
+	pairs := splitUnescaped(b, ',')
 	for _, p := range pairs {
 		pair := strings.SplitN(p, "=", 2)
 		if len(pair) != 2 {
@@ -250,3 +426,22 @@ func parseOptions(b string) (map[string]string, error) {
 	}
 	return opts, nil
 }
+
+func splitUnescaped(s string, sep byte) []string {
+	parts := []string{}
+	current := strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && s[i+1] == sep {
+			current.WriteByte(sep)
+			i++
+			continue
+		}
+		if s[i] == sep {
+			parts = append(parts, current.String())
+			current.Reset()
+			continue
+		}
+		current.WriteByte(s[i])
+	}
+	return append(parts, current.String())
+}
@@ -0,0 +1,146 @@
+package login
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Reads the YAML or TOML config file (by the extension .toml) into the config.
+// The keys are the names of the flags, where sections are joined with '-', e.g. jwt.expiry for jwt-expiry.
+// The backends, oauth, saml and token_exchange.clients sections hold the options of the providers or clients as maps.
+func (c *Config) ReadConfigFile(f *flag.FlagSet, file string) error {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return errors.Wrapf(err, "can't read config file %v", file)
+	}
+	values := map[string]interface{}{}
+	if strings.ToLower(filepath.Ext(file)) == ".toml" {
+		err = toml.Unmarshal(b, &values)
+	} else {
+		raw := map[interface{}]interface{}{}
+		err = yaml.Unmarshal(b, &raw)
+		values = stringKeys(raw)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "can't parse config file %v", file)
+	}
+	if err := c.applyConfigFileValues(f, "", values); err != nil {
+		return errors.Wrapf(err, "invalid config file %v", file)
+	}
+	return nil
+}
+
+func (c *Config) applyConfigFileValues(f *flag.FlagSet, prefix string, values map[string]interface{}) error {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	// sorted for deterministic errors
+	sort.Strings(keys)
+	for _, key := range keys {
+		name := prefix + strings.Replace(key, "_", "-", -1)
+		value := values[key]
+		if name == "backends" || name == "oauth" || name == "saml" || name == "token-exchange-clients" {
+			providers, ok := asMap(value)
+			if !ok {
+				return fmt.Errorf("%v has to be a map of providers", name)
+			}
+			if err := c.addProviderOptions(name, providers); err != nil {
+				return err
+			}
+			continue
+		}
+		if section, ok := asMap(value); ok {
+			if err := c.applyConfigFileValues(f, name+"-", section); err != nil {
+				return err
+			}
+			continue
+		}
+		fl := f.Lookup(name)
+		if fl == nil || name == "config" {
+			return fmt.Errorf("unknown parameter %v", name)
+		}
+		if _, isList := value.([]interface{}); isList {
+			return fmt.Errorf("parameter %v can not be a list", name)
+		}
+		if err := fl.Value.Set(fmt.Sprint(value)); err != nil {
+			return fmt.Errorf("invalid value for parameter %v: %v", name, value)
+		}
+	}
+	return nil
+}
+
+// Takes the options of the providers as they are, so that they may contain commas
+func (c *Config) addProviderOptions(section string, providers map[string]interface{}) error {
+	for providerName, value := range providers {
+		options, ok := asMap(value)
+		if !ok && value != nil {
+			return fmt.Errorf("options of %v.%v have to be a map", section, providerName)
+		}
+		opts := map[string]string{}
+		for k, v := range options {
+			opts[k] = fmt.Sprint(v)
+		}
+		switch section {
+		case "backends":
+			c.Backends[providerName] = opts
+		case "saml":
+			c.Saml[providerName] = opts
+		case "token-exchange-clients":
+			c.TokenExchangeClients[providerName] = opts
+		default:
+			c.Oauth[providerName] = opts
+		}
+	}
+	return nil
+}
+
+// Returns the path of the config file from the -config flag or the environment
+func configFileFromArgs(args []string) string {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		name := strings.TrimLeft(arg, "-")
+		if name == arg {
+			continue
+		}
+		if strings.HasPrefix(name, "config=") {
+			return strings.TrimPrefix(name, "config=")
+		}
+		if name == "config" && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return os.Getenv(envName("config"))
+}
+
+func asMap(value interface{}) (map[string]interface{}, bool) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return v, true
+	case map[interface{}]interface{}:
+		return stringKeys(v), true
+	}
+	return nil, false
+}
+
+// Converts the maps of the YAML decoder to maps with string keys
+func stringKeys(m map[interface{}]interface{}) map[string]interface{} {
+	result := map[string]interface{}{}
+	for k, v := range m {
+		if nested, ok := v.(map[interface{}]interface{}); ok {
+			v = stringKeys(nested)
+		}
+		result[fmt.Sprint(k)] = v
+	}
+	return result
+}
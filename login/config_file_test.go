@@ -0,0 +1,195 @@
+package login
+
+import (
+	"flag"
+	"os"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+var configFileYAML = `
+host: 0.0.0.0
+port: 8080
+success_url: /app
+jwt:
+  secret: fileSecret
+  expiry: 2h
+  refreshes: 3
+cookie:
+  name: token
+  http_only: false
+  domain: example.org
+backends:
+  simple:
+    bob: secret
+  ldap:
+    url: ldaps://ldap.example.org
+    base_dn: ou=people,dc=example,dc=org
+    bind_dn: cn=service,ou=apps,dc=example,dc=org
+oauth:
+  github:
+    client_id: id
+    client_secret: secret
+  corp:
+    provider: oidc
+    issuer: https://sso.example.org
+saml:
+  azure:
+    idp_metadata_url: https://login.example.org/federationmetadata.xml
+    binding: post
+token_exchange:
+  audiences: orders
+  clients:
+    orders:
+      secret: orders-secret
+      audiences: orders
+`
+
+var configFileTOML = `
+host = "0.0.0.0"
+port = "8080"
+success_url = "/app"
+
+[jwt]
+secret = "fileSecret"
+expiry = "2h"
+refreshes = 3
+
+[cookie]
+name = "token"
+http_only = false
+domain = "example.org"
+
+[backends.simple]
+bob = "secret"
+
+[backends.ldap]
+url = "ldaps://ldap.example.org"
+base_dn = "ou=people,dc=example,dc=org"
+bind_dn = "cn=service,ou=apps,dc=example,dc=org"
+
+[oauth.github]
+client_id = "id"
+client_secret = "secret"
+
+[oauth.corp]
+provider = "oidc"
+issuer = "https://sso.example.org"
+
+[saml.azure]
+idp_metadata_url = "https://login.example.org/federationmetadata.xml"
+binding = "post"
+
+[token_exchange]
+audiences = "orders"
+
+[token_exchange.clients.orders]
+secret = "orders-secret"
+audiences = "orders"
+`
+
+func writeConfigFile(pattern, content string) string {
+	f, err := os.CreateTemp("", pattern)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(content); err != nil {
+		panic(err)
+	}
+	return f.Name()
+}
+
+func TestConfig_ReadConfigFile(t *testing.T) {
+	for _, file := range []string{
+		writeConfigFile("*.yml", configFileYAML),
+		writeConfigFile("*.toml", configFileTOML),
+	} {
+		defer os.Remove(file)
+		cfg, err := readConfig(flag.NewFlagSet("", flag.ContinueOnError), []string{"-config", file})
+		NoError(t, err, file)
+		expected := DefaultConfig()
+		expected.ConfigFile = file
+		expected.Host = "0.0.0.0"
+		expected.Port = "8080"
+		expected.SuccessURL = "/app"
+		expected.JwtSecret = "fileSecret"
+		expected.JwtExpiry = 2 * time.Hour
+		expected.JwtRefreshes = 3
+		expected.CookieName = "token"
+		expected.CookieHTTPOnly = false
+		expected.CookieDomain = "example.org"
+		expected.Backends = Options{
+			"simple": {"bob": "secret"},
+			"ldap": {
+				"url":     "ldaps://ldap.example.org",
+				"base_dn": "ou=people,dc=example,dc=org",
+				"bind_dn": "cn=service,ou=apps,dc=example,dc=org",
+			},
+		}
+		expected.Oauth = Options{
+			"github": {"client_id": "id", "client_secret": "secret"},
+			"corp":   {"provider": "oidc", "issuer": "https://sso.example.org"},
+		}
+		expected.Saml = Options{
+			"azure": {"idp_metadata_url": "https://login.example.org/federationmetadata.xml", "binding": "post"},
+		}
+		expected.TokenExchangeAudiences = "orders"
+		expected.TokenExchangeClients = Options{
+			"orders": {"secret": "orders-secret", "audiences": "orders"},
+		}
+		Equal(t, expected, cfg, file)
+	}
+}
+
+func TestConfig_ReadConfigFile_Precedence(t *testing.T) {
+	file := writeConfigFile("*.yml", configFileYAML)
+	defer os.Remove(file)
+	NoError(t, os.Setenv("LOGSRV_CONFIG", file))
+	NoError(t, os.Setenv("LOGSRV_JWT_EXPIRY", "3h"))
+	NoError(t, os.Setenv("LOGSRV_SUCCESS_URL", "/env"))
+	defer os.Unsetenv("LOGSRV_CONFIG")
+	defer os.Unsetenv("LOGSRV_JWT_EXPIRY")
+	defer os.Unsetenv("LOGSRV_SUCCESS_URL")
+	cfg, err := readConfig(flag.NewFlagSet("", flag.ContinueOnError), []string{"--success-url=/flag", "--simple=alice=secret"})
+	NoError(t, err)
+	Equal(t, "/flag", cfg.SuccessURL)
+	Equal(t, 3*time.Hour, cfg.JwtExpiry)
+	Equal(t, "token", cfg.CookieName)
+	// the flag replaces the options of the provider
+	Equal(t, map[string]string{"alice": "secret"}, cfg.Backends["simple"])
+	Contains(t, cfg.Backends, "ldap")
+}
+
+func TestConfig_ReadConfigFile_Errors(t *testing.T) {
+	for _, content := range []string{
+		"unknown: value",
+		"jwt:\n  unknown: value",
+		"jwt:\n  expiry: soon",
+		"backends: simple",
+		"backends:\n  simple: bob",
+		"host:\n  - a\n  - b",
+		"config: other.yml",
+		"- no map",
+	} {
+		file := writeConfigFile("*.yml", content)
+		defer os.Remove(file)
+		_, err := readConfig(flag.NewFlagSet("", flag.ContinueOnError), []string{"--config=" + file})
+		Error(t, err, content)
+	}
+	_, err := readConfig(flag.NewFlagSet("", flag.ContinueOnError), []string{"--config", "/does/not/exist.yml"})
+	Error(t, err)
+	file := writeConfigFile("*.toml", "host = ")
+	defer os.Remove(file)
+	_, err = readConfig(flag.NewFlagSet("", flag.ContinueOnError), []string{"--config", file})
+	Error(t, err)
+}
+
+func Test_configFileFromArgs(t *testing.T) {
+	Equal(t, "a.yml", configFileFromArgs([]string{"-host", "x", "-config", "a.yml"}))
+	Equal(t, "a.yml", configFileFromArgs([]string{"--config=a.yml"}))
+	Equal(t, "", configFileFromArgs([]string{"--", "-config", "a.yml"}))
+	Equal(t, "", configFileFromArgs([]string{"-config"}))
+}
@@ -28,7 +28,11 @@ func TestConfig_ReadConfig(t *testing.T) {
 		"--text-logging=true",
 		"--jwt-secret=jwtsecret",
 		"--jwt-algo=algo",
+		"--jwt-verify-keys=/keys;/old.key",
 		"--jwt-expiry=42h42m",
+		"--jwt-issuer=https://login.example.com",
+		"--jwt-audience=example.com",
+		"--jwt-leeway=30s",
 		"--success-url=successurl",
 		"--redirect=false",
 		"--redirect-query-parameter=comingFrom",
@@ -42,14 +46,40 @@ func TestConfig_ReadConfig(t *testing.T) {
 		"--cookie-domain=*.example.com",
 		"--cookie-http-only=false",
 		"--cookie-secure=false",
+		"--token-lookup=header,cookie",
 		"--backend=provider=simple",
 		"--backend=provider=foo",
 		"--github=client_id=foo,client_secret=bar",
+		"--saml=name=corp,idp_metadata_url=https://idp.example.org/metadata",
 		"--grace-period=4s",
 		"--user-file=users.yml",
 		"--user-endpoint=http://test.io/claims",
 		"--user-endpoint-token=token",
 		"--user-endpoint-timeout=1s",
+		"--totp-file=totp.yml",
+		"--trusted-proxies=10.0.0.0/8",
+		"--login-rate-ip=20",
+		"--login-rate-user=5",
+		"--lockout-threshold=3",
+		"--lockout-duration=30s",
+		"--password-min-length=12",
+		"--password-breached-file=breached.txt",
+		"--admin-path=/login/admin",
+		"--admin-group=operators",
+		"--store=file",
+		"--store-file=/var/lib/logsrv/store.json",
+		"--server-sessions=true",
+		"--token-exchange-audiences=orders,billing",
+		"--token-exchange-claims=email,groups",
+		"--token-exchange-expiry=1m",
+		"--token-exchange-client=id=orders,secret=orders-secret,audiences=orders",
+		"--refresh-token-expiry=720h",
+		"--refresh-cookie-name=refresh",
+		"--metrics-path=/internal/metrics",
+		"--backend-order=htpasswd,simple",
+		"--audit-log=/var/log/logsrv/audit.log",
+		"--audit-log-max-size=10",
+		"--audit-log-max-backups=3",
 	}
 	expected := &Config{
 		Host:                   "host",
@@ -58,7 +88,11 @@ func TestConfig_ReadConfig(t *testing.T) {
 		TextLogging:            true,
 		JwtSecret:              "jwtsecret",
 		JwtAlgo:                "algo",
+		JwtVerifyKeys:          "/keys;/old.key",
 		JwtExpiry:              42*time.Hour + 42*time.Minute,
+		JwtIssuer:              "https://login.example.com",
+		JwtAudience:            "example.com",
+		JwtLeeway:              30 * time.Second,
 		SuccessURL:             "successurl",
 		Redirect:               false,
 		RedirectQueryParameter: "comingFrom",
@@ -72,6 +106,7 @@ func TestConfig_ReadConfig(t *testing.T) {
 		CookieDomain:           "*.example.com",
 		CookieHTTPOnly:         false,
 		CookieSecure:           false,
+		TokenLookup:            "header,cookie",
 		Backends: Options{
 			"simple": map[string]string{},
 			"foo":    map[string]string{},
@@ -82,11 +117,45 @@ func TestConfig_ReadConfig(t *testing.T) {
 				"client_secret": "bar",
 			},
 		},
-		GracePeriod:         4 * time.Second,
-		UserFile:            "users.yml",
-		UserEndpoint:        "http://test.io/claims",
-		UserEndpointToken:   "token",
-		UserEndpointTimeout: time.Second,
+		Saml: Options{
+			"corp": map[string]string{
+				"idp_metadata_url": "https://idp.example.org/metadata",
+			},
+		},
+		GracePeriod:            4 * time.Second,
+		UserFile:               "users.yml",
+		UserEndpoint:           "http://test.io/claims",
+		UserEndpointToken:      "token",
+		UserEndpointTimeout:    time.Second,
+		TOTPFile:               "totp.yml",
+		TrustedProxies:         "10.0.0.0/8",
+		LoginRateIP:            20,
+		LoginRateUser:          5,
+		LockoutThreshold:       3,
+		LockoutDuration:        30 * time.Second,
+		PasswordMinLength:      12,
+		PasswordBreachedFile:   "breached.txt",
+		AdminPath:              "/login/admin",
+		AdminGroup:             "operators",
+		Store:                  "file",
+		StoreFile:              "/var/lib/logsrv/store.json",
+		ServerSessions:         true,
+		TokenExchangeAudiences: "orders,billing",
+		TokenExchangeClaims:    "email,groups",
+		TokenExchangeExpiry:    time.Minute,
+		TokenExchangeClients: Options{
+			"orders": map[string]string{
+				"secret":    "orders-secret",
+				"audiences": "orders",
+			},
+		},
+		RefreshTokenExpiry: 720 * time.Hour,
+		RefreshCookieName:  "refresh",
+		MetricsPath:        "/internal/metrics",
+		BackendOrder:       "htpasswd,simple",
+		AuditLog:           "/var/log/logsrv/audit.log",
+		AuditLogMaxSize:    10,
+		AuditLogMaxBackups: 3,
 	}
 	cfg, err := readConfig(flag.NewFlagSet("", flag.ContinueOnError), input)
 	NoError(t, err)
@@ -184,6 +253,7 @@ func TestConfig_ReadConfigFromEnv(t *testing.T) {
 		CookieDomain:           "*.example.com",
 		CookieHTTPOnly:         false,
 		CookieSecure:           false,
+		TokenLookup:            "cookie,header",
 		Backends: Options{
 			"simple": map[string]string{
 				"foo": "bar",
@@ -195,11 +265,22 @@ func TestConfig_ReadConfigFromEnv(t *testing.T) {
 				"client_secret": "bar",
 			},
 		},
-		GracePeriod:         4 * time.Second,
-		UserFile:            "users.yml",
-		UserEndpoint:        "http://test.io/claims",
-		UserEndpointToken:   "token",
-		UserEndpointTimeout: time.Second,
+		Saml:                 Options{},
+		GracePeriod:          4 * time.Second,
+		UserFile:             "users.yml",
+		UserEndpoint:         "http://test.io/claims",
+		UserEndpointToken:    "token",
+		UserEndpointTimeout:  time.Second,
+		LockoutDuration:      time.Minute,
+		PasswordMinLength:    8,
+		AdminGroup:           "admin",
+		Store:                "memory",
+		TokenExchangeClaims:  "name,email,origin,domain,groups",
+		TokenExchangeExpiry:  5 * time.Minute,
+		TokenExchangeClients: Options{},
+		RefreshCookieName:    "refresh_token",
+		AuditLogMaxSize:      100,
+		AuditLogMaxBackups:   10,
 	}
 	cfg, err := readConfig(flag.NewFlagSet("", flag.ContinueOnError), []string{})
 	NoError(t, err)
@@ -224,3 +305,66 @@ func TestConfig_ReadConfigFromEnv_SecretFile(t *testing.T) {
 	NoError(t, err)
 	Equal(t, testSecret, cfg.JwtSecret)
 }
+
+func Test_parseOptions(t *testing.T) {
+	opts, err := parseOptions(`url=ldap://localhost,base_dn=dc=example\,dc=org,filter=(uid=%s)`)
+	NoError(t, err)
+	Equal(t, map[string]string{
+		"url":     "ldap://localhost",
+		"base_dn": "dc=example,dc=org",
+		"filter":  "(uid=%s)",
+	}, opts)
+	_, err = parseOptions("foo")
+	Error(t, err)
+}
+
+func TestConfig_Redacted(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.JwtSecret = "jwt-secret"
+	cfg.Backends = Options{
+		"simple": {"bob": "secret"},
+		"ldap":   {"url": "ldap://localhost", "bind_dn": "cn=logsrv", "bind_password": "ldap-secret"},
+	}
+	cfg.Oauth = Options{"github": {"client_id": "foo", "client_secret": "oauth-secret"}}
+	cfg.Saml = Options{"corp": {"idp_metadata_url": "https://idp.example.org/metadata", "password": "saml-secret"}}
+	cfg.TokenExchangeClients = Options{"frontend": {"secret": "client-secret", "audiences": "orders"}}
+
+	redacted := cfg.Redacted()
+	Equal(t, "...", redacted.JwtSecret)
+	Equal(t, Options{
+		"simple": {"bob": "..."},
+		"ldap":   {"url": "ldap://localhost", "bind_dn": "cn=logsrv", "bind_password": "..."},
+	}, redacted.Backends)
+	Equal(t, Options{"github": {"client_id": "foo", "client_secret": "..."}}, redacted.Oauth)
+	Equal(t, Options{"corp": {"idp_metadata_url": "https://idp.example.org/metadata", "password": "..."}}, redacted.Saml)
+	Equal(t, Options{"frontend": {"secret": "...", "audiences": "orders"}}, redacted.TokenExchangeClients)
+
+	// the config itself is not changed
+	Equal(t, "jwt-secret", cfg.JwtSecret)
+	Equal(t, "secret", cfg.Backends["simple"]["bob"])
+	Equal(t, "oauth-secret", cfg.Oauth["github"]["client_secret"])
+	Equal(t, "client-secret", cfg.TokenExchangeClients["frontend"]["secret"])
+}
+
+func TestConfig_NamedOauthInstances(t *testing.T) {
+	cfg := DefaultConfig()
+	NoError(t, cfg.addOauthOpts("oidc", "name=keycloak,issuer=https://sso.example.org,client_id=foo"))
+	NoError(t, cfg.addOauthOpts("oidc", "name=corp,issuer=https://login.corp.example,client_id=bar"))
+	NoError(t, cfg.addOauthOpts("github", "client_id=baz"))
+	Equal(t, Options{
+		"keycloak": {"provider": "oidc", "issuer": "https://sso.example.org", "client_id": "foo"},
+		"corp":     {"provider": "oidc", "issuer": "https://login.corp.example", "client_id": "bar"},
+		"github":   {"client_id": "baz"},
+	}, cfg.Oauth)
+}
+
+func TestConfig_SamlOpts(t *testing.T) {
+	cfg := DefaultConfig()
+	NoError(t, cfg.addSamlOpts("name=corp,idp_metadata_url=https://idp.example.org/metadata"))
+	NoError(t, cfg.addSamlOpts("name=azure,idp_metadata_file=/etc/logsrv/azure.xml,binding=post"))
+	Equal(t, Options{
+		"corp":  {"idp_metadata_url": "https://idp.example.org/metadata"},
+		"azure": {"idp_metadata_file": "/etc/logsrv/azure.xml", "binding": "post"},
+	}, cfg.Saml)
+	Error(t, cfg.addSamlOpts("idp_metadata_url=https://idp.example.org/metadata"))
+}
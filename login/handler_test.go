@@ -11,14 +11,20 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
+	"github.com/pchchv/logsrv/logging"
+	"github.com/pchchv/logsrv/metrics"
 	"github.com/pchchv/logsrv/model"
 	"github.com/pchchv/logsrv/oauth2"
+	"github.com/pchchv/logsrv/saml"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	. "github.com/stretchr/testify/assert"
 )
 
@@ -34,6 +40,15 @@ type oauth2ManagerMock struct {
 	_GetConfigFromRequest func(r *http.Request) (oauth2.Config, error)
 }
 
+type samlManagerMock struct {
+	_Handle func(w http.ResponseWriter, r *http.Request) (
+		responded bool,
+		authenticated bool,
+		userInfo model.UserInfo,
+		err error)
+	_GetConfigFromRequest func(r *http.Request) (saml.Config, error)
+}
+
 const (
 	TypeJSON   = "Content-Type: application/json"
 	TypeForm   = "Content-Type: application/x-www-form-urlencoded"
@@ -100,6 +115,13 @@ func TestHandler_NewFromConfig(t *testing.T) {
 			0,
 			true,
 		},
+		{
+			// init error because no idp metadata is provided
+			&Config{Saml: Options{"corp": {}}},
+			0,
+			0,
+			true,
+		},
 		{
 			&Config{Backends: Options{"simpleFoo": {"bob": "secret"}}},
 			1,
@@ -226,6 +248,79 @@ func TestHandler_HandleOauth(t *testing.T) {
 	Equal(t, 403, recorder.Code)
 }
 
+func TestHandler_HandleSaml(t *testing.T) {
+	managerMock := &samlManagerMock{
+		_GetConfigFromRequest: func(r *http.Request) (saml.Config, error) {
+			if r.URL.Path != "/context/login/saml/corp" {
+				return saml.Config{}, errors.New("no saml configuration")
+			}
+			return saml.Config{Name: "corp"}, nil
+		},
+	}
+	handler := testHandler()
+	handler.saml = managerMock
+	// test start flow redirect
+	managerMock._Handle = func(w http.ResponseWriter, r *http.Request) (
+		responded bool,
+		authenticated bool,
+		userInfo model.UserInfo,
+		err error,
+	) {
+		w.Header().Set("Location", "http://idp.example.com")
+		w.WriteHeader(302)
+		return true, false, model.UserInfo{}, nil
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req("GET", "/context/login/saml/corp", ""))
+	Equal(t, 302, recorder.Code)
+	Equal(t, "http://idp.example.com", recorder.Header().Get("Location"))
+	// test authentication
+	managerMock._Handle = func(w http.ResponseWriter, r *http.Request) (
+		responded bool,
+		authenticated bool,
+		userInfo model.UserInfo,
+		err error,
+	) {
+		return false, true, model.UserInfo{Sub: "marvin", Origin: "corp", Groups: []string{"admin"}}, nil
+	}
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req("POST", "/context/login/saml/corp", "SAMLResponse=xyz", TypeForm, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+	token, err := tokenAsMap(recorder.Body.String())
+	NoError(t, err)
+	Equal(t, "marvin", token["sub"])
+	Equal(t, "corp", token["origin"])
+	Equal(t, []interface{}{"admin"}, token["groups"])
+	// test invalid response of the identity provider
+	managerMock._Handle = func(w http.ResponseWriter, r *http.Request) (
+		responded bool,
+		authenticated bool,
+		userInfo model.UserInfo,
+		err error,
+	) {
+		return false, false, model.UserInfo{}, &saml.ResponseError{Err: errors.New("signature invalid")}
+	}
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req("POST", "/context/login/saml/corp", "SAMLResponse=xyz", TypeForm))
+	Equal(t, 403, recorder.Code)
+	// test error in saml
+	managerMock._Handle = func(w http.ResponseWriter, r *http.Request) (
+		responded bool,
+		authenticated bool,
+		userInfo model.UserInfo,
+		err error,
+	) {
+		return false, false, model.UserInfo{}, errors.New("some error")
+	}
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req("POST", "/context/login/saml/corp", "SAMLResponse=xyz", TypeForm))
+	Equal(t, 500, recorder.Code)
+	// other paths are handled as login
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+}
+
 func TestHandler_LoginWeb(t *testing.T) {
 	// redirectSuccess
 	recorder := call(req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptHTML))
@@ -435,7 +530,81 @@ func TestHandler_getToken_Valid(t *testing.T) {
 	}
 	userInfo, valid := h.GetToken(r)
 	True(t, valid)
-	Equal(t, input, userInfo)
+	equalIssued(t, input, userInfo)
+}
+
+func TestHandler_getToken_IssuerAndAudience(t *testing.T) {
+	h := testHandler()
+	h.config.JwtIssuer = "https://login.example.com"
+	h.config.JwtAudience = "example.com"
+	token, err := h.createToken(model.UserInfo{Sub: "marvin", Expiry: time.Now().Add(time.Minute).Unix()})
+	NoError(t, err)
+	claims, err := tokenAsMap(token)
+	NoError(t, err)
+	Equal(t, "https://login.example.com", claims["iss"])
+	Equal(t, "example.com", claims["aud"])
+	Equal(t, claims["iat"], claims["nbf"])
+
+	bearer := func(token string) *http.Request {
+		return &http.Request{Header: http.Header{"Authorization": {"Bearer " + token}}}
+	}
+	userInfo, valid := h.GetToken(bearer(token))
+	True(t, valid)
+	Equal(t, "https://login.example.com", userInfo.Issuer)
+	Equal(t, model.Audience{"example.com"}, userInfo.Audience)
+
+	// tokens of another instance or environment are rejected
+	other := testHandler()
+	other.config.JwtIssuer = "https://login.staging.example.com"
+	other.config.JwtAudience = "example.com"
+	otherToken, err := other.createToken(model.UserInfo{Sub: "marvin", Expiry: time.Now().Add(time.Minute).Unix()})
+	NoError(t, err)
+	_, valid = h.GetToken(bearer(otherToken))
+	False(t, valid)
+
+	other.config.JwtIssuer = "https://login.example.com"
+	other.config.JwtAudience = "staging.example.com"
+	otherToken, err = other.createToken(model.UserInfo{Sub: "marvin", Expiry: time.Now().Add(time.Minute).Unix()})
+	NoError(t, err)
+	_, valid = h.GetToken(bearer(otherToken))
+	False(t, valid)
+
+	// tokens without audience are rejected, if an audience is configured
+	other.config.JwtAudience = ""
+	otherToken, err = other.createToken(model.UserInfo{Sub: "marvin", Expiry: time.Now().Add(time.Minute).Unix()})
+	NoError(t, err)
+	_, valid = h.GetToken(bearer(otherToken))
+	False(t, valid)
+
+	// tokens with an audience are rejected, if no audience is configured
+	h.config.JwtIssuer, h.config.JwtAudience = "", ""
+	_, valid = h.GetToken(bearer(token))
+	False(t, valid)
+}
+
+func TestHandler_getToken_Leeway(t *testing.T) {
+	h := testHandler()
+	key, err := h.signingInfo()
+	NoError(t, err)
+	sign := func(claims jwt.MapClaims) *http.Request {
+		token, err := jwt.NewWithClaims(key.method, claims).SignedString(key.signKey)
+		NoError(t, err)
+		return &http.Request{Header: http.Header{"Authorization": {"Bearer " + token}}}
+	}
+	now := time.Now().Unix()
+	expired := sign(jwt.MapClaims{"sub": "marvin", "exp": now - 10})
+	notYetValid := sign(jwt.MapClaims{"sub": "marvin", "exp": now + 60, "nbf": now + 10, "iat": now + 10})
+
+	_, valid := h.GetToken(expired)
+	False(t, valid)
+	_, valid = h.GetToken(notYetValid)
+	False(t, valid)
+
+	h.config.JwtLeeway = 30 * time.Second
+	_, valid = h.GetToken(expired)
+	True(t, valid)
+	_, valid = h.GetToken(notYetValid)
+	True(t, valid)
 }
 
 func TestHandler_ReturnUserInfoJSON(t *testing.T) {
@@ -460,7 +629,7 @@ func TestHandler_ReturnUserInfoJSON(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	Equal(t, input, output)
+	equalIssued(t, input, output)
 }
 
 func TestHandler_ReturnUserInfoJSON_InvalidToken(t *testing.T) {
@@ -497,7 +666,7 @@ func TestHandler_signAndVerify_ES256(t *testing.T) {
 	}
 	userInfo, valid := h.GetToken(r)
 	True(t, valid)
-	Equal(t, input, userInfo)
+	equalIssued(t, input, userInfo)
 }
 
 func TestHandler_signAndVerify_RSA(t *testing.T) {
@@ -527,7 +696,7 @@ func TestHandler_signAndVerify_RSA(t *testing.T) {
 			}
 			userInfo, valid := h.GetToken(r)
 			True(t, valid)
-			Equal(t, input, userInfo)
+			equalIssued(t, input, userInfo)
 		})
 	}
 	t.Run("headerless", func(t *testing.T) {
@@ -555,7 +724,7 @@ func TestHandler_signAndVerify_RSA(t *testing.T) {
 		}
 		userInfo, valid := h.GetToken(r)
 		True(t, valid)
-		Equal(t, input, userInfo)
+		equalIssued(t, input, userInfo)
 	})
 	t.Run("garbage key", func(t *testing.T) {
 		h := testHandler()
@@ -620,6 +789,7 @@ func testHandler() *Handler {
 		},
 		oauth:  oauth2.NewManager(),
 		config: testConfig(),
+		store:  NewMemoryStore(),
 	}
 }
 
@@ -686,6 +856,19 @@ func (m *oauth2ManagerMock) GetConfigFromRequest(r *http.Request) (oauth2.Config
 	return m._GetConfigFromRequest(r)
 }
 
+func (m *samlManagerMock) Handle(w http.ResponseWriter, r *http.Request) (
+	responded bool,
+	authenticated bool,
+	userInfo model.UserInfo,
+	err error,
+) {
+	return m._Handle(w, r)
+}
+
+func (m *samlManagerMock) GetConfigFromRequest(r *http.Request) (saml.Config, error) {
+	return m._GetConfigFromRequest(r)
+}
+
 // copied from golang: net/http/cookie.go
 // with some simplifications for edge cases
 // readSetCookies parses all "Set-Cookie" values from
@@ -765,3 +948,162 @@ func readCookiesParts(c *http.Cookie, parts []string) {
 		c.Unparsed = append(c.Unparsed, parts[i])
 	}
 }
+
+// Compares the user info of an issued token with the input, which has no jti, iat and nbf set
+func equalIssued(t *testing.T, input, issued model.UserInfo) {
+	NotEmpty(t, issued.ID)
+	InDelta(t, time.Now().Unix(), issued.IssuedAt, 5)
+	Equal(t, issued.IssuedAt, issued.NotBefore)
+	issued.ID, issued.IssuedAt, issued.NotBefore = "", 0, 0
+	Equal(t, input, issued)
+}
+
+func TestHandler_Reload(t *testing.T) {
+	config := DefaultConfig()
+	config.Backends = Options{SimpleProviderName: {"bob": "secret"}}
+	h, err := NewHandler(config)
+	NoError(t, err)
+	NoError(t, h.store.Set("key", []byte("value"), time.Now().Add(time.Minute)))
+
+	changed := DefaultConfig()
+	changed.Backends = Options{SimpleProviderName: {"alice": "secret"}}
+	reloaded, err := h.Reload(changed)
+	NoError(t, err)
+	Equal(t, changed, reloaded.config)
+	// the server side state is kept
+	Same(t, h.store, reloaded.store)
+
+	changed = DefaultConfig()
+	changed.Backends = Options{SimpleProviderName: {"alice": "secret"}}
+	changed.Store = "file"
+	changed.StoreFile = os.TempDir() + "/logsrv-reload-test.json"
+	defer os.Remove(changed.StoreFile)
+	reloaded, err = h.Reload(changed)
+	NoError(t, err)
+	NotEqual(t, h.store, reloaded.store)
+
+	_, err = h.Reload(DefaultConfig())
+	Error(t, err)
+}
+
+func TestHandler_Reload_ClosesStore(t *testing.T) {
+	config := DefaultConfig()
+	config.Backends = Options{SimpleProviderName: {"bob": "secret"}}
+	config.Store = "bolt"
+	config.StoreFile = filepath.Join(t.TempDir(), "store.db")
+	h, err := NewHandler(config)
+	NoError(t, err)
+
+	changed := *config
+	changed.StoreFile = filepath.Join(t.TempDir(), "other.db")
+	reloaded, err := h.Reload(&changed)
+	NoError(t, err)
+	defer reloaded.Close()
+	// the lock of the previous file is released
+	s, err := NewBoltStore(config.StoreFile)
+	NoError(t, err)
+	NoError(t, s.Close())
+}
+
+func TestHandler_Metrics(t *testing.T) {
+	success := metrics.Logins.WithLabelValues(SimpleProviderName, metrics.OutcomeSuccess)
+	failure := metrics.Logins.WithLabelValues(passwordProvider, metrics.OutcomeFailure)
+	loginErrors := metrics.Logins.WithLabelValues(passwordProvider, metrics.OutcomeError)
+	refreshes := metrics.TokenRefreshes.WithLabelValues(metrics.RefreshJwt)
+	successBefore, failureBefore := testutil.ToFloat64(success), testutil.ToFloat64(failure)
+	errorsBefore, refreshesBefore := testutil.ToFloat64(loginErrors), testutil.ToFloat64(refreshes)
+	maxRefreshesBefore := testutil.ToFloat64(metrics.MaxRefreshesReached)
+	durationsBefore := authenticateDurationCount(t, SimpleProviderName)
+
+	h := testHandler()
+	h.routingRules = []backendRoute{{name: SimpleProviderName}}
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", `{"username": "bob", "password": "secret"}`, TypeJSON, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+	Equal(t, successBefore+1, testutil.ToFloat64(success))
+	Equal(t, durationsBefore+1, authenticateDurationCount(t, SimpleProviderName))
+
+	recorder = call(req("POST", "/context/login", `{"username": "bob", "password": "FOOOBAR"}`, TypeJSON, AcceptJwt))
+	Equal(t, 403, recorder.Code)
+	Equal(t, failureBefore+1, testutil.ToFloat64(failure))
+
+	recorder = httptest.NewRecorder()
+	testHandlerWithError().ServeHTTP(recorder, req("POST", "/context/login", `{"username": "bob", "password": "secret"}`, TypeJSON, AcceptJwt))
+	Equal(t, 500, recorder.Code)
+	Equal(t, errorsBefore+1, testutil.ToFloat64(loginErrors))
+
+	token, err := h.createToken(model.UserInfo{Sub: "bob", Expiry: time.Now().Add(time.Second).Unix()})
+	NoError(t, err)
+	recorder = call(req("POST", "/context/login", "", AcceptJwt, "Cookie: "+h.config.CookieName+"="+token))
+	Equal(t, 200, recorder.Code)
+	Equal(t, refreshesBefore+1, testutil.ToFloat64(refreshes))
+
+	token, err = h.createToken(model.UserInfo{Sub: "bob", Expiry: time.Now().Add(time.Second).Unix(), Refreshes: 1})
+	NoError(t, err)
+	recorder = call(req("POST", "/context/login", "", AcceptJwt, "Cookie: "+h.config.CookieName+"="+token))
+	Equal(t, 403, recorder.Code)
+	Equal(t, maxRefreshesBefore+1, testutil.ToFloat64(metrics.MaxRefreshesReached))
+}
+
+func authenticateDurationCount(t *testing.T, backend string) uint64 {
+	families, err := metrics.Registry.Gather()
+	NoError(t, err)
+	for _, f := range families {
+		if f.GetName() != "logsrv_backend_authenticate_duration_seconds" {
+			continue
+		}
+		for _, m := range f.Metric {
+			for _, l := range m.Label {
+				if l.GetName() == "backend" && l.GetValue() == backend {
+					return m.GetHistogram().GetSampleCount()
+				}
+			}
+		}
+	}
+	return 0
+}
+
+type auditBuffer struct {
+	strings.Builder
+}
+
+func (b *auditBuffer) Close() error {
+	return nil
+}
+
+func TestHandler_Audit(t *testing.T) {
+	b := &auditBuffer{}
+	NoError(t, logging.SetAuditLog(b))
+	defer logging.SetAuditLog(nil)
+	h := testHandler()
+	h.config.LockoutThreshold = 1
+	h.throttle = newThrottle(h.config)
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", `{"username": "bob", "password": "secret"}`, TypeJSON, AcceptJwt, "User-Agent: test-agent"))
+	Equal(t, 200, recorder.Code)
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", `{"username": "bob", "password": "FOOOBAR"}`, TypeJSON, AcceptJwt))
+	Equal(t, 403, recorder.Code)
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("DELETE", "/context/login", ""))
+
+	events := []logging.AuditEvent{}
+	for _, line := range strings.Split(strings.TrimSpace(b.String()), "\n") {
+		e := logging.AuditEvent{}
+		NoError(t, json.Unmarshal([]byte(line), &e))
+		events = append(events, e)
+	}
+	Equal(t, 4, len(events))
+	Equal(t, logging.AuditLogin, events[0].Event)
+	Equal(t, logging.AuditSuccess, events[0].Outcome)
+	Equal(t, "bob", events[0].Subject)
+	Equal(t, SimpleProviderName, events[0].Origin)
+	Equal(t, "test-agent", events[0].UserAgent)
+	Equal(t, logging.AuditLogin, events[1].Event)
+	Equal(t, logging.AuditFailure, events[1].Outcome)
+	Equal(t, "wrong credentials", events[1].Reason)
+	Equal(t, logging.AuditLockout, events[2].Event)
+	Equal(t, "bob", events[2].Subject)
+	Equal(t, logging.AuditLogout, events[3].Event)
+}
@@ -0,0 +1,126 @@
+package login
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pchchv/logsrv/logging"
+	"github.com/pkg/errors"
+)
+
+const (
+	healthPath = "/healthz"
+	readyPath  = "/readyz"
+
+	// Checks, which take longer, are reported as failed
+	healthCheckTimeout = 5 * time.Second
+	// The result of the readiness checks is reused for this duration
+	readyCacheDuration = 5 * time.Second
+
+	userEndpointCheckName = "user_endpoint"
+)
+
+var errHealthCheckTimeout = errors.New("health check timed out")
+
+// Status of a probe or a single check
+type healthStatus struct {
+	Status string                  `json:"status"`
+	Checks map[string]healthStatus `json:"checks,omitempty"`
+}
+
+// Caches the result of the readiness checks.
+// The probe needs no authentication, so that it must not put load on the backends for every request.
+// Only one run of the checks is done at a time, concurrent requests wait for its result.
+type readiness struct {
+	mu      sync.Mutex
+	code    int
+	status  healthStatus
+	checked time.Time
+}
+
+// The process is alive, as long as it answers
+func (h *Handler) handleHealth(w http.ResponseWriter, r *http.Request) {
+	writeHealthStatus(w, http.StatusOK, healthStatus{Status: "ok"})
+}
+
+// Ready, if all backends and the user claims endpoint are healthy.
+// Backends without health check are reported as ok.
+// The response has the status of the checks only, the errors are logged,
+// because they may reveal internal hosts and accounts.
+func (h *Handler) handleReady(w http.ResponseWriter, r *http.Request) {
+	h.readiness.mu.Lock()
+	defer h.readiness.mu.Unlock()
+	if time.Since(h.readiness.checked) >= readyCacheDuration {
+		h.readiness.code, h.readiness.status = h.checkReady(r)
+		h.readiness.checked = time.Now()
+	}
+	writeHealthStatus(w, h.readiness.code, h.readiness.status)
+}
+
+func (h *Handler) checkReady(r *http.Request) (int, healthStatus) {
+	checks := map[string]HealthChecker{}
+	status := healthStatus{Status: "ok", Checks: map[string]healthStatus{}}
+	for i, b := range h.backends {
+		if c, ok := b.(HealthChecker); ok {
+			checks[h.backendName(i)] = c
+		} else {
+			status.Checks[h.backendName(i)] = healthStatus{Status: "ok"}
+		}
+	}
+	if h.userClaimsHealth != nil {
+		checks[userEndpointCheckName] = h.userClaimsHealth
+	}
+	for name, err := range runHealthChecks(checks, healthCheckTimeout) {
+		if err != nil {
+			logging.Application(r.Header).WithError(err).WithField("check", name).Warn("readiness check failed")
+			status.Status = "error"
+			status.Checks[name] = healthStatus{Status: "error"}
+		} else {
+			status.Checks[name] = healthStatus{Status: "ok"}
+		}
+	}
+	code := http.StatusOK
+	if status.Status != "ok" {
+		code = http.StatusServiceUnavailable
+	}
+	return code, status
+}
+
+type healthCheckResult struct {
+	name string
+	err  error
+}
+
+// Runs the checks in parallel and returns the error of every check by its name
+func runHealthChecks(checks map[string]HealthChecker, timeout time.Duration) map[string]error {
+	results := make(chan healthCheckResult, len(checks))
+	for name, c := range checks {
+		go func(name string, c HealthChecker) {
+			results <- healthCheckResult{name, c.CheckHealth()}
+		}(name, c)
+	}
+	errs := map[string]error{}
+	deadline := time.After(timeout)
+	for len(errs) < len(checks) {
+		select {
+		case result := <-results:
+			errs[result.name] = result.err
+		case <-deadline:
+			for name := range checks {
+				if _, done := errs[name]; !done {
+					errs[name] = errHealthCheckTimeout
+				}
+			}
+		}
+	}
+	return errs
+}
+
+func writeHealthStatus(w http.ResponseWriter, code int, status healthStatus) {
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(status) // ignore error of encoding
+}
@@ -0,0 +1,106 @@
+package login
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pchchv/logsrv/model"
+	. "github.com/stretchr/testify/assert"
+)
+
+type healthTestBackend struct {
+	err   error
+	delay time.Duration
+}
+
+func (b healthTestBackend) Authenticate(username, password string) (bool, model.UserInfo, error) {
+	return false, model.UserInfo{}, nil
+}
+
+func (b healthTestBackend) CheckHealth() error {
+	time.Sleep(b.delay)
+	return b.err
+}
+
+func TestHandler_Healthz(t *testing.T) {
+	h := testHandler()
+	h.backends = []Backend{healthTestBackend{err: errors.New("down")}}
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/healthz", ""))
+	Equal(t, 200, recorder.Code)
+	Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+	JSONEq(t, `{"status": "ok"}`, recorder.Body.String())
+}
+
+func TestHandler_Readyz(t *testing.T) {
+	h := testHandler()
+	h.backends = append(h.backends, healthTestBackend{})
+	h.routingRules = []backendRoute{{name: SimpleProviderName}, {name: "htpasswd"}}
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/readyz", ""))
+	Equal(t, 200, recorder.Code)
+	JSONEq(t, `{"status": "ok", "checks": {"simple": {"status": "ok"}, "htpasswd": {"status": "ok"}}}`, recorder.Body.String())
+
+	h.backends[1] = healthTestBackend{err: errors.New("open /etc/htpasswd: permission denied")}
+	h.userClaimsHealth = healthTestBackend{}
+	// the result is cached
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/readyz", ""))
+	Equal(t, 200, recorder.Code)
+
+	h.readiness.checked = time.Time{}
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/readyz", ""))
+	Equal(t, 503, recorder.Code)
+	// the error is logged only
+	NotContains(t, recorder.Body.String(), "/etc/htpasswd")
+	status := healthStatus{}
+	NoError(t, json.Unmarshal(recorder.Body.Bytes(), &status))
+	Equal(t, "error", status.Status)
+	Equal(t, healthStatus{Status: "error"}, status.Checks["htpasswd"])
+	Equal(t, healthStatus{Status: "ok"}, status.Checks[userEndpointCheckName])
+	Equal(t, healthStatus{Status: "ok"}, status.Checks[SimpleProviderName])
+}
+
+func TestHandler_Readyz_OneCheckAtATime(t *testing.T) {
+	h := testHandler()
+	checks := make(chan struct{}, 10)
+	h.backends = []Backend{countingHealthBackend{checks}}
+	done := make(chan int)
+	for i := 0; i < 5; i++ {
+		go func() {
+			recorder := httptest.NewRecorder()
+			h.ServeHTTP(recorder, req("GET", "/readyz", ""))
+			done <- recorder.Code
+		}()
+	}
+	for i := 0; i < 5; i++ {
+		Equal(t, 200, <-done)
+	}
+	Equal(t, 1, len(checks))
+}
+
+type countingHealthBackend struct {
+	checks chan struct{}
+}
+
+func (b countingHealthBackend) Authenticate(username, password string) (bool, model.UserInfo, error) {
+	return false, model.UserInfo{}, nil
+}
+
+func (b countingHealthBackend) CheckHealth() error {
+	b.checks <- struct{}{}
+	time.Sleep(10 * time.Millisecond)
+	return nil
+}
+
+func Test_runHealthChecks_Timeout(t *testing.T) {
+	errs := runHealthChecks(map[string]HealthChecker{
+		"fast": healthTestBackend{},
+		"slow": healthTestBackend{delay: time.Second},
+	}, 50*time.Millisecond)
+	Equal(t, map[string]error{"fast": nil, "slow": errHealthCheckTimeout}, errs)
+}
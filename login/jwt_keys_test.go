@@ -0,0 +1,141 @@
+package login
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pchchv/logsrv/model"
+	. "github.com/stretchr/testify/assert"
+)
+
+func Test_keyID_RFC7638Example(t *testing.T) {
+	n, _ := base64.RawURLEncoding.DecodeString("0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw")
+	key := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: 65537}
+	Equal(t, "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs", keyID(key))
+}
+
+func Test_parseVerifyKey(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	NoError(t, err)
+	publicDER, err := x509.MarshalPKIXPublicKey(ecKey.Public())
+	NoError(t, err)
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+	k, err := parseVerifyKey("ES256", string(publicPEM))
+	NoError(t, err)
+	Nil(t, k.signKey)
+	Equal(t, keyID(ecKey.Public()), k.kid)
+	jwk, ok := k.jwk()
+	True(t, ok)
+	Equal(t, "EC", jwk["kty"])
+	Equal(t, "P-256", jwk["crv"])
+	Equal(t, "ES256", jwk["alg"])
+	_, err = parseVerifyKey("RS256", "-garbage-")
+	Error(t, err)
+	// secrets are never published
+	k, err = parseVerifyKey("HS512", "secret")
+	NoError(t, err)
+	_, ok = k.jwk()
+	False(t, ok)
+}
+
+func Test_loadVerifyKeys(t *testing.T) {
+	dir := t.TempDir()
+	NoError(t, os.WriteFile(filepath.Join(dir, "b.key"), []byte("secretB"), 0600))
+	NoError(t, os.WriteFile(filepath.Join(dir, "a.key"), []byte("secretA"), 0600))
+	NoError(t, os.WriteFile(filepath.Join(dir, ".hidden"), []byte("hidden"), 0600))
+	single := filepath.Join(t.TempDir(), "c.key")
+	NoError(t, os.WriteFile(single, []byte("secretC"), 0600))
+	keys, err := loadVerifyKeys("HS512", dir+";"+single)
+	NoError(t, err)
+	Equal(t, 3, len(keys))
+	Equal(t, []byte("secretA"), keys[0].verifyKey)
+	Equal(t, []byte("secretB"), keys[1].verifyKey)
+	Equal(t, []byte("secretC"), keys[2].verifyKey)
+	_, err = loadVerifyKeys("HS512", filepath.Join(dir, "does-not-exist"))
+	Error(t, err)
+}
+
+func TestHandler_KeyRotation(t *testing.T) {
+	oldKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	NoError(t, err)
+	newKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	NoError(t, err)
+	oldHandler := testHandler()
+	oldHandler.config.JwtAlgo = "ES256"
+	oldHandler.config.JwtSecret = ecPrivateKeyPEM(t, oldKey)
+	input := model.UserInfo{Sub: "marvin", Expiry: time.Now().Add(time.Minute).Unix()}
+	oldToken, err := oldHandler.createToken(input)
+	NoError(t, err)
+	// the new key signs, the old one is only accepted for verification
+	keyFile := filepath.Join(t.TempDir(), "old.pem")
+	NoError(t, os.WriteFile(keyFile, []byte(ecPrivateKeyPEM(t, oldKey)), 0600))
+	h := testHandler()
+	h.config.JwtAlgo = "ES256"
+	h.config.JwtSecret = ecPrivateKeyPEM(t, newKey)
+	h.config.JwtVerifyKeys = keyFile
+	newToken, err := h.createToken(input)
+	NoError(t, err)
+	parsed, _ := jwt.Parse(newToken, nil)
+	Equal(t, keyID(newKey.Public()), parsed.Header["kid"])
+	for _, token := range []string{oldToken, newToken} {
+		userInfo, valid := h.GetToken(&http.Request{
+			Header: http.Header{"Cookie": {h.config.CookieName + "=" + token + ";"}},
+		})
+		True(t, valid)
+		equalIssued(t, input, userInfo)
+	}
+	// after the rotation window the old tokens are rejected
+	_, valid := testHandlerWithKey(t, newKey).GetToken(&http.Request{
+		Header: http.Header{"Cookie": {h.config.CookieName + "=" + oldToken + ";"}},
+	})
+	False(t, valid)
+}
+
+func TestHandler_Jwks(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	NoError(t, err)
+	h := testHandlerWithKey(t, key)
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/.well-known/jwks.json", ""))
+	Equal(t, 200, recorder.Code)
+	Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+	jwks := struct {
+		Keys []map[string]string `json:"keys"`
+	}{}
+	NoError(t, json.Unmarshal(recorder.Body.Bytes(), &jwks))
+	Equal(t, 1, len(jwks.Keys))
+	Equal(t, keyID(key.Public()), jwks.Keys[0]["kid"])
+	Equal(t, "ES256", jwks.Keys[0]["alg"])
+	Equal(t, "sig", jwks.Keys[0]["use"])
+	// symmetric keys are not published
+	recorder = call(req("GET", "/context/login/.well-known/jwks.json", ""))
+	Equal(t, 200, recorder.Code)
+	JSONEq(t, `{"keys": []}`, recorder.Body.String())
+}
+
+func testHandlerWithKey(t *testing.T, key *ecdsa.PrivateKey) *Handler {
+	h := testHandler()
+	h.config.JwtAlgo = "ES256"
+	h.config.JwtSecret = ecPrivateKeyPEM(t, key)
+	return h
+}
+
+func ecPrivateKeyPEM(t *testing.T, key *ecdsa.PrivateKey) string {
+	der, err := x509.MarshalECPrivateKey(key)
+	NoError(t, err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))
+}
@@ -58,14 +58,69 @@ const partials = `
               {{end}}
               <br/>
               <a class="btn btn-md btn-primary" href="{{ .Config.LoginPath }}?logout=true">Logout</a>
+              {{if .CanChangePassword}}<a class="btn btn-md btn-default" href="{{ trimRight .Config.LoginPath "/" }}/password">Change password</a>{{end}}
+{{end}}
+{{define "passwordChange"}}
+                <div class="panel panel-default">
+  	          <div class="panel-heading">
+  		    <div class="panel-title">
+  		      <h4>Change password of {{.UserInfo.Sub}}</h4>
+                      {{ if .PasswordError}}<div class="alert alert-warning" role="alert">{{.PasswordError}}</div>{{end}}
+                      {{ if .PasswordChanged}}<div class="alert alert-success" role="alert">Your password has been changed</div>{{end}}
+		    </div>
+	          </div>
+	          <div class="panel-body">
+		    <form accept-charset="UTF-8" role="form" method="POST" action="{{ trimRight .Config.LoginPath "/" }}/password">
+                      <fieldset>
+		        <div class="form-group">
+		          <input class="form-control" placeholder="Current password" name="old_password" type="password" autocomplete="current-password" value="">
+		        </div>
+		        <div class="form-group">
+		          <input class="form-control" placeholder="New password" name="new_password" type="password" autocomplete="new-password" value="">
+		        </div>
+		        <div class="form-group">
+		          <input class="form-control" placeholder="Repeat the new password" name="new_password_confirm" type="password" autocomplete="new-password" value="">
+		        </div>
+		        <input class="btn btn-lg btn-success btn-block" type="submit" value="Change password">
+		      </fieldset>
+		    </form>
+	          </div>
+	        </div>
+                <a href="{{ .Config.LoginPath }}">Back</a>
 {{end}}
 {{define "login"}}
+              {{if .MFA}}
+                <div class="panel panel-default">
+  	          <div class="panel-heading">
+  		    <div class="panel-title">
+  		      <h4>Two-factor authentication</h4>
+                      {{ if .Failure}}<div class="alert alert-warning" role="alert">Invalid code</div>{{end}}
+                      {{ if .Throttled}}<div class="alert alert-warning" role="alert">Too many attempts. Please try again later.</div>{{end}}
+		    </div>
+	          </div>
+	          <div class="panel-body">
+		    <form accept-charset="UTF-8" role="form" method="POST" action="{{.Config.LoginPath}}">
+                      <fieldset>
+		        <div class="form-group">
+		          <input class="form-control" placeholder="Code of your authenticator app" name="otp" type="text" inputmode="numeric" autocomplete="one-time-code" autofocus value="">
+		        </div>
+		        <input class="btn btn-lg btn-success btn-block" type="submit" value="Verify">
+		      </fieldset>
+		    </form>
+	          </div>
+	        </div>
+              {{else}}
               {{ range $providerName, $opts := .Config.Oauth }}
                 <a class="btn btn-block btn-lg btn-social btn-{{ $providerName }}" href="{{ trimRight $.Config.LoginPath "/" }}/{{ $providerName }}">
                   <span class="fa fa-{{ $providerName }}"></span> Sign in with {{ $providerName | ucfirst }}
                 </a>
               {{end}}
-              {{if and (not (eq (len .Config.Backends) 0)) (not (eq (len .Config.Oauth) 0))}}
+              {{ range $name, $opts := .Config.Saml }}
+                <a class="btn btn-block btn-lg btn-social btn-saml" href="{{ trimRight $.Config.LoginPath "/" }}/saml/{{ $name }}">
+                  <span class="fa fa-sign-in"></span> Sign in with {{ $name | ucfirst }}
+                </a>
+              {{end}}
+              {{if and (not (eq (len .Config.Backends) 0)) (or (not (eq (len .Config.Oauth) 0)) (not (eq (len .Config.Saml) 0)))}}
                 <div class="login-or-container">
                   <hr class="login-or-hr">
                   <div class="login-or lead">or</div>
@@ -77,6 +132,7 @@ const partials = `
   		    <div class="panel-title">
   		      <h4>Sign in</h4>
                       {{ if .Failure}}<div class="alert alert-warning" role="alert">Invalid credentials</div>{{end}} 
+                      {{ if .Throttled}}<div class="alert alert-warning" role="alert">Too many login attempts. Please try again later.</div>{{end}}
 		    </div>
 	          </div>
 	          <div class="panel-body">
@@ -94,6 +150,7 @@ const partials = `
 	          </div>
 	        </div>
               {{end}}
+              {{end}}
 {{end}}`
 
 var layout = `<!DOCTYPE html>
@@ -112,7 +169,9 @@ var layout = `<!DOCTYPE html>
                 <strong>Internal Error. </strong> Please try again later.
               </div>
             {{end}}
-            {{if .Authenticated}}
+            {{if and .Authenticated .PasswordChange}}
+              {{template "passwordChange" . }}
+            {{else if .Authenticated}}
               {{template "userInfo" . }}
             {{else}}
               {{template "login" . }}
@@ -127,9 +186,16 @@ var layout = `<!DOCTYPE html>
 type loginFormData struct {
 	Error         bool
 	Failure       bool
+	Throttled     bool
 	Config        *Config
 	Authenticated bool
+	MFA           bool
 	UserInfo      model.UserInfo
+	// The password change form and its result
+	CanChangePassword bool
+	PasswordChange    bool
+	PasswordChanged   bool
+	PasswordError     string
 }
 
 func writeLoginForm(w http.ResponseWriter, params loginFormData) {
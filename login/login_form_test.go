@@ -60,6 +60,19 @@ func Test_form(t *testing.T) {
 	Contains(t, recorder.Body.String(), `href="/login/github"`)
 	NotContains(t, recorder.Body.String(), `Welcome`)
 	NotContains(t, recorder.Body.String(), `Error`)
+	// with form and saml links
+	recorder = httptest.NewRecorder()
+	writeLoginForm(recorder, loginFormData{
+		Config: &Config{
+			LoginPath: "/login",
+			Backends:  Options{"simple": {}},
+			Saml:      Options{"corp": {}},
+		},
+	})
+	Contains(t, recorder.Body.String(), `<form`)
+	Contains(t, recorder.Body.String(), `href="/login/saml/corp"`)
+	Contains(t, recorder.Body.String(), `Sign in with Corp`)
+	Contains(t, recorder.Body.String(), `login-or`)
 	// show only the user info
 	recorder = httptest.NewRecorder()
 	writeLoginForm(recorder, loginFormData{
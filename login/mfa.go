@@ -0,0 +1,292 @@
+package login
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pchchv/logsrv/logging"
+	"github.com/pchchv/logsrv/metrics"
+	"github.com/pchchv/logsrv/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	mfaCookieName = "logsrv_mfa"
+	// Time to enter the code after the password was accepted
+	mfaExpiry      = 5 * time.Minute
+	mfaMaxAttempts = 5
+
+	mfaPendingPrefix = "mfa-pending:"
+	totpUsedPrefix   = "totp-used:"
+)
+
+var errInvalidMFAToken = errors.New("invalid or expired mfa token")
+
+// Server side state of a login, which is waiting for the second factor
+type pendingMFA struct {
+	// the username of the password login, which is throttled and locked out
+	Username string         `json:"username"`
+	UserInfo model.UserInfo `json:"user_info"`
+	Expiry   int64          `json:"exp"`
+	Attempts int            `json:"attempts"`
+}
+
+// Starts the second step of the login.
+// The pending login is referenced by a random token, which is passed as cookie
+// and in the JSON response, but it is not a jwt and grants no access.
+func (h *Handler) startMFA(w http.ResponseWriter, r *http.Request, username string, userInfo model.UserInfo) {
+	token, err := h.createPendingMFA(username, userInfo)
+	if err != nil {
+		logging.Application(r.Header).WithError(err).Error()
+		h.respondError(w, r)
+		return
+	}
+	h.setMFACookie(w, token, time.Now().Add(mfaExpiry))
+	if wantHTML(r) {
+		writeLoginForm(w,
+			loginFormData{
+				Config:   h.config,
+				MFA:      true,
+				UserInfo: model.UserInfo{Sub: userInfo.Sub},
+			})
+		return
+	}
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.WriteHeader(202)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"mfa_required": true,
+		"mfa_token":    token,
+		"expires_in":   int64(mfaExpiry.Seconds()),
+	}) // ignore error of encoding
+}
+
+// Completes a login with the one time password of the user.
+// Wrong codes count against the lockout of the user like wrong passwords,
+// so that the code can not be guessed by starting new logins with the password.
+func (h *Handler) handleMFA(w http.ResponseWriter, r *http.Request, c credentials) {
+	token := c.MFAToken
+	if token == "" {
+		if cookie, err := r.Cookie(mfaCookieName); err == nil {
+			token = cookie.Value
+		}
+	}
+	pending, err := h.lookupMFA(token)
+	// without a pending login, only the ip is throttled
+	username := ""
+	if err == nil {
+		username = pending.username()
+	}
+	if wait := h.throttle.allow(logging.GetRemoteIp(r), username); wait > 0 {
+		logging.Application(r.Header).
+			WithField("username", username).Warn("second factor throttled")
+		metrics.Logins.WithLabelValues(passwordProvider, metrics.OutcomeThrottled).Inc()
+		logging.Audit(r, logging.AuditEvent{
+			Event: logging.AuditLogin, Subject: pending.UserInfo.Sub, Origin: pending.UserInfo.Origin,
+			Outcome: logging.AuditFailure, Reason: "second factor throttled"})
+		h.respondTooManyRequests(w, r, wait)
+		return
+	}
+	if err == nil {
+		var verified pendingMFA
+		if verified, err = h.verifyMFA(token, c.OTP); err == nil {
+			pending = verified
+		}
+		// a wrong code, the last one drops the pending login
+		if err == errInvalidMFAToken || (err == nil && pending.Attempts > 0) {
+			h.failedMFA(r, pending)
+		}
+	}
+	if err == errInvalidMFAToken {
+		logging.Application(r.Header).Info("failed second factor, no pending login")
+		metrics.Logins.WithLabelValues(passwordProvider, metrics.OutcomeFailure).Inc()
+		logging.Audit(r, logging.AuditEvent{
+			Event: logging.AuditLogin, Outcome: logging.AuditFailure, Reason: "second factor without pending login"})
+		h.deleteMFACookie(w)
+		h.respondAuthFailure(w, r)
+		return
+	}
+	if err != nil {
+		logging.Application(r.Header).WithError(err).Error()
+		metrics.Logins.WithLabelValues(passwordProvider, metrics.OutcomeError).Inc()
+		logging.Audit(r, logging.AuditEvent{
+			Event: logging.AuditLogin, Outcome: logging.AuditError, Reason: err.Error()})
+		h.respondError(w, r)
+		return
+	}
+	if pending.Attempts > 0 {
+		logging.Application(r.Header).
+			WithField("username", pending.UserInfo.Sub).Info("failed second factor")
+		metrics.Logins.WithLabelValues(passwordProvider, metrics.OutcomeFailure).Inc()
+		logging.Audit(r, logging.AuditEvent{
+			Event: logging.AuditLogin, Subject: pending.UserInfo.Sub, Origin: pending.UserInfo.Origin,
+			Outcome: logging.AuditFailure, Reason: "wrong second factor"})
+		h.respondMFAFailure(w, r, pending)
+		return
+	}
+	logging.Application(r.Header).
+		WithField("username", pending.UserInfo.Sub).Info("successfully authenticated with second factor")
+	h.throttle.succeeded(pending.username())
+	h.deleteMFACookie(w)
+	metrics.Logins.WithLabelValues(pending.UserInfo.Origin, metrics.OutcomeSuccess).Inc()
+	logging.Audit(r, logging.AuditEvent{
+		Event: logging.AuditLogin, Subject: pending.UserInfo.Sub, Origin: pending.UserInfo.Origin,
+		Outcome: logging.AuditSuccess, Reason: "second factor"})
+	pending.UserInfo.Amr = []string{amrPassword, amrOTP}
+	h.respondAuthenticated(w, r, pending.UserInfo)
+}
+
+// Records a wrong code of the pending login and locks out the user after repeated failures
+func (h *Handler) failedMFA(r *http.Request, pending pendingMFA) {
+	username := pending.username()
+	if lockout := h.throttle.failed(username); lockout > 0 {
+		logging.Application(r.Header).
+			WithField("username", username).Warnf("user locked out for %v", lockout)
+		logging.Audit(r, logging.AuditEvent{
+			Event: logging.AuditLockout, Subject: username, Outcome: logging.AuditFailure,
+			Reason: fmt.Sprintf("locked out for %v after failed second factors", lockout)})
+	}
+}
+
+// Returns the username of the password login, pending logins of older versions have the subject only
+func (p pendingMFA) username() string {
+	if p.Username != "" {
+		return p.Username
+	}
+	return p.UserInfo.Sub
+}
+
+func (h *Handler) createPendingMFA(username string, userInfo model.UserInfo) (string, error) {
+	if h.store == nil {
+		return "", errors.New("no store configured for the second factor")
+	}
+	token, err := randStringBytes(32)
+	if err != nil {
+		return "", err
+	}
+	expiry := time.Now().Add(mfaExpiry)
+	b, err := json.Marshal(pendingMFA{Username: username, UserInfo: userInfo, Expiry: expiry.Unix()})
+	if err != nil {
+		return "", err
+	}
+	return token, h.store.Set(mfaPendingPrefix+hashToken(token), b, expiry)
+}
+
+// Returns the pending login of the token without changing it
+func (h *Handler) lookupMFA(token string) (pendingMFA, error) {
+	if h.store == nil || token == "" {
+		return pendingMFA{}, errInvalidMFAToken
+	}
+	h.storeMu.Lock()
+	defer h.storeMu.Unlock()
+	b, exist, err := h.store.Get(mfaPendingPrefix + hashToken(token))
+	if err != nil {
+		return pendingMFA{}, err
+	}
+	if !exist {
+		return pendingMFA{}, errInvalidMFAToken
+	}
+	pending := pendingMFA{}
+	return pending, json.Unmarshal(b, &pending)
+}
+
+// Checks the code for the pending login of the token.
+// On success, the pending login is consumed and returned with zero attempts.
+// Otherwise the failed attempts are returned, which are limited per pending login.
+func (h *Handler) verifyMFA(token, code string) (pendingMFA, error) {
+	if h.store == nil || token == "" {
+		return pendingMFA{}, errInvalidMFAToken
+	}
+	h.storeMu.Lock()
+	defer h.storeMu.Unlock()
+	key := mfaPendingPrefix + hashToken(token)
+	b, exist, err := h.store.Get(key)
+	if err != nil {
+		return pendingMFA{}, err
+	}
+	if !exist {
+		return pendingMFA{}, errInvalidMFAToken
+	}
+	pending := pendingMFA{}
+	if err := json.Unmarshal(b, &pending); err != nil {
+		return pendingMFA{}, err
+	}
+	step, valid := validateTOTP(h.totp.secret(pending.UserInfo), code, time.Now())
+	if valid {
+		// every code is accepted once only
+		usedKey := totpUsedPrefix + pending.UserInfo.Sub + ":" + strconv.FormatInt(step, 10)
+		_, used, err := h.store.Get(usedKey)
+		if err != nil {
+			return pendingMFA{}, err
+		}
+		valid = !used
+		if valid {
+			expiry := time.Unix((step+totpSkew+1)*totpPeriod, 0)
+			if err := h.store.Set(usedKey, []byte{}, expiry); err != nil {
+				return pendingMFA{}, err
+			}
+		}
+	}
+	if valid {
+		pending.Attempts = 0
+		return pending, h.store.Delete(key)
+	}
+	pending.Attempts++
+	if pending.Attempts >= mfaMaxAttempts {
+		// the login has to be started again with the password
+		if err := h.store.Delete(key); err != nil {
+			return pendingMFA{}, err
+		}
+		return pendingMFA{}, errInvalidMFAToken
+	}
+	if b, err = json.Marshal(pending); err != nil {
+		return pendingMFA{}, err
+	}
+	// the attempts do not extend the lifetime of the pending login
+	return pending, h.store.Set(key, b, time.Unix(pending.Expiry, 0))
+}
+
+func (h *Handler) respondMFAFailure(w http.ResponseWriter, r *http.Request, pending pendingMFA) {
+	if wantHTML(r) {
+		w.Header().Set("Content-Type", contentTypeHTML)
+		w.WriteHeader(403)
+		writeLoginForm(w,
+			loginFormData{
+				Failure:  true,
+				Config:   h.config,
+				MFA:      true,
+				UserInfo: model.UserInfo{Sub: pending.UserInfo.Sub},
+			})
+		return
+	}
+	if wantJSON(r) {
+		w.Header().Set("Content-Type", contentTypeJSON)
+		w.WriteHeader(403)
+		fmt.Fprintf(w, `{"error": "Wrong code", "attempts_left": %d}`, mfaMaxAttempts-pending.Attempts)
+	} else {
+		w.Header().Set("Content-Type", contentTypePlain)
+		w.WriteHeader(403)
+		fmt.Fprintf(w, "Wrong code")
+	}
+}
+
+func (h *Handler) setMFACookie(w http.ResponseWriter, token string, expiry time.Time) {
+	cookie := &http.Cookie{
+		Name:     mfaCookieName,
+		Value:    token,
+		HttpOnly: true,
+		Secure:   h.config.CookieSecure,
+		Path:     h.config.LoginPath,
+		Expires:  expiry,
+	}
+	if h.config.CookieDomain != "" {
+		cookie.Domain = h.config.CookieDomain
+	}
+	http.SetCookie(w, cookie)
+}
+
+func (h *Handler) deleteMFACookie(w http.ResponseWriter) {
+	h.setMFACookie(w, "delete", time.Unix(0, 0))
+}
@@ -0,0 +1,183 @@
+package login
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func testHandlerWithTOTP() *Handler {
+	h := testHandler()
+	h.totp = &totpSecrets{secrets: map[string]string{"bob": testTOTPSecret}}
+	return h
+}
+
+func currentTOTPCode() string {
+	key, err := decodeTOTPSecret(testTOTPSecret)
+	if err != nil {
+		panic(err)
+	}
+	return hotp(key, time.Now().Unix()/totpPeriod)
+}
+
+// Performs the first step of the login and returns the mfa token of the json response
+func startMFALogin(t *testing.T, h *Handler) string {
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", `{"username": "bob", "password": "secret"}`, TypeJSON, "Accept: application/json"))
+	Equal(t, 202, recorder.Code)
+	Equal(t, contentTypeJSON, recorder.Header().Get("Content-Type"))
+	response := map[string]interface{}{}
+	NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	Equal(t, true, response["mfa_required"])
+	Equal(t, float64(300), response["expires_in"])
+	NotEmpty(t, response["mfa_token"])
+	return response["mfa_token"].(string)
+}
+
+func TestHandler_MFA_JSON(t *testing.T) {
+	h := testHandlerWithTOTP()
+	mfaToken := startMFALogin(t, h)
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", `{"mfa_token": "`+mfaToken+`", "otp": "`+currentTOTPCode()+`"}`, TypeJSON, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+	claims, err := tokenAsMap(recorder.Body.String())
+	NoError(t, err)
+	Equal(t, "bob", claims["sub"])
+	Equal(t, []interface{}{"pwd", "otp"}, claims["amr"])
+	// the pending login is consumed
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", `{"mfa_token": "`+mfaToken+`", "otp": "`+currentTOTPCode()+`"}`, TypeJSON, AcceptJwt))
+	Equal(t, 403, recorder.Code)
+}
+
+func TestHandler_MFA_Web(t *testing.T) {
+	h := testHandlerWithTOTP()
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptHTML))
+	Equal(t, 200, recorder.Code)
+	Contains(t, recorder.Body.String(), `name="otp"`)
+	NotContains(t, recorder.Body.String(), `name="password"`)
+	Nil(t, findCookie(recorder, h.config.CookieName))
+	mfaCookie := findCookie(recorder, mfaCookieName)
+	NotNil(t, mfaCookie)
+	True(t, mfaCookie.HttpOnly)
+	Equal(t, "/context/login", mfaCookie.Path)
+
+	// wrong code
+	recorder = httptest.NewRecorder()
+	r := req("POST", "/context/login", "otp=000000", TypeForm, AcceptHTML)
+	r.AddCookie(mfaCookie)
+	h.ServeHTTP(recorder, r)
+	Equal(t, 403, recorder.Code)
+	Contains(t, recorder.Body.String(), "Invalid code")
+	Contains(t, recorder.Body.String(), `name="otp"`)
+
+	recorder = httptest.NewRecorder()
+	r = req("POST", "/context/login", "otp="+currentTOTPCode(), TypeForm, AcceptHTML)
+	r.AddCookie(mfaCookie)
+	h.ServeHTTP(recorder, r)
+	Equal(t, 303, recorder.Code)
+	Equal(t, "/", recorder.Header().Get("Location"))
+	Equal(t, "delete", findCookie(recorder, mfaCookieName).Value)
+	cookie := findCookie(recorder, h.config.CookieName)
+	NotNil(t, cookie)
+	claims, err := tokenAsMap(cookie.Value)
+	NoError(t, err)
+	Equal(t, []interface{}{"pwd", "otp"}, claims["amr"])
+}
+
+func TestHandler_MFA_WrongPassword(t *testing.T) {
+	h := testHandlerWithTOTP()
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "username=bob&password=wrong", TypeForm, AcceptJwt))
+	Equal(t, 403, recorder.Code)
+	Nil(t, findCookie(recorder, mfaCookieName))
+}
+
+func TestHandler_MFA_MaxAttempts(t *testing.T) {
+	h := testHandlerWithTOTP()
+	mfaToken := startMFALogin(t, h)
+	for i := 1; i < mfaMaxAttempts; i++ {
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, req("POST", "/context/login", "mfa_token="+mfaToken+"&otp=000000", TypeForm, "Accept: application/json"))
+		Equal(t, 403, recorder.Code)
+		Contains(t, recorder.Body.String(), "Wrong code")
+	}
+	// the last attempt drops the pending login, so that even the right code is rejected
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "mfa_token="+mfaToken+"&otp=000000", TypeForm, AcceptJwt))
+	Equal(t, 403, recorder.Code)
+	Equal(t, "Wrong credentials", recorder.Body.String())
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "mfa_token="+mfaToken+"&otp="+currentTOTPCode(), TypeForm, AcceptJwt))
+	Equal(t, 403, recorder.Code)
+}
+
+func TestHandler_MFA_Replay(t *testing.T) {
+	h := testHandlerWithTOTP()
+	code := currentTOTPCode()
+	first, second := startMFALogin(t, h), startMFALogin(t, h)
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "mfa_token="+first+"&otp="+code, TypeForm, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "mfa_token="+second+"&otp="+code, TypeForm, AcceptJwt))
+	Equal(t, 403, recorder.Code)
+	Equal(t, "Wrong code", recorder.Body.String())
+}
+
+func TestHandler_MFA_NoPendingLogin(t *testing.T) {
+	h := testHandlerWithTOTP()
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "otp="+currentTOTPCode(), TypeForm, AcceptJwt))
+	Equal(t, 403, recorder.Code)
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "mfa_token=unknown&otp="+currentTOTPCode(), TypeForm, AcceptJwt))
+	Equal(t, 403, recorder.Code)
+}
+
+func TestHandler_Login_AmrPassword(t *testing.T) {
+	// users without a secret login with the password only
+	h := testHandlerWithTOTP()
+	h.totp.secrets = map[string]string{}
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+	claims, err := tokenAsMap(recorder.Body.String())
+	NoError(t, err)
+	Equal(t, []interface{}{"pwd"}, claims["amr"])
+}
+
+func TestHandler_MFA_WrongCodesLockOut(t *testing.T) {
+	h := testHandlerWithTOTP()
+	h.throttle = newThrottle(&Config{LockoutThreshold: 3, LockoutDuration: time.Minute})
+	// a new login with the password does not reset the failures of the codes
+	for i := 0; i < 3; i++ {
+		mfaToken := startMFALogin(t, h)
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, req("POST", "/context/login", "mfa_token="+mfaToken+"&otp=000000", TypeForm, AcceptJwt))
+		Equal(t, 403, recorder.Code)
+	}
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", `{"username": "bob", "password": "secret"}`, TypeJSON, AcceptJwt))
+	Equal(t, 429, recorder.Code)
+}
+
+func TestHandler_MFA_ResetsFailuresAfterSecondFactor(t *testing.T) {
+	h := testHandlerWithTOTP()
+	h.throttle = newThrottle(&Config{LockoutThreshold: 2, LockoutDuration: time.Minute})
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "username=bob&password=wrong", TypeForm, AcceptJwt))
+	Equal(t, 403, recorder.Code)
+	mfaToken := startMFALogin(t, h)
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "mfa_token="+mfaToken+"&otp="+currentTOTPCode(), TypeForm, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+	// the failure of the password is forgotten
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "username=bob&password=wrong", TypeForm, AcceptJwt))
+	Equal(t, 403, recorder.Code)
+}
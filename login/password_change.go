@@ -0,0 +1,226 @@
+package login
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pchchv/logsrv/logging"
+	"github.com/pchchv/logsrv/model"
+)
+
+const passwordPath = "/password"
+
+// Parameters of a password change by form or JSON
+type passwordChange struct {
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+	// The repetition of the new password in the form, which is optional for JSON requests
+	NewPasswordConfirm string `json:"new_password_confirm"`
+}
+
+// Returns the backend of the user's origin, if it can change passwords
+func (h *Handler) passwordChanger(origin string) PasswordChanger {
+	for i, b := range h.backends {
+		if h.backendName(i) != origin {
+			continue
+		}
+		if changer, ok := b.(PasswordChanger); ok {
+			return changer
+		}
+	}
+	return nil
+}
+
+// Shows the password change form and changes the password of the authenticated user,
+// after the old password was verified and the new password passed the password policy.
+func (h *Handler) handlePasswordChange(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "POST" {
+		h.respondBadRequest(w, r)
+		return
+	}
+	userInfo, valid := h.GetToken(r)
+	if !valid {
+		h.respondAuthFailure(w, r)
+		return
+	}
+	changer := h.passwordChanger(userInfo.Origin)
+	if changer == nil {
+		h.respondPasswordChangeFailure(w, r, userInfo, 403, "Password change is not supported for this user")
+		return
+	}
+	if r.Method == "GET" {
+		if !wantHTML(r) {
+			h.respondBadRequest(w, r)
+			return
+		}
+		writeLoginForm(w, loginFormData{
+			Config:         h.config,
+			Authenticated:  true,
+			PasswordChange: true,
+			UserInfo:       userInfo,
+		})
+		return
+	}
+	p, err := getPasswordChange(r)
+	if err != nil {
+		h.respondBadRequest(w, r)
+		return
+	}
+	if wait := h.throttle.allow(logging.GetRemoteIp(r), userInfo.Sub); wait > 0 {
+		logging.Application(r.Header).
+			WithField("username", userInfo.Sub).Warn("password change throttled")
+		h.auditPasswordChange(r, userInfo, logging.AuditFailure, "throttled")
+		h.respondTooManyRequests(w, r, wait)
+		return
+	}
+	reason, err := h.checkPasswordPolicy(p)
+	if err != nil {
+		logging.Application(r.Header).WithError(err).Error()
+		h.auditPasswordChange(r, userInfo, logging.AuditError, err.Error())
+		h.respondError(w, r)
+		return
+	}
+	if reason != "" {
+		h.auditPasswordChange(r, userInfo, logging.AuditFailure, reason)
+		h.respondPasswordChangeFailure(w, r, userInfo, 400, reason)
+		return
+	}
+	changed, err := changer.ChangePassword(userInfo.Sub, p.OldPassword, p.NewPassword)
+	if err != nil {
+		logging.Application(r.Header).WithError(err).Error()
+		h.auditPasswordChange(r, userInfo, logging.AuditError, err.Error())
+		h.respondError(w, r)
+		return
+	}
+	if !changed {
+		logging.Application(r.Header).
+			WithField("username", userInfo.Sub).Info("password change with wrong password")
+		h.auditPasswordChange(r, userInfo, logging.AuditFailure, "wrong password")
+		if lockout := h.throttle.failed(userInfo.Sub); lockout > 0 {
+			logging.Audit(r, logging.AuditEvent{
+				Event: logging.AuditLockout, Subject: userInfo.Sub, Outcome: logging.AuditFailure,
+				Reason: fmt.Sprintf("locked out for %v after failed password changes", lockout)})
+		}
+		h.respondPasswordChangeFailure(w, r, userInfo, 403, "Wrong password")
+		return
+	}
+	h.throttle.succeeded(userInfo.Sub)
+	logging.Application(r.Header).
+		WithField("username", userInfo.Sub).Info("password changed")
+	h.auditPasswordChange(r, userInfo, logging.AuditSuccess, "")
+	if wantHTML(r) {
+		writeLoginForm(w, loginFormData{
+			Config:          h.config,
+			Authenticated:   true,
+			PasswordChange:  true,
+			PasswordChanged: true,
+			UserInfo:        userInfo,
+		})
+		return
+	}
+	w.WriteHeader(204)
+}
+
+func getPasswordChange(r *http.Request) (passwordChange, error) {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), contentTypeJSON) {
+		p := passwordChange{}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return passwordChange{}, err
+		}
+		err = json.Unmarshal(body, &p)
+		return p, err
+	}
+	if err := r.ParseForm(); err != nil {
+		return passwordChange{}, err
+	}
+	return passwordChange{
+		OldPassword:        r.PostForm.Get("old_password"),
+		NewPassword:        r.PostForm.Get("new_password"),
+		NewPasswordConfirm: r.PostForm.Get("new_password_confirm"),
+	}, nil
+}
+
+// Checks the new password against the password policy.
+// It returns the reason for the rejection of the password or an empty string.
+func (h *Handler) checkPasswordPolicy(p passwordChange) (string, error) {
+	if p.NewPasswordConfirm != "" && p.NewPasswordConfirm != p.NewPassword {
+		return "The new passwords do not match", nil
+	}
+	if utf8.RuneCountInString(p.NewPassword) < h.config.PasswordMinLength || p.NewPassword == "" {
+		return fmt.Sprintf("The new password needs at least %d characters", h.config.PasswordMinLength), nil
+	}
+	if p.NewPassword == p.OldPassword {
+		return "The new password has to differ from the old one", nil
+	}
+	if h.config.PasswordBreachedFile != "" {
+		breached, err := isBreachedPassword(h.config.PasswordBreachedFile, p.NewPassword)
+		if err != nil || breached {
+			return "The new password is known from a data breach", err
+		}
+	}
+	return "", nil
+}
+
+// Searches the password in the file of breached passwords, which has one password or its SHA-1 hash per line.
+// Hashes may be followed by a count, like in the Pwned Passwords list (HASH:COUNT).
+// The file is read on every check, because password changes are rare and the lists can be large.
+func isBreachedPassword(file, password string) (bool, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	digest := sha1.Sum([]byte(password))
+	hash := hex.EncodeToString(digest[:])
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == password {
+			return true, nil
+		}
+		if len(line) >= len(hash) && strings.EqualFold(line[:len(hash)], hash) &&
+			(len(line) == len(hash) || line[len(hash)] == ':') {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+func (h *Handler) auditPasswordChange(r *http.Request, userInfo model.UserInfo, outcome, reason string) {
+	logging.Audit(r, logging.AuditEvent{
+		Event: logging.AuditPasswordChange, Subject: userInfo.Sub, Origin: userInfo.Origin,
+		Outcome: outcome, Reason: reason})
+}
+
+func (h *Handler) respondPasswordChangeFailure(w http.ResponseWriter, r *http.Request, userInfo model.UserInfo, status int, message string) {
+	if wantHTML(r) {
+		w.Header().Set("Content-Type", contentTypeHTML)
+		w.WriteHeader(status)
+		writeLoginForm(w, loginFormData{
+			Config:         h.config,
+			Authenticated:  true,
+			PasswordChange: true,
+			PasswordError:  message,
+			UserInfo:       userInfo,
+		})
+		return
+	}
+	if wantJSON(r) {
+		w.Header().Set("Content-Type", contentTypeJSON)
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{"error": message})
+		return
+	}
+	w.Header().Set("Content-Type", contentTypePlain)
+	w.WriteHeader(status)
+	fmt.Fprint(w, message)
+}
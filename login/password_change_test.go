@@ -0,0 +1,158 @@
+package login
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pchchv/logsrv/logging"
+	"github.com/pchchv/logsrv/model"
+	. "github.com/stretchr/testify/assert"
+)
+
+const passwordTestProvider = "passwordtest"
+
+// Backend with passwords in memory, which can be changed
+type passwordTestBackend map[string]string
+
+func (b passwordTestBackend) Authenticate(username, password string) (bool, model.UserInfo, error) {
+	if p, exist := b[username]; exist && p == password {
+		return true, model.UserInfo{Sub: username, Origin: passwordTestProvider}, nil
+	}
+	return false, model.UserInfo{}, nil
+}
+
+func (b passwordTestBackend) ChangePassword(username, oldPassword, newPassword string) (bool, error) {
+	if p, exist := b[username]; !exist || p != oldPassword {
+		return false, nil
+	}
+	b[username] = newPassword
+	return true, nil
+}
+
+func passwordTestHandler(t *testing.T) (*Handler, passwordTestBackend, string) {
+	backend := passwordTestBackend{"bob": "secret"}
+	h := testHandler()
+	h.backends = append(h.backends, backend)
+	h.routingRules = []backendRoute{{name: SimpleProviderName}, {name: passwordTestProvider}}
+	token, err := h.createToken(model.UserInfo{Sub: "bob", Origin: passwordTestProvider, Expiry: time.Now().Add(time.Minute).Unix()})
+	NoError(t, err)
+	return h, backend, "Authorization: Bearer " + token
+}
+
+func TestHandler_PasswordChange(t *testing.T) {
+	b := &auditBuffer{}
+	NoError(t, logging.SetAuditLog(b))
+	defer logging.SetAuditLog(nil)
+	h, backend, bearer := passwordTestHandler(t)
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/password", `{"old_password": "secret", "new_password": "new-secret"}`, TypeJSON, bearer))
+	Equal(t, 204, recorder.Code)
+	Equal(t, "new-secret", backend["bob"])
+
+	e := logging.AuditEvent{}
+	NoError(t, json.Unmarshal([]byte(strings.TrimSpace(b.String())), &e))
+	Equal(t, logging.AuditPasswordChange, e.Event)
+	Equal(t, logging.AuditSuccess, e.Outcome)
+	Equal(t, "bob", e.Subject)
+	Equal(t, passwordTestProvider, e.Origin)
+}
+
+func TestHandler_PasswordChange_Form(t *testing.T) {
+	h, backend, bearer := passwordTestHandler(t)
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login", "", AcceptHTML, bearer))
+	Contains(t, recorder.Body.String(), `href="/context/login/password"`)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/password", "", AcceptHTML, bearer))
+	Equal(t, 200, recorder.Code)
+	Contains(t, recorder.Body.String(), `name="new_password_confirm"`)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/password", "old_password=secret&new_password=new-secret&new_password_confirm=new-secreT", TypeForm, AcceptHTML, bearer))
+	Equal(t, 400, recorder.Code)
+	Contains(t, recorder.Body.String(), "The new passwords do not match")
+	Equal(t, "secret", backend["bob"])
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/password", "old_password=secret&new_password=new-secret&new_password_confirm=new-secret", TypeForm, AcceptHTML, bearer))
+	Equal(t, 200, recorder.Code)
+	Contains(t, recorder.Body.String(), "Your password has been changed")
+	Equal(t, "new-secret", backend["bob"])
+}
+
+func TestHandler_PasswordChange_Rejected(t *testing.T) {
+	breached, err := os.CreateTemp("", "logsrv_breached")
+	NoError(t, err)
+	defer os.Remove(breached.Name())
+	// sha1 of password123 in the format of the Pwned Passwords list
+	_, err = breached.WriteString("qwertzuiop\nCBFDAC6008F9CAB4083784CBD1874F76618D2A97:2254650\n")
+	NoError(t, err)
+	NoError(t, breached.Close())
+
+	tests := []struct {
+		title    string
+		body     string
+		bearer   bool
+		code     int
+		expected string
+	}{
+		{"not authenticated", `{"old_password": "secret", "new_password": "new-secret"}`, false, 403, "Wrong credentials"},
+		{"wrong password", `{"old_password": "XXX", "new_password": "new-secret"}`, true, 403, "Wrong password"},
+		{"too short", `{"old_password": "secret", "new_password": "short"}`, true, 400, "The new password needs at least 8 characters"},
+		{"unchanged", `{"old_password": "secret12", "new_password": "secret12"}`, true, 400, "The new password has to differ from the old one"},
+		{"breached", `{"old_password": "secret", "new_password": "qwertzuiop"}`, true, 400, "The new password is known from a data breach"},
+		{"breached hash", `{"old_password": "secret", "new_password": "password123"}`, true, 400, "The new password is known from a data breach"},
+		{"invalid json", `{"old_password"`, true, 400, "Bad Request"},
+	}
+	for _, test := range tests {
+		t.Run(test.title, func(t *testing.T) {
+			h, backend, bearer := passwordTestHandler(t)
+			h.config.PasswordBreachedFile = breached.Name()
+			headers := []string{TypeJSON, "Accept: application/json"}
+			if test.bearer {
+				headers = append(headers, bearer)
+			}
+			recorder := httptest.NewRecorder()
+			h.ServeHTTP(recorder, req("POST", "/context/login/password", test.body, headers...))
+			Equal(t, test.code, recorder.Code)
+			Contains(t, recorder.Body.String(), test.expected)
+			Equal(t, "secret", backend["bob"])
+		})
+	}
+}
+
+func TestHandler_PasswordChange_NotSupported(t *testing.T) {
+	h := testHandler()
+	token, err := h.createToken(model.UserInfo{Sub: "bob", Origin: SimpleProviderName, Expiry: time.Now().Add(time.Minute).Unix()})
+	NoError(t, err)
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/password", `{"old_password": "secret", "new_password": "new-secret"}`, TypeJSON, "Accept: application/json", "Authorization: Bearer "+token))
+	Equal(t, 403, recorder.Code)
+	Contains(t, recorder.Body.String(), "Password change is not supported for this user")
+	// and no link in the login form
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login", "", AcceptHTML, "Authorization: Bearer "+token))
+	NotContains(t, recorder.Body.String(), "/context/login/password")
+}
+
+func TestHandler_PasswordChange_Lockout(t *testing.T) {
+	h, backend, bearer := passwordTestHandler(t)
+	h.config.LockoutThreshold = 2
+	h.throttle = newThrottle(h.config)
+	for i := 0; i < 2; i++ {
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, req("POST", "/context/login/password", `{"old_password": "XXX", "new_password": "new-secret"}`, TypeJSON, bearer))
+		Equal(t, 403, recorder.Code)
+	}
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/password", `{"old_password": "secret", "new_password": "new-secret"}`, TypeJSON, bearer))
+	Equal(t, 429, recorder.Code)
+	Equal(t, "secret", backend["bob"])
+}
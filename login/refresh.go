@@ -0,0 +1,270 @@
+package login
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pchchv/logsrv/logging"
+	"github.com/pchchv/logsrv/metrics"
+	"github.com/pchchv/logsrv/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	refreshPath = "/refresh"
+
+	refreshTokenPrefix  = "refresh:"
+	refreshFamilyPrefix = "refresh-family:"
+)
+
+var errInvalidRefreshToken = errors.New("invalid refresh token")
+
+// Server side state of a refresh token.
+// All tokens, which are rotated out of the same login, belong to one family.
+type refreshTokenEntry struct {
+	Family string `json:"family"`
+	// Time of the login, which started the family
+	IssuedAt int64          `json:"iat"`
+	Expiry   int64          `json:"exp"`
+	Used     bool           `json:"used"`
+	UserInfo model.UserInfo `json:"user_info"`
+}
+
+// Starts a new family of refresh tokens, e.g. on login
+func startRefreshFamily() (*refreshTokenEntry, error) {
+	family, err := randStringBytes(16)
+	if err != nil {
+		return nil, err
+	}
+	return &refreshTokenEntry{Family: family, IssuedAt: time.Now().Unix()}, nil
+}
+
+// Issues a new refresh token of the family for the user
+func (h *Handler) issueRefreshToken(userInfo model.UserInfo, family string, familyIssuedAt int64) (string, error) {
+	if h.store == nil {
+		return "", errors.New("no store configured for refresh tokens")
+	}
+	token, err := randStringBytes(32)
+	if err != nil {
+		return "", err
+	}
+	userInfo.Expiry, userInfo.ID, userInfo.IssuedAt = 0, "", 0
+	expiry := time.Now().Add(h.config.RefreshTokenExpiry)
+	entry := refreshTokenEntry{
+		Family:   family,
+		IssuedAt: familyIssuedAt,
+		Expiry:   expiry.Unix(),
+		UserInfo: userInfo,
+	}
+	if err := h.setRefreshTokenEntry(token, entry); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// Redeems the refresh token and returns its entry.
+// A token can be used once only, the reuse of a token revokes the whole family,
+// because either the legitimate user or an attacker holds a stolen token.
+func (h *Handler) useRefreshToken(token string) (refreshTokenEntry, error) {
+	if h.store == nil || token == "" {
+		return refreshTokenEntry{}, errInvalidRefreshToken
+	}
+	h.storeMu.Lock()
+	defer h.storeMu.Unlock()
+	b, exist, err := h.store.Get(refreshTokenPrefix + hashToken(token))
+	if err != nil {
+		return refreshTokenEntry{}, err
+	}
+	if !exist {
+		return refreshTokenEntry{}, errInvalidRefreshToken
+	}
+	entry := refreshTokenEntry{}
+	if err := json.Unmarshal(b, &entry); err != nil {
+		return refreshTokenEntry{}, err
+	}
+	_, familyRevoked, err := h.store.Get(refreshFamilyPrefix + entry.Family)
+	if err != nil {
+		return refreshTokenEntry{}, err
+	}
+	if familyRevoked {
+		return refreshTokenEntry{}, errInvalidRefreshToken
+	}
+	if entry.Used {
+		logging.Logger.WithField("username", entry.UserInfo.Sub).Warn("reuse of refresh token detected, revoking the session")
+		if err := h.revokeRefreshFamily(entry.Family); err != nil {
+			return refreshTokenEntry{}, err
+		}
+		return refreshTokenEntry{}, errInvalidRefreshToken
+	}
+	// all sessions of the user may have been revoked
+	revoked, err := h.isRevoked(model.UserInfo{Sub: entry.UserInfo.Sub, IssuedAt: entry.IssuedAt})
+	if err != nil {
+		return refreshTokenEntry{}, err
+	}
+	if revoked {
+		return refreshTokenEntry{}, errInvalidRefreshToken
+	}
+	// the used token is kept until its expiry to detect a reuse
+	entry.Used = true
+	if err := h.setRefreshTokenEntry(token, entry); err != nil {
+		return refreshTokenEntry{}, err
+	}
+	return entry, nil
+}
+
+func (h *Handler) setRefreshTokenEntry(token string, entry refreshTokenEntry) error {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return h.store.Set(refreshTokenPrefix+hashToken(token), b, time.Unix(entry.Expiry, 0))
+}
+
+func (h *Handler) revokeRefreshFamily(family string) error {
+	return h.store.Set(refreshFamilyPrefix+family, []byte{}, time.Now().Add(h.config.RefreshTokenExpiry))
+}
+
+// Revokes the family of the refresh token, e.g. on logout
+func (h *Handler) revokeRefreshToken(token string) error {
+	if h.store == nil || token == "" {
+		return nil
+	}
+	b, exist, err := h.store.Get(refreshTokenPrefix + hashToken(token))
+	if err != nil || !exist {
+		return err
+	}
+	entry := refreshTokenEntry{}
+	if err := json.Unmarshal(b, &entry); err != nil {
+		return err
+	}
+	return h.revokeRefreshFamily(entry.Family)
+}
+
+// Only the hash is stored, so that the tokens can not be taken from the store
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Checks with the backends, that the user is still valid, e.g. not deleted or locked
+func (h *Handler) validateUser(userInfo model.UserInfo) (bool, error) {
+	for _, b := range h.backends {
+		v, ok := b.(UserValidator)
+		if !ok {
+			continue
+		}
+		valid, err := v.ValidateUser(userInfo)
+		if err != nil || !valid {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// Exchanges a refresh token for a new jwt and a new refresh token
+func (h *Handler) handleRefreshToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		h.respondBadRequest(w, r)
+		return
+	}
+	token, err := getRefreshToken(r, h.config.RefreshCookieName)
+	if err != nil {
+		h.respondBadRequest(w, r)
+		return
+	}
+	entry, err := h.useRefreshToken(token)
+	if err == errInvalidRefreshToken {
+		logging.Audit(r, logging.AuditEvent{
+			Event: logging.AuditRefresh, Outcome: logging.AuditFailure, Reason: "invalid refresh token"})
+		h.deleteRefreshCookie(w)
+		h.respondAuthFailure(w, r)
+		return
+	}
+	if err != nil {
+		logging.Application(r.Header).WithError(err).Error()
+		h.respondError(w, r)
+		return
+	}
+	valid, err := h.validateUser(entry.UserInfo)
+	if err != nil {
+		logging.Application(r.Header).WithError(err).Error()
+		logging.Audit(r, logging.AuditEvent{
+			Event: logging.AuditRefresh, Subject: entry.UserInfo.Sub, Origin: entry.UserInfo.Origin,
+			Outcome: logging.AuditError, Reason: err.Error()})
+		h.respondError(w, r)
+		return
+	}
+	if !valid {
+		logging.Application(r.Header).
+			WithField("username", entry.UserInfo.Sub).Info("user rejected on refresh")
+		logging.Audit(r, logging.AuditEvent{
+			Event: logging.AuditRefresh, Subject: entry.UserInfo.Sub, Origin: entry.UserInfo.Origin,
+			Outcome: logging.AuditFailure, Reason: "user rejected by the backend"})
+		h.deleteRefreshCookie(w)
+		h.respondAuthFailure(w, r)
+		return
+	}
+	logging.Application(r.Header).
+		WithField("username", entry.UserInfo.Sub).Info("refreshed jwt by refresh token")
+	metrics.TokenRefreshes.WithLabelValues(metrics.RefreshToken).Inc()
+	logging.Audit(r, logging.AuditEvent{
+		Event: logging.AuditRefresh, Subject: entry.UserInfo.Sub, Origin: entry.UserInfo.Origin,
+		Outcome: logging.AuditSuccess, Reason: "refresh token"})
+	h.respondTokens(w, r, entry.UserInfo, &entry)
+}
+
+// Reads the refresh token from the form, a json body or the cookie
+func getRefreshToken(r *http.Request, cookieName string) (string, error) {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), contentTypeJSON) {
+		m := map[string]string{}
+		if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+			return "", err
+		}
+		if m["refresh_token"] != "" {
+			return m["refresh_token"], nil
+		}
+	} else if token := r.FormValue("refresh_token"); token != "" {
+		return token, nil
+	}
+	if c, err := r.Cookie(cookieName); err == nil {
+		return c.Value, nil
+	}
+	return "", nil
+}
+
+func (h *Handler) setRefreshCookie(w http.ResponseWriter, token string) {
+	cookie := &http.Cookie{
+		Name:     h.config.RefreshCookieName,
+		Value:    token,
+		HttpOnly: true,
+		Secure:   h.config.CookieSecure,
+		// the refresh token is only needed by the login resource
+		Path:    h.config.LoginPath,
+		Expires: time.Now().Add(h.config.RefreshTokenExpiry),
+	}
+	if h.config.CookieDomain != "" {
+		cookie.Domain = h.config.CookieDomain
+	}
+	http.SetCookie(w, cookie)
+}
+
+func (h *Handler) deleteRefreshCookie(w http.ResponseWriter) {
+	if h.config.RefreshTokenExpiry == 0 {
+		return
+	}
+	cookie := &http.Cookie{
+		Name:     h.config.RefreshCookieName,
+		Value:    "delete",
+		HttpOnly: true,
+		Expires:  time.Unix(0, 0),
+		Path:     h.config.LoginPath,
+	}
+	if h.config.CookieDomain != "" {
+		cookie.Domain = h.config.CookieDomain
+	}
+	http.SetCookie(w, cookie)
+}
@@ -0,0 +1,160 @@
+package login
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func testHandlerWithRefresh() *Handler {
+	h := testHandler()
+	h.config.RefreshTokenExpiry = time.Hour
+	return h
+}
+
+func findCookie(recorder *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, c := range recorder.Result().Cookies() {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// Logs in bob and returns the access and refresh token of the json response
+func loginWithRefresh(t *testing.T, h *Handler) (string, string) {
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", `{"username": "bob", "password": "secret"}`, TypeJSON, "Accept: application/json"))
+	Equal(t, 200, recorder.Code)
+	Equal(t, contentTypeJSON, recorder.Header().Get("Content-Type"))
+	response := map[string]interface{}{}
+	NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	Equal(t, "Bearer", response["token_type"])
+	Equal(t, float64(24*60*60), response["expires_in"])
+	NotEmpty(t, response["refresh_token"])
+	return response["access_token"].(string), response["refresh_token"].(string)
+}
+
+func refresh(h *Handler, refreshToken string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/refresh", "refresh_token="+refreshToken, TypeForm, AcceptJwt))
+	return recorder
+}
+
+func TestHandler_RefreshToken(t *testing.T) {
+	h := testHandlerWithRefresh()
+	_, refreshToken := loginWithRefresh(t, h)
+	recorder := refresh(h, refreshToken)
+	Equal(t, 200, recorder.Code)
+	Equal(t, contentTypeJWT, recorder.Header().Get("Content-Type"))
+	claims, err := tokenAsMap(recorder.Body.String())
+	NoError(t, err)
+	Equal(t, "bob", claims["sub"])
+	// the refresh token is rotated
+	cookie := findCookie(recorder, h.config.RefreshCookieName)
+	NotNil(t, cookie)
+	True(t, cookie.HttpOnly)
+	Equal(t, "/context/login", cookie.Path)
+	NotEqual(t, refreshToken, cookie.Value)
+	recorder = refresh(h, cookie.Value)
+	Equal(t, 200, recorder.Code)
+}
+
+func TestHandler_RefreshToken_ReuseDetection(t *testing.T) {
+	h := testHandlerWithRefresh()
+	_, refreshToken := loginWithRefresh(t, h)
+	recorder := refresh(h, refreshToken)
+	Equal(t, 200, recorder.Code)
+	rotated := findCookie(recorder, h.config.RefreshCookieName).Value
+	// the reuse of the old token revokes the whole family
+	Equal(t, 403, refresh(h, refreshToken).Code)
+	Equal(t, 403, refresh(h, rotated).Code)
+	// other logins are not affected
+	_, other := loginWithRefresh(t, h)
+	Equal(t, 200, refresh(h, other).Code)
+}
+
+func TestHandler_RefreshToken_Cookie(t *testing.T) {
+	h := testHandlerWithRefresh()
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptHTML))
+	Equal(t, 303, recorder.Code)
+	cookie := findCookie(recorder, h.config.RefreshCookieName)
+	NotNil(t, cookie)
+	recorder = httptest.NewRecorder()
+	r := req("POST", "/context/login/refresh", "", TypeForm, AcceptHTML)
+	r.AddCookie(cookie)
+	h.ServeHTTP(recorder, r)
+	Equal(t, 303, recorder.Code)
+	NotNil(t, findCookie(recorder, h.config.CookieName))
+	// logout revokes the refresh token
+	rotated := findCookie(recorder, h.config.RefreshCookieName)
+	recorder = httptest.NewRecorder()
+	r = req("DELETE", "/context/login", "")
+	r.AddCookie(rotated)
+	h.ServeHTTP(recorder, r)
+	Equal(t, "delete", findCookie(recorder, h.config.RefreshCookieName).Value)
+	Equal(t, 403, refresh(h, rotated.Value).Code)
+}
+
+func TestHandler_RefreshToken_Revalidation(t *testing.T) {
+	h := testHandlerWithRefresh()
+	_, refreshToken := loginWithRefresh(t, h)
+	// bob was removed from the backend
+	h.backends = []Backend{NewSimpleBackend(map[string]string{"alice": "secret"})}
+	recorder := refresh(h, refreshToken)
+	Equal(t, 403, recorder.Code)
+	Equal(t, "delete", findCookie(recorder, h.config.RefreshCookieName).Value)
+}
+
+func TestHandler_RefreshToken_RejectedByUserEndpoint(t *testing.T) {
+	rejected := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rejected {
+			w.WriteHeader(403)
+			return
+		}
+		w.WriteHeader(404)
+	}))
+	defer server.Close()
+	h := testHandlerWithRefresh()
+	provider, err := newUserClaimsProvider(server.URL, "", time.Second)
+	NoError(t, err)
+	h.userClaims = provider.Claims
+	_, refreshToken := loginWithRefresh(t, h)
+	rejected = true
+	Equal(t, 403, refresh(h, refreshToken).Code)
+}
+
+func TestHandler_RefreshToken_RevokeAll(t *testing.T) {
+	h := testHandlerWithRefresh()
+	accessToken, refreshToken := loginWithRefresh(t, h)
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, tokenRequest(h, "POST", "/context/login/revoke-all", accessToken))
+	Equal(t, 200, recorder.Code)
+	Equal(t, 403, refresh(h, refreshToken).Code)
+}
+
+func TestHandler_RefreshToken_Invalid(t *testing.T) {
+	h := testHandlerWithRefresh()
+	Equal(t, 403, refresh(h, "").Code)
+	Equal(t, 403, refresh(h, "unknown").Code)
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/refresh", `{"refresh_token": "unknown"}`, TypeJSON))
+	Equal(t, 403, recorder.Code)
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/refresh", ""))
+	Equal(t, 400, recorder.Code)
+	// refresh tokens are not issued, if disabled
+	h = testHandler()
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+	Nil(t, findCookie(recorder, h.config.RefreshCookieName))
+	False(t, strings.Contains(recorder.Header().Get("Content-Type"), contentTypeJSON))
+}
@@ -0,0 +1,169 @@
+package login
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pchchv/logsrv/logging"
+	"github.com/pchchv/logsrv/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	revokePath    = "/revoke"
+	revokeAllPath = "/revoke-all"
+
+	revokedIDPrefix  = "revoked:jti:"
+	revokedSubPrefix = "revoked:sub:"
+)
+
+// Marks a single token as revoked until it expires
+func (h *Handler) revokeToken(userInfo model.UserInfo) error {
+	if h.store == nil {
+		return errors.New("no store configured for revocations")
+	}
+	if userInfo.ID == "" {
+		return errors.Errorf("token of %v has no jti and can not be revoked", userInfo.Sub)
+	}
+	if err := h.store.Set(revokedIDPrefix+userInfo.ID, []byte{}, time.Unix(userInfo.Expiry, 0)); err != nil {
+		return err
+	}
+	return h.forgetSessions(session{ID: userInfo.ID})
+}
+
+// Revokes all tokens of the user, which have been issued up to now
+func (h *Handler) revokeAllTokens(sub string) error {
+	if h.store == nil {
+		return errors.New("no store configured for revocations")
+	}
+	now := time.Now()
+	// tokens issued before are expired after the jwt or refresh token expiry at the latest
+	expiry := h.config.JwtExpiry
+	if h.config.RefreshTokenExpiry > expiry {
+		expiry = h.config.RefreshTokenExpiry
+	}
+	if err := h.store.Set(revokedSubPrefix+sub, []byte(strconv.FormatInt(now.UnixNano(), 10)), now.Add(expiry)); err != nil {
+		return err
+	}
+	sessions, err := h.sessions(sub)
+	if err != nil {
+		return err
+	}
+	return h.forgetSessions(sessions...)
+}
+
+// Checks the revocation of the single token and of all tokens of the user.
+// Tokens issued in the same second as a revocation of all tokens are revoked as well,
+// because the iat of a token has a precision of seconds, see waitForRevocation.
+func (h *Handler) isRevoked(userInfo model.UserInfo) (bool, error) {
+	if h.store == nil {
+		return false, nil
+	}
+	if userInfo.ID != "" {
+		_, revoked, err := h.store.Get(revokedIDPrefix + userInfo.ID)
+		if err != nil || revoked {
+			return revoked, err
+		}
+	}
+	revokedAt, err := h.revokedAllAt(userInfo.Sub)
+	if err != nil || revokedAt.IsZero() {
+		return false, err
+	}
+	return userInfo.IssuedAt <= revokedAt.Unix(), nil
+}
+
+// Returns the time of the last revocation of all tokens of the user or the zero time
+func (h *Handler) revokedAllAt(sub string) (time.Time, error) {
+	v, exist, err := h.store.Get(revokedSubPrefix + sub)
+	if err != nil || !exist {
+		return time.Time{}, err
+	}
+	revokedAt, err := strconv.ParseInt(string(v), 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	// older versions stored the seconds
+	if revokedAt < 1e12 {
+		return time.Unix(revokedAt, 0), nil
+	}
+	return time.Unix(0, revokedAt), nil
+}
+
+// Waits for the second after a revocation of all tokens of the user in the current second,
+// so that the new tokens of a login right after the revocation are not revoked as well.
+func (h *Handler) waitForRevocation(sub string) error {
+	if h.store == nil {
+		return nil
+	}
+	revokedAt, err := h.revokedAllAt(sub)
+	if err != nil {
+		return err
+	}
+	if now := time.Now(); now.Unix() == revokedAt.Unix() {
+		time.Sleep(time.Unix(revokedAt.Unix()+1, 0).Sub(now))
+	}
+	return nil
+}
+
+// Revokes the token given by the form parameter token or the token of the cookie.
+// Like RFC 7009, the request succeeds also for invalid tokens, because there is nothing to revoke.
+func (h *Handler) handleRevoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		h.respondBadRequest(w, r)
+		return
+	}
+	tokenString := r.FormValue("token")
+	if tokenString == "" {
+		var source string
+		if tokenString, source = h.tokenFromRequest(r); source == tokenSourceCookie {
+			h.deleteToken(w, r)
+		}
+	}
+	if userInfo, valid, _ := h.verifyToken(tokenString); valid {
+		if err := h.revokeToken(userInfo); err != nil {
+			logging.Application(r.Header).WithError(err).Error()
+			h.respondError(w, r)
+			return
+		}
+		logging.Application(r.Header).
+			WithField("username", userInfo.Sub).Info("revoked jwt")
+		logging.Audit(r, logging.AuditEvent{
+			Event: logging.AuditRevocation, Subject: userInfo.Sub, Origin: userInfo.Origin,
+			Outcome: logging.AuditSuccess, Reason: "token"})
+	}
+	w.WriteHeader(200)
+}
+
+// Revokes all tokens of the user with the token of the request, i.e. a logout everywhere
+func (h *Handler) handleRevokeAll(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		h.respondBadRequest(w, r)
+		return
+	}
+	userInfo, valid := h.GetToken(r)
+	if !valid {
+		h.respondAuthFailure(w, r)
+		return
+	}
+	if err := h.revokeAllTokens(userInfo.Sub); err != nil {
+		logging.Application(r.Header).WithError(err).Error()
+		h.respondError(w, r)
+		return
+	}
+	logging.Application(r.Header).
+		WithField("username", userInfo.Sub).Info("revoked all jwts")
+	logging.Audit(r, logging.AuditEvent{
+		Event: logging.AuditRevocation, Subject: userInfo.Sub, Origin: userInfo.Origin,
+		Outcome: logging.AuditSuccess, Reason: "all tokens"})
+	h.deleteToken(w, r)
+	h.deleteRefreshCookie(w)
+	w.WriteHeader(200)
+}
+
+// Reports, if the request has a token, which is signed by us, but has been revoked
+func (h *Handler) HasRevokedToken(r *http.Request) bool {
+	tokenString, _ := h.tokenFromRequest(r)
+	_, _, revoked := h.verifyToken(tokenString)
+	return revoked
+}
@@ -0,0 +1,136 @@
+package login
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pchchv/logsrv/model"
+	. "github.com/stretchr/testify/assert"
+)
+
+func tokenRequest(h *Handler, method, url, token string) *http.Request {
+	r := req(method, url, "", TypeForm)
+	r.Header.Set("Cookie", h.config.CookieName+"="+token)
+	return r
+}
+
+func TestHandler_Revoke(t *testing.T) {
+	h := testHandler()
+	input := model.UserInfo{Sub: "bob", Expiry: time.Now().Add(time.Minute).Unix()}
+	token, err := h.createToken(input)
+	NoError(t, err)
+	other, err := h.createToken(input)
+	NoError(t, err)
+	_, valid := h.GetToken(tokenRequest(h, "GET", "/context/login", token))
+	True(t, valid)
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, tokenRequest(h, "POST", "/context/login/revoke", token))
+	Equal(t, 200, recorder.Code)
+	Contains(t, recorder.Header().Get("Set-Cookie"), h.config.CookieName+"=delete")
+	_, valid = h.GetToken(tokenRequest(h, "GET", "/context/login", token))
+	False(t, valid)
+	True(t, h.HasRevokedToken(tokenRequest(h, "GET", "/context/login", token)))
+	// other tokens of the user are still valid
+	_, valid = h.GetToken(tokenRequest(h, "GET", "/context/login", other))
+	True(t, valid)
+	False(t, h.HasRevokedToken(tokenRequest(h, "GET", "/context/login", other)))
+	// the token can be passed as parameter, invalid tokens are ignored
+	for _, body := range []string{"token=" + other, "token=garbage", ""} {
+		recorder = httptest.NewRecorder()
+		h.ServeHTTP(recorder, req("POST", "/context/login/revoke", body, TypeForm))
+		Equal(t, 200, recorder.Code)
+	}
+	_, valid = h.GetToken(tokenRequest(h, "GET", "/context/login", other))
+	False(t, valid)
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/revoke", ""))
+	Equal(t, 400, recorder.Code)
+}
+
+func TestHandler_RevokeAll(t *testing.T) {
+	h := testHandler()
+	bob := model.UserInfo{Sub: "bob", Expiry: time.Now().Add(time.Minute).Unix()}
+	token, err := h.createToken(bob)
+	NoError(t, err)
+	other, err := h.createToken(bob)
+	NoError(t, err)
+	alice, err := h.createToken(model.UserInfo{Sub: "alice", Expiry: bob.Expiry})
+	NoError(t, err)
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, tokenRequest(h, "POST", "/context/login/revoke-all", token))
+	Equal(t, 200, recorder.Code)
+	for _, revoked := range []string{token, other} {
+		_, valid := h.GetToken(tokenRequest(h, "GET", "/context/login", revoked))
+		False(t, valid)
+	}
+	_, valid := h.GetToken(tokenRequest(h, "GET", "/context/login", alice))
+	True(t, valid)
+	// without a valid token, nothing can be revoked
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, tokenRequest(h, "POST", "/context/login/revoke-all", token))
+	Equal(t, 403, recorder.Code)
+}
+
+func TestHandler_LogoutRevokesToken(t *testing.T) {
+	h := testHandler()
+	token, err := h.createToken(model.UserInfo{Sub: "bob", Expiry: time.Now().Add(time.Minute).Unix()})
+	NoError(t, err)
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, tokenRequest(h, "DELETE", "/context/login", token))
+	Equal(t, 200, recorder.Code)
+	_, valid := h.GetToken(tokenRequest(h, "GET", "/context/login", token))
+	False(t, valid)
+}
+
+func TestHandler_LoginInTheSecondOfRevokeAll(t *testing.T) {
+	h := testHandler()
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+	oldToken := recorder.Body.String()
+	NoError(t, h.revokeAllTokens("bob"))
+	// a login right after the revocation, usually in the same second
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+	_, valid, _ := h.verifyToken(recorder.Body.String())
+	True(t, valid)
+	_, valid, revoked := h.verifyToken(oldToken)
+	False(t, valid)
+	True(t, revoked)
+}
+
+func TestHandler_revokedAllAt_Seconds(t *testing.T) {
+	h := testHandler()
+	// the format of older versions
+	NoError(t, h.store.Set(revokedSubPrefix+"bob", []byte("1700000000"), time.Now().Add(time.Minute)))
+	revokedAt, err := h.revokedAllAt("bob")
+	NoError(t, err)
+	Equal(t, time.Unix(1700000000, 0), revokedAt)
+}
+
+func TestHandler_isRevoked(t *testing.T) {
+	h := testHandler()
+	now := time.Now()
+	userInfo := model.UserInfo{Sub: "bob", ID: "id1", IssuedAt: now.Add(-time.Minute).Unix(), Expiry: now.Add(time.Minute).Unix()}
+	revoked, err := h.isRevoked(userInfo)
+	NoError(t, err)
+	False(t, revoked)
+	NoError(t, h.revokeAllTokens("bob"))
+	revoked, err = h.isRevoked(userInfo)
+	NoError(t, err)
+	True(t, revoked)
+	// issued after the revocation
+	userInfo.IssuedAt = now.Add(2 * time.Second).Unix()
+	revoked, err = h.isRevoked(userInfo)
+	NoError(t, err)
+	False(t, revoked)
+	// without a store, nothing is revoked
+	h.store = nil
+	revoked, err = h.isRevoked(model.UserInfo{Sub: "bob"})
+	NoError(t, err)
+	False(t, revoked)
+	Error(t, h.revokeToken(userInfo))
+}
@@ -0,0 +1,97 @@
+package login
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/pchchv/logsrv/logging"
+)
+
+const (
+	jwtPath = "/jwt"
+
+	serverSessionPrefix = "sid:"
+)
+
+// With server sessions, the cookie holds an opaque session id only and the jwt is kept in the store
+func (h *Handler) usesServerSessions() bool {
+	return h.config.ServerSessions && h.store != nil
+}
+
+// Stores the jwt until its expiry and returns the id of the new session
+func (h *Handler) createServerSession(token string, expiry int64) (string, error) {
+	id, err := randStringBytes(32)
+	if err != nil {
+		return "", err
+	}
+	return id, h.store.Set(serverSessionKey(id), []byte(token), time.Unix(expiry, 0))
+}
+
+// Returns the jwt of the session or an empty string for unknown and expired sessions
+func (h *Handler) serverSessionToken(id string) string {
+	b, exist, err := h.store.Get(serverSessionKey(id))
+	if err != nil {
+		logging.Logger.WithError(err).Error("error on reading the server session")
+		return ""
+	}
+	if !exist {
+		return ""
+	}
+	return string(b)
+}
+
+// Removes the session of the cookie of the request, e.g. on a logout or a new login
+func (h *Handler) deleteServerSession(r *http.Request) {
+	if !h.usesServerSessions() {
+		return
+	}
+	c, err := r.Cookie(h.config.CookieName)
+	if err != nil || c.Value == "" {
+		return
+	}
+	if err := h.store.Delete(serverSessionKey(c.Value)); err != nil {
+		logging.Application(r.Header).WithError(err).Warn("server session not deleted")
+	}
+}
+
+// The store has the hash of the session id only, so that the store content can not be used as cookie
+func serverSessionKey(id string) string {
+	digest := sha256.Sum256([]byte(id))
+	return serverSessionPrefix + hex.EncodeToString(digest[:])
+}
+
+// Returns the jwt of the session cookie for API calls, which need the token itself,
+// e.g. single page applications, which send it as bearer token to other services.
+func (h *Handler) handleJwtExchange(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		h.respondBadRequest(w, r)
+		return
+	}
+	tokenString, source := h.tokenFromRequest(r)
+	userInfo, valid, _ := h.verifyToken(tokenString)
+	if !valid || source != tokenSourceCookie || !h.usesServerSessions() {
+		h.respondAuthFailure(w, r)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	if wantJSON(r) {
+		expiresIn := userInfo.Expiry - time.Now().Unix()
+		if expiresIn < 0 {
+			expiresIn = 0
+		}
+		w.Header().Set("Content-Type", contentTypeJSON)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": tokenString,
+			"token_type":   "Bearer",
+			"expires_in":   expiresIn,
+		}) // ignore error of encoding
+		return
+	}
+	w.Header().Set("Content-Type", contentTypeJWT)
+	w.WriteHeader(200)
+	fmt.Fprint(w, tokenString)
+}
@@ -0,0 +1,123 @@
+package login
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pchchv/logsrv/model"
+	. "github.com/stretchr/testify/assert"
+)
+
+func serverSessionLogin(t *testing.T, h *Handler) string {
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptHTML))
+	Equal(t, 303, recorder.Code)
+	cookies := readSetCookies(recorder.Header())
+	Equal(t, 1, len(cookies))
+	return cookies[0].Value
+}
+
+func TestHandler_ServerSessions(t *testing.T) {
+	h := testHandler()
+	h.config.ServerSessions = true
+
+	id := serverSessionLogin(t, h)
+	// the cookie holds an opaque id instead of the jwt
+	_, err := tokenAsMap(id)
+	Error(t, err)
+	Equal(t, 64, len(id))
+	values, err := h.store.List(serverSessionPrefix)
+	NoError(t, err)
+	Equal(t, 1, len(values))
+	NotContains(t, values, serverSessionPrefix+id)
+
+	cookie := "Cookie: " + h.config.CookieName + "=" + id
+	userInfo, valid := h.GetToken(req("GET", "/context/login", "", cookie))
+	True(t, valid)
+	Equal(t, "bob", userInfo.Sub)
+
+	_, valid = h.GetToken(req("GET", "/context/login", "", "Cookie: "+h.config.CookieName+"=unknown"))
+	False(t, valid)
+
+	// the logout deletes the session
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("DELETE", "/context/login", "", cookie))
+	_, valid = h.GetToken(req("GET", "/context/login", "", cookie))
+	False(t, valid)
+	values, err = h.store.List(serverSessionPrefix)
+	NoError(t, err)
+	Equal(t, 0, len(values))
+}
+
+func TestHandler_ServerSessions_JwtCookieRejected(t *testing.T) {
+	h := testHandler()
+	token, err := h.createToken(model.UserInfo{Sub: "bob", Expiry: time.Now().Add(time.Minute).Unix()})
+	NoError(t, err)
+	h.config.ServerSessions = true
+
+	_, valid := h.GetToken(req("GET", "/context/login", "", "Cookie: "+h.config.CookieName+"="+token))
+	False(t, valid)
+	// bearer tokens are still accepted
+	_, valid = h.GetToken(req("GET", "/context/login", "", "Authorization: Bearer "+token))
+	True(t, valid)
+}
+
+func TestHandler_ServerSessions_Refresh(t *testing.T) {
+	h := testHandler()
+	h.config.ServerSessions = true
+	id := serverSessionLogin(t, h)
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "", AcceptHTML, "Cookie: "+h.config.CookieName+"="+id))
+	Equal(t, 303, recorder.Code)
+	cookies := readSetCookies(recorder.Header())
+	Equal(t, 1, len(cookies))
+	NotEqual(t, id, cookies[0].Value)
+
+	// the old session is replaced
+	values, err := h.store.List(serverSessionPrefix)
+	NoError(t, err)
+	Equal(t, 1, len(values))
+	_, valid := h.GetToken(req("GET", "/context/login", "", "Cookie: "+h.config.CookieName+"="+id))
+	False(t, valid)
+}
+
+func TestHandler_JwtExchange(t *testing.T) {
+	h := testHandler()
+	h.config.ServerSessions = true
+	id := serverSessionLogin(t, h)
+	cookie := "Cookie: " + h.config.CookieName + "=" + id
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/jwt", "", cookie))
+	Equal(t, 200, recorder.Code)
+	Equal(t, contentTypeJWT, recorder.Header().Get("Content-Type"))
+	claims, err := tokenAsMap(recorder.Body.String())
+	NoError(t, err)
+	Equal(t, "bob", claims["sub"])
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/jwt", "", cookie, "Accept: application/json"))
+	Equal(t, 200, recorder.Code)
+	response := map[string]interface{}{}
+	NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	Equal(t, "Bearer", response["token_type"])
+	claims, err = tokenAsMap(response["access_token"].(string))
+	NoError(t, err)
+	Equal(t, "bob", claims["sub"])
+	Greater(t, response["expires_in"], float64(0))
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/jwt", ""))
+	Equal(t, 403, recorder.Code)
+
+	// without server sessions, the cookie has the jwt already
+	h.config.ServerSessions = false
+	token, err := h.createToken(model.UserInfo{Sub: "bob", Expiry: time.Now().Add(time.Minute).Unix()})
+	NoError(t, err)
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/jwt", "", "Cookie: "+h.config.CookieName+"="+token))
+	Equal(t, 403, recorder.Code)
+}
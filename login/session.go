@@ -0,0 +1,100 @@
+package login
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/pchchv/logsrv/model"
+)
+
+const sessionPrefix = "session:"
+
+// An issued token, which is tracked in the store for the admin api
+type session struct {
+	ID       string `json:"id"`
+	Sub      string `json:"sub"`
+	Origin   string `json:"origin,omitempty"`
+	IssuedAt int64  `json:"iat"`
+	Expiry   int64  `json:"exp"`
+	// Family of the refresh tokens, which were issued together with the token
+	RefreshFamily string `json:"refresh_family,omitempty"`
+}
+
+// Sessions are only tracked for the admin api, because every token causes a write to the store
+func (h *Handler) tracksSessions() bool {
+	return h.config.AdminPath != "" && h.store != nil
+}
+
+// Stores the session of a new token until its expiry
+func (h *Handler) trackSession(userInfo model.UserInfo, refreshFamily string) error {
+	if !h.tracksSessions() {
+		return nil
+	}
+	b, err := json.Marshal(session{
+		ID:            userInfo.ID,
+		Sub:           userInfo.Sub,
+		Origin:        userInfo.Origin,
+		IssuedAt:      userInfo.IssuedAt,
+		Expiry:        userInfo.Expiry,
+		RefreshFamily: refreshFamily,
+	})
+	if err != nil {
+		return err
+	}
+	return h.store.Set(sessionPrefix+userInfo.ID, b, time.Unix(userInfo.Expiry, 0))
+}
+
+// Returns the active sessions of the user or of all users for an empty sub, the newest first
+func (h *Handler) sessions(sub string) ([]session, error) {
+	sessions := []session{}
+	if !h.tracksSessions() {
+		return sessions, nil
+	}
+	values, err := h.store.List(sessionPrefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range values {
+		s := session{}
+		if err := json.Unmarshal(b, &s); err != nil {
+			return nil, err
+		}
+		if sub == "" || s.Sub == sub {
+			sessions = append(sessions, s)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		if sessions[i].IssuedAt != sessions[j].IssuedAt {
+			return sessions[i].IssuedAt > sessions[j].IssuedAt
+		}
+		return sessions[i].ID < sessions[j].ID
+	})
+	return sessions, nil
+}
+
+// Returns the session of the token id, the bool is false for unknown or expired sessions
+func (h *Handler) session(id string) (session, bool, error) {
+	if !h.tracksSessions() {
+		return session{}, false, nil
+	}
+	b, exist, err := h.store.Get(sessionPrefix + id)
+	if err != nil || !exist {
+		return session{}, false, err
+	}
+	s := session{}
+	return s, true, json.Unmarshal(b, &s)
+}
+
+// Removes the sessions of revoked tokens
+func (h *Handler) forgetSessions(sessions ...session) error {
+	if !h.tracksSessions() {
+		return nil
+	}
+	for _, s := range sessions {
+		if err := h.store.Delete(sessionPrefix + s.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -51,3 +51,12 @@ func (sb *SimpleBackend) Authenticate(username, password string) (bool, model.Us
 	}
 	return false, model.UserInfo{}, nil
 }
+
+// Checks, that the user still exists
+func (sb *SimpleBackend) ValidateUser(userInfo model.UserInfo) (bool, error) {
+	if userInfo.Origin != SimpleProviderName {
+		return true, nil
+	}
+	_, exist := sb.userPassword[userInfo.Sub]
+	return exist, nil
+}
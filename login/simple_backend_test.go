@@ -3,6 +3,8 @@ package login
 import (
 	"testing"
 
+	"github.com/pchchv/logsrv/model"
+
 	. "github.com/stretchr/testify/assert"
 )
 
@@ -38,3 +40,21 @@ func TestSimpleBackend_Authenticate(t *testing.T) {
 	Equal(t, "", userInfo.Sub)
 	NoError(t, err)
 }
+
+func TestSimpleBackend_ValidateUser(t *testing.T) {
+	backend := NewSimpleBackend(map[string]string{
+		"bob": "secret",
+	})
+	for _, test := range []struct {
+		userInfo model.UserInfo
+		expected bool
+	}{
+		{model.UserInfo{Sub: "bob", Origin: SimpleProviderName}, true},
+		{model.UserInfo{Sub: "alice", Origin: SimpleProviderName}, false},
+		{model.UserInfo{Sub: "alice", Origin: "github"}, true},
+	} {
+		valid, err := backend.ValidateUser(test.userInfo)
+		NoError(t, err)
+		Equal(t, test.expected, valid, test.userInfo.Sub)
+	}
+}
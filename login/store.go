@@ -0,0 +1,199 @@
+package login
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server side storage for state, which has to outlive a single request, e.g. token revocations.
+// Entries expire, so that the store does not grow without bounds.
+type Store interface {
+	// Returns the value for the key, the bool is false for missing or expired entries
+	Get(key string) ([]byte, bool, error)
+	// Sets the value, which is removed after the expiry
+	Set(key string, value []byte, expiry time.Time) error
+	Delete(key string) error
+	// Returns the values of all entries with the key prefix, which are not expired
+	List(prefix string) (map[string][]byte, error)
+	// Releases the resources of the store, e.g. the lock of the file
+	Close() error
+}
+
+// Creates the store configured by the -store option
+func NewStore(config *Config) (Store, error) {
+	switch config.Store {
+	case "", "memory":
+		return NewMemoryStore(), nil
+	case "file":
+		if config.StoreFile == "" {
+			return nil, fmt.Errorf("missing -store-file for store %v", config.Store)
+		}
+		return NewFileStore(config.StoreFile)
+	case "bolt":
+		if config.StoreFile == "" {
+			return nil, fmt.Errorf("missing -store-file for store %v", config.Store)
+		}
+		return NewBoltStore(config.StoreFile)
+	}
+	return nil, fmt.Errorf("no such store: %v", config.Store)
+}
+
+type storeEntry struct {
+	Value  []byte    `json:"value"`
+	Expiry time.Time `json:"expiry"`
+}
+
+func (e storeEntry) expired(now time.Time) bool {
+	return !e.Expiry.After(now)
+}
+
+// Store, which holds the entries in memory only
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]storeEntry
+	// entries are cleaned up periodically on writes
+	nextCleanup time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: map[string]storeEntry{}}
+}
+
+func (s *MemoryStore) Get(key string) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, exist := s.entries[key]
+	if !exist || e.expired(time.Now()) {
+		return nil, false, nil
+	}
+	return e.Value, true, nil
+}
+
+func (s *MemoryStore) Set(key string, value []byte, expiry time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[key] = storeEntry{Value: value, Expiry: expiry}
+	s.cleanup()
+	return nil
+}
+
+func (s *MemoryStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+	return nil
+}
+
+func (s *MemoryStore) List(prefix string) (map[string][]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	values := map[string][]byte{}
+	for k, e := range s.entries {
+		if strings.HasPrefix(k, prefix) && !e.expired(now) {
+			values[k] = e.Value
+		}
+	}
+	return values, nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+func (s *MemoryStore) cleanup() {
+	now := time.Now()
+	if now.Before(s.nextCleanup) {
+		return
+	}
+	for k, e := range s.entries {
+		if e.expired(now) {
+			delete(s.entries, k)
+		}
+	}
+	s.nextCleanup = now.Add(time.Minute)
+}
+
+// Store, which keeps the entries in memory and writes them to a json file on every change,
+// so that they survive a restart.
+// Every change rewrites and syncs the whole file, so it suits small setups only, larger ones should use the BoltStore.
+type FileStore struct {
+	MemoryStore
+	file string
+}
+
+// Creates a file store and loads the existing entries from the file
+func NewFileStore(file string) (*FileStore, error) {
+	s := &FileStore{MemoryStore: *NewMemoryStore(), file: file}
+	b, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &s.entries); err != nil {
+		return nil, fmt.Errorf("error parsing store file %v: %v", file, err)
+	}
+	return s, nil
+}
+
+func (s *FileStore) Set(key string, value []byte, expiry time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[key] = storeEntry{Value: value, Expiry: expiry}
+	s.cleanup()
+	return s.save()
+}
+
+func (s *FileStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+	return s.save()
+}
+
+func (s *FileStore) save() error {
+	b, err := json.Marshal(s.entries)
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomically(s.file, b)
+}
+
+// Replaces the file by a temporary file in the same directory, so that readers never see a partial file.
+// The mode of an existing file is kept, a new file is only readable by the owner.
+func WriteFileAtomically(file string, content []byte) error {
+	mode := os.FileMode(0600)
+	if fileInfo, err := os.Stat(file); err == nil {
+		mode = fileInfo.Mode().Perm()
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(file), "."+filepath.Base(file)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
@@ -0,0 +1,133 @@
+package login
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var boltBucket = []byte("logsrv")
+
+// Store, which keeps the entries in an embedded bolt database.
+// Unlike the file store, a change writes the changed entry only, so that it scales to many sessions.
+type BoltStore struct {
+	db *bolt.DB
+	mu sync.Mutex
+	// expired entries are cleaned up periodically on writes
+	nextCleanup time.Time
+}
+
+// Opens or creates the bolt database. The file is locked, so it can not be shared between instances.
+func NewBoltStore(file string) (*BoltStore, error) {
+	db, err := bolt.Open(file, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("error opening bolt store %v: %v", file, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStore{db: db}, nil
+}
+
+func (s *BoltStore) Get(key string) ([]byte, bool, error) {
+	var value []byte
+	var exist bool
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBucket).Get([]byte(key))
+		if b == nil {
+			return nil
+		}
+		e := storeEntry{}
+		if err := json.Unmarshal(b, &e); err != nil {
+			return err
+		}
+		if !e.expired(time.Now()) {
+			value, exist = e.Value, true
+		}
+		return nil
+	})
+	return value, exist, err
+}
+
+func (s *BoltStore) Set(key string, value []byte, expiry time.Time) error {
+	b, err := json.Marshal(storeEntry{Value: value, Expiry: expiry})
+	if err != nil {
+		return err
+	}
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Put([]byte(key), b)
+	})
+	if err != nil {
+		return err
+	}
+	return s.cleanup()
+}
+
+func (s *BoltStore) Delete(key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Delete([]byte(key))
+	})
+}
+
+func (s *BoltStore) List(prefix string) (map[string][]byte, error) {
+	values := map[string][]byte{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		now := time.Now()
+		c := tx.Bucket(boltBucket).Cursor()
+		for k, b := c.Seek([]byte(prefix)); k != nil && bytes.HasPrefix(k, []byte(prefix)); k, b = c.Next() {
+			e := storeEntry{}
+			if err := json.Unmarshal(b, &e); err != nil {
+				return err
+			}
+			if !e.expired(now) {
+				values[string(k)] = e.Value
+			}
+		}
+		return nil
+	})
+	return values, err
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *BoltStore) cleanup() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if now.Before(s.nextCleanup) {
+		return nil
+	}
+	s.nextCleanup = now.Add(time.Minute)
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltBucket)
+		// the keys are collected first, because a delete moves the cursor
+		expired := [][]byte{}
+		err := bucket.ForEach(func(k, b []byte) error {
+			e := storeEntry{}
+			if err := json.Unmarshal(b, &e); err != nil || e.expired(now) {
+				expired = append(expired, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range expired {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
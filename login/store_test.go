@@ -0,0 +1,108 @@
+package login
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func testStore(t *testing.T, s Store) {
+	_, exist, err := s.Get("foo")
+	NoError(t, err)
+	False(t, exist)
+	NoError(t, s.Set("foo", []byte("bar"), time.Now().Add(time.Minute)))
+	v, exist, err := s.Get("foo")
+	NoError(t, err)
+	True(t, exist)
+	Equal(t, []byte("bar"), v)
+	NoError(t, s.Set("expired", []byte("bar"), time.Now().Add(-time.Second)))
+	_, exist, err = s.Get("expired")
+	NoError(t, err)
+	False(t, exist)
+	NoError(t, s.Set("list:a", []byte("1"), time.Now().Add(time.Minute)))
+	NoError(t, s.Set("list:b", []byte("2"), time.Now().Add(time.Minute)))
+	NoError(t, s.Set("list:expired", []byte("3"), time.Now().Add(-time.Second)))
+	values, err := s.List("list:")
+	NoError(t, err)
+	Equal(t, map[string][]byte{"list:a": []byte("1"), "list:b": []byte("2")}, values)
+	NoError(t, s.Delete("foo"))
+	_, exist, err = s.Get("foo")
+	NoError(t, err)
+	False(t, exist)
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+}
+
+func TestFileStore(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "store.json")
+	s, err := NewFileStore(file)
+	NoError(t, err)
+	testStore(t, s)
+	// the entries survive a restart
+	NoError(t, s.Set("persistent", []byte("value"), time.Now().Add(time.Minute)))
+	s, err = NewFileStore(file)
+	NoError(t, err)
+	v, exist, err := s.Get("persistent")
+	NoError(t, err)
+	True(t, exist)
+	Equal(t, []byte("value"), v)
+}
+
+func TestWriteFileAtomically(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "store.json")
+	NoError(t, WriteFileAtomically(file, []byte("{}")))
+	fileInfo, err := os.Stat(file)
+	NoError(t, err)
+	Equal(t, os.FileMode(0600), fileInfo.Mode().Perm())
+
+	// the mode of an existing file is kept
+	NoError(t, os.Chmod(file, 0640))
+	NoError(t, WriteFileAtomically(file, []byte(`{"foo":{}}`)))
+	fileInfo, err = os.Stat(file)
+	NoError(t, err)
+	Equal(t, os.FileMode(0640), fileInfo.Mode().Perm())
+	b, err := os.ReadFile(file)
+	NoError(t, err)
+	Equal(t, `{"foo":{}}`, string(b))
+}
+
+func TestBoltStore(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "store.db")
+	s, err := NewBoltStore(file)
+	NoError(t, err)
+	testStore(t, s)
+	// the entries survive a restart
+	NoError(t, s.Set("persistent", []byte("value"), time.Now().Add(time.Minute)))
+	NoError(t, s.Close())
+	s, err = NewBoltStore(file)
+	NoError(t, err)
+	defer s.Close()
+	v, exist, err := s.Get("persistent")
+	NoError(t, err)
+	True(t, exist)
+	Equal(t, []byte("value"), v)
+}
+
+func TestNewStore(t *testing.T) {
+	s, err := NewStore(&Config{})
+	NoError(t, err)
+	IsType(t, &MemoryStore{}, s)
+	s, err = NewStore(&Config{Store: "file", StoreFile: filepath.Join(t.TempDir(), "store.json")})
+	NoError(t, err)
+	IsType(t, &FileStore{}, s)
+	_, err = NewStore(&Config{Store: "file"})
+	Error(t, err)
+	s, err = NewStore(&Config{Store: "bolt", StoreFile: filepath.Join(t.TempDir(), "store.db")})
+	NoError(t, err)
+	IsType(t, &BoltStore{}, s)
+	NoError(t, s.(*BoltStore).Close())
+	_, err = NewStore(&Config{Store: "bolt"})
+	Error(t, err)
+	_, err = NewStore(&Config{Store: "redis"})
+	Error(t, err)
+}
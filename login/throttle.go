@@ -0,0 +1,160 @@
+package login
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Upper bound of the exponential lockout
+const maxLockout = 24 * time.Hour
+
+// Limits the login attempts per ip and per username with token buckets
+// and locks out users after repeated failed logins with an exponentially growing duration.
+type throttle struct {
+	mu       sync.Mutex
+	ipRate   int
+	userRate int
+	// the failures, after which a user is locked out and the duration of the first lockout
+	lockoutThreshold int
+	lockoutDuration  time.Duration
+	buckets          map[string]*tokenBucket
+	lockouts         map[string]*lockout
+	lastCleanup      time.Time
+	now              func() time.Time
+}
+
+// Token bucket, which holds up to rate tokens and is refilled with rate tokens per minute
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type lockout struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// Returns nil, if neither limits nor a lockout are configured
+func newThrottle(config *Config) *throttle {
+	if config.LoginRateIP <= 0 && config.LoginRateUser <= 0 && config.LockoutThreshold <= 0 {
+		return nil
+	}
+	return &throttle{
+		ipRate:           config.LoginRateIP,
+		userRate:         config.LoginRateUser,
+		lockoutThreshold: config.LockoutThreshold,
+		lockoutDuration:  config.LockoutDuration,
+		buckets:          map[string]*tokenBucket{},
+		lockouts:         map[string]*lockout{},
+		now:              time.Now,
+	}
+}
+
+func (t *throttle) sameLimits(other *throttle) bool {
+	if t == nil || other == nil {
+		return t == other
+	}
+	return t.ipRate == other.ipRate && t.userRate == other.userRate &&
+		t.lockoutThreshold == other.lockoutThreshold && t.lockoutDuration == other.lockoutDuration
+}
+
+// Takes a login attempt of the ip and the username.
+// It returns the duration to wait, if the attempt is not allowed.
+// An empty username only checks the ip.
+func (t *throttle) allow(ip, username string) time.Duration {
+	if t == nil {
+		return 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	t.cleanup(now)
+	if username != "" {
+		if l, exist := t.lockouts[username]; exist && now.Before(l.lockedUntil) {
+			return l.lockedUntil.Sub(now)
+		}
+	}
+	wait := t.take("ip:"+ip, t.ipRate, now)
+	if username != "" {
+		if userWait := t.take("user:"+username, t.userRate, now); userWait > wait {
+			wait = userWait
+		}
+	}
+	return wait
+}
+
+// Records a failed login and returns the duration of the lockout, which starts with this failure
+func (t *throttle) failed(username string) time.Duration {
+	if t == nil || t.lockoutThreshold <= 0 {
+		return 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	l, exist := t.lockouts[username]
+	if !exist {
+		l = &lockout{}
+		t.lockouts[username] = l
+	}
+	l.failures++
+	l.lastFailure = t.now()
+	if l.failures < t.lockoutThreshold {
+		return 0
+	}
+	duration := time.Duration(float64(t.lockoutDuration) * math.Pow(2, float64(l.failures-t.lockoutThreshold)))
+	if duration > maxLockout || duration <= 0 {
+		duration = maxLockout
+	}
+	l.lockedUntil = t.now().Add(duration)
+	return duration
+}
+
+// Resets the failures of the user after a successful login
+func (t *throttle) succeeded(username string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.lockouts, username)
+}
+
+func (t *throttle) take(key string, rate int, now time.Time) time.Duration {
+	if rate <= 0 {
+		return 0
+	}
+	b, exist := t.buckets[key]
+	if !exist {
+		b = &tokenBucket{tokens: float64(rate), last: now}
+		t.buckets[key] = b
+	}
+	perSecond := float64(rate) / 60
+	b.tokens = math.Min(float64(rate), b.tokens+now.Sub(b.last).Seconds()*perSecond)
+	b.last = now
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / perSecond * float64(time.Second))
+	}
+	b.tokens--
+	return 0
+}
+
+// Drops full buckets and expired lockouts, so that the maps do not grow without bounds
+func (t *throttle) cleanup(now time.Time) {
+	if now.Sub(t.lastCleanup) < time.Minute {
+		return
+	}
+	t.lastCleanup = now
+	for key, b := range t.buckets {
+		// a bucket is full again after one minute
+		if now.Sub(b.last) > time.Minute {
+			delete(t.buckets, key)
+		}
+	}
+	for username, l := range t.lockouts {
+		// failures are forgotten after a day without failures
+		if now.Sub(l.lastFailure) > maxLockout && now.After(l.lockedUntil) {
+			delete(t.lockouts, username)
+		}
+	}
+}
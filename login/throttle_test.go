@@ -0,0 +1,134 @@
+package login
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pchchv/logsrv/logging"
+	. "github.com/stretchr/testify/assert"
+)
+
+func testThrottle(config *Config) (*throttle, *time.Time) {
+	now := time.Unix(1000000, 0)
+	t := newThrottle(config)
+	t.now = func() time.Time { return now }
+	return t, &now
+}
+
+func Test_newThrottle_Disabled(t *testing.T) {
+	th := newThrottle(DefaultConfig())
+	Nil(t, th)
+	// a nil throttle allows everything
+	Equal(t, time.Duration(0), th.allow("127.0.0.1", "bob"))
+	Equal(t, time.Duration(0), th.failed("bob"))
+	th.succeeded("bob")
+}
+
+func Test_throttle_TokenBucket(t *testing.T) {
+	th, now := testThrottle(&Config{LoginRateIP: 3, LoginRateUser: 2})
+	Equal(t, time.Duration(0), th.allow("1.1.1.1", "bob"))
+	Equal(t, time.Duration(0), th.allow("1.1.1.1", "bob"))
+	// user limit reached, one token is refilled after 30s
+	Equal(t, 30*time.Second, th.allow("1.1.1.1", "bob"))
+	// ip limit reached, the user limit is independent
+	Equal(t, 20*time.Second, th.allow("1.1.1.1", "alice"))
+	Equal(t, time.Duration(0), th.allow("2.2.2.2", "alice"))
+	*now = now.Add(30 * time.Second)
+	Equal(t, time.Duration(0), th.allow("2.2.2.2", "bob"))
+	// the ip only check for the second factor
+	Equal(t, time.Duration(0), th.allow("2.2.2.2", ""))
+}
+
+func Test_throttle_Lockout(t *testing.T) {
+	th, now := testThrottle(&Config{LockoutThreshold: 3, LockoutDuration: time.Minute})
+	Equal(t, time.Duration(0), th.failed("bob"))
+	Equal(t, time.Duration(0), th.failed("bob"))
+	Equal(t, time.Minute, th.failed("bob"))
+	Equal(t, time.Minute, th.allow("1.1.1.1", "bob"))
+	Equal(t, time.Duration(0), th.allow("1.1.1.1", "alice"))
+	*now = now.Add(time.Minute)
+	Equal(t, time.Duration(0), th.allow("1.1.1.1", "bob"))
+	// every further failure doubles the lockout
+	Equal(t, 2*time.Minute, th.failed("bob"))
+	Equal(t, 4*time.Minute, th.failed("bob"))
+	for i := 0; i < 20; i++ {
+		th.failed("bob")
+	}
+	Equal(t, maxLockout, th.allow("1.1.1.1", "bob"))
+	// a successful login resets the failures
+	th.succeeded("bob")
+	Equal(t, time.Duration(0), th.allow("1.1.1.1", "bob"))
+	Equal(t, time.Duration(0), th.failed("bob"))
+}
+
+func Test_throttle_Cleanup(t *testing.T) {
+	th, now := testThrottle(&Config{LoginRateIP: 3, LockoutThreshold: 3, LockoutDuration: time.Minute})
+	th.allow("1.1.1.1", "bob")
+	th.failed("bob")
+	*now = now.Add(maxLockout + time.Minute)
+	th.allow("2.2.2.2", "")
+	Len(t, th.buckets, 1)
+	Len(t, th.lockouts, 0)
+}
+
+func TestHandler_Login_Throttled(t *testing.T) {
+	h := testHandler()
+	h.throttle = newThrottle(&Config{LockoutThreshold: 2, LockoutDuration: time.Minute})
+	// shows the form of the backends
+	h.config.Backends = Options{SimpleProviderName: {"bob": "secret"}}
+	for i := 0; i < 2; i++ {
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, req("POST", "/context/login", `{"username": "bob", "password": "wrong"}`, TypeJSON, AcceptJwt))
+		Equal(t, 403, recorder.Code)
+	}
+	// even the right password is rejected during the lockout
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", `{"username": "bob", "password": "secret"}`, TypeJSON, "Accept: application/json"))
+	Equal(t, 429, recorder.Code)
+	Equal(t, "60", recorder.Header().Get("Retry-After"))
+	Equal(t, contentTypeJSON, recorder.Header().Get("Content-Type"))
+	Equal(t, `{"error": "Too many login attempts", "retry_after": 60}`, recorder.Body.String())
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptHTML))
+	Equal(t, 429, recorder.Code)
+	NotEmpty(t, recorder.Header().Get("Retry-After"))
+	Contains(t, recorder.Body.String(), "Too many login attempts")
+	Contains(t, recorder.Body.String(), `value="bob"`)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptJwt))
+	Equal(t, 429, recorder.Code)
+	Equal(t, "Too many login attempts", recorder.Body.String())
+
+	// other users are not affected
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "username=alice&password=wrong", TypeForm, AcceptJwt))
+	Equal(t, 403, recorder.Code)
+}
+
+func TestHandler_Login_RateLimitPerIP(t *testing.T) {
+	h := testHandler()
+	h.throttle = newThrottle(&Config{LoginRateIP: 1})
+	login := func(username, remoteAddr string, headers ...string) int {
+		r := req("POST", "/context/login", "username="+username+"&password=secret", append([]string{TypeForm, AcceptJwt}, headers...)...)
+		r.RemoteAddr = remoteAddr
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, r)
+		return recorder.Code
+	}
+	Equal(t, 200, login("bob", "[2001:db8::1]:1234"))
+	Equal(t, 429, login("alice", "[2001:db8::1]:5678"))
+	// other ipv6 clients have their own limit
+	Equal(t, 200, login("bob", "[2001:db8::2]:1234"))
+	// the ip headers of clients are ignored
+	Equal(t, 429, login("alice", "[2001:db8::1]:1234", "X-Real-Ip: 10.0.0.3"))
+
+	// behind a trusted proxy, the ip is taken from the header
+	NoError(t, logging.SetTrustedProxies("192.0.2.0/24"))
+	defer logging.SetTrustedProxies("")
+	Equal(t, 200, login("bob", "192.0.2.10:1234", "X-Real-Ip: 10.0.0.1"))
+	Equal(t, 429, login("alice", "192.0.2.11:1234", "X-Real-Ip: 10.0.0.1"))
+	Equal(t, 200, login("bob", "192.0.2.10:1234", "X-Real-Ip: 10.0.0.2"))
+}
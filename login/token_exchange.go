@@ -0,0 +1,203 @@
+package login
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pchchv/logsrv/logging"
+	"github.com/pchchv/logsrv/model"
+)
+
+// Token exchange (RFC 8693) of a logsrv jwt for a short lived token of a downstream service
+const (
+	tokenExchangePath = "/token"
+
+	grantTypeTokenExchange = "urn:ietf:params:oauth:grant-type:token-exchange"
+	tokenTypeJwt           = "urn:ietf:params:oauth:token-type:jwt"
+	tokenTypeAccessToken   = "urn:ietf:params:oauth:token-type:access_token"
+)
+
+// Exchanges the subject token for a token with the requested audience, a subset of the claims,
+// a shorter expiry and an act claim for the actor, i.e. the service, which requests the token.
+// The actor is the client authenticated by the actor_token of its service account or by its secret.
+// An exchanged token can only be exchanged again by a client, which is configured for its audience.
+func (h *Handler) handleTokenExchange(w http.ResponseWriter, r *http.Request) {
+	audiences := splitList(h.config.TokenExchangeAudiences)
+	if len(audiences) == 0 {
+		h.respondNotFound(w, r)
+		return
+	}
+	if r.Method != "POST" {
+		h.respondBadRequest(w, r)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		respondTokenExchangeError(w, 400, "invalid_request", "invalid form")
+		return
+	}
+	if r.PostForm.Get("grant_type") != grantTypeTokenExchange {
+		respondTokenExchangeError(w, 400, "unsupported_grant_type", "grant_type has to be "+grantTypeTokenExchange)
+		return
+	}
+	if !validExchangeTokenType(r.PostForm.Get("subject_token_type")) {
+		respondTokenExchangeError(w, 400, "invalid_request", "unsupported subject_token_type")
+		return
+	}
+	if t := r.PostForm.Get("requested_token_type"); t != "" && t != tokenTypeJwt && t != tokenTypeAccessToken {
+		respondTokenExchangeError(w, 400, "invalid_request", "unsupported requested_token_type")
+		return
+	}
+	audience := r.PostForm.Get("audience")
+	if !contains(audiences, audience) {
+		respondTokenExchangeError(w, 400, "invalid_target", "audience is not allowed")
+		return
+	}
+	if r.PostForm.Get("actor_token") != "" && !validExchangeTokenType(r.PostForm.Get("actor_token_type")) {
+		respondTokenExchangeError(w, 400, "invalid_request", "unsupported actor_token_type")
+		return
+	}
+	actor, clientAudiences, authenticated := h.authenticateExchangeClient(r)
+	if !authenticated {
+		respondTokenExchangeError(w, 401, "invalid_client", "client authentication failed")
+		return
+	}
+	// tokens of previous exchanges can be exchanged again
+	subjectToken := r.PostForm.Get("subject_token")
+	subject, valid, _ := h.verifyTokenFor(subjectToken, audiences...)
+	if !valid {
+		respondTokenExchangeError(w, 400, "invalid_request", "invalid subject_token")
+		return
+	}
+	token, err := h.parseToken(subjectToken, jwt.MapClaims{})
+	if err != nil {
+		respondTokenExchangeError(w, 400, "invalid_request", "invalid subject_token")
+		return
+	}
+	subjectClaims := token.Claims.(jwt.MapClaims)
+	if _, exchanged := subjectClaims["act"]; exchanged && !containsAny(clientAudiences, subject.Audience) {
+		respondTokenExchangeError(w, 400, "invalid_grant", "subject_token was issued for another client")
+		return
+	}
+	claims, err := h.exchangeClaims(subjectClaims, subject, audience, actor)
+	if err != nil {
+		logging.Application(r.Header).WithError(err).Error()
+		h.respondError(w, r)
+		return
+	}
+	exchangedToken, err := h.signClaims(claims)
+	if err != nil {
+		logging.Application(r.Header).WithError(err).Error()
+		h.respondError(w, r)
+		return
+	}
+	logging.Application(r.Header).
+		WithField("username", subject.Sub).Infof("exchanged jwt for %v by %v", audience, actor)
+	logging.Audit(r, logging.AuditEvent{
+		Event: logging.AuditTokenExchange, Subject: subject.Sub, Origin: subject.Origin, Outcome: logging.AuditSuccess,
+		Reason: "audience " + audience + ", actor " + actor})
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token":      exchangedToken,
+		"issued_token_type": tokenTypeJwt,
+		"token_type":        "Bearer",
+		"expires_in":        claims["exp"].(int64) - time.Now().Unix(),
+	}) // ignore error of encoding
+}
+
+func validExchangeTokenType(tokenType string) bool {
+	return tokenType == tokenTypeJwt || tokenType == tokenTypeAccessToken
+}
+
+// Authenticates a configured client by the secret, given by basic authentication
+// or the parameters client_id and client_secret, or by a valid logsrv jwt in actor_token.
+// An actor token has to be the one of a client with an origin, i.e. the service account of the client
+// in a backend, so that users of other backends with the same name and other users are no clients.
+// Returns the actor and the audiences, which the client may exchange again.
+func (h *Handler) authenticateExchangeClient(r *http.Request) (actor string, audiences []string, authenticated bool) {
+	if actorToken := r.PostForm.Get("actor_token"); actorToken != "" {
+		// exchanged tokens are no valid actor tokens
+		actorInfo, valid, _ := h.verifyToken(actorToken)
+		if !valid {
+			return "", nil, false
+		}
+		client, exist := h.config.TokenExchangeClients[actorInfo.Sub]
+		if !exist || client["origin"] == "" || client["origin"] != actorInfo.Origin {
+			return "", nil, false
+		}
+		return actorInfo.Sub, exchangeClientAudiences(client), true
+	}
+	id, secret, basicAuth := r.BasicAuth()
+	if !basicAuth {
+		id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	client, exist := h.config.TokenExchangeClients[id]
+	if !exist || client["secret"] == "" || subtle.ConstantTimeCompare([]byte(secret), []byte(client["secret"])) != 1 {
+		return "", nil, false
+	}
+	return id, exchangeClientAudiences(client), true
+}
+
+// The audiences of a client are separated by ';', because ',' separates the options of the client
+func exchangeClientAudiences(client map[string]string) []string {
+	return splitList(strings.Replace(client["audiences"], ";", ",", -1))
+}
+
+func containsAny(list []string, values []string) bool {
+	for _, v := range values {
+		if contains(list, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the claims of the exchanged token: sub, the configured claims of the subject token,
+// the audience, the act claim, a new jti and an expiry, which is not after the one of the subject token.
+func (h *Handler) exchangeClaims(subjectClaims jwt.MapClaims, subject model.UserInfo, audience, actor string) (customClaims, error) {
+	claims := customClaims{}
+	for _, name := range splitList(h.config.TokenExchangeClaims) {
+		if v, exist := subjectClaims[name]; exist {
+			claims[name] = v
+		}
+	}
+	id, err := randStringBytes(16)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	expiry := now.Add(h.config.TokenExchangeExpiry).Unix()
+	if subject.Expiry < expiry {
+		expiry = subject.Expiry
+	}
+	act := map[string]interface{}{"sub": actor}
+	if previous, exist := subjectClaims["act"]; exist {
+		// the actors of a chain of exchanges are nested (RFC 8693, section 4.1)
+		act["act"] = previous
+	}
+	if h.config.JwtIssuer != "" {
+		claims["iss"] = h.config.JwtIssuer
+	}
+	claims["sub"] = subject.Sub
+	claims["aud"] = audience
+	claims["act"] = act
+	claims["jti"] = id
+	claims["iat"] = now.Unix()
+	claims["nbf"] = now.Unix()
+	claims["exp"] = expiry
+	return claims, nil
+}
+
+func respondTokenExchangeError(w http.ResponseWriter, status int, code, description string) {
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{
+		"error":             code,
+		"error_description": description,
+	}) // ignore error of encoding
+}
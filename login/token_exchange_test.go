@@ -0,0 +1,230 @@
+package login
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/pchchv/logsrv/model"
+	. "github.com/stretchr/testify/assert"
+)
+
+func tokenExchangeTestHandler(t *testing.T) (*Handler, string) {
+	h := testHandler()
+	h.config.TokenExchangeAudiences = "orders, billing"
+	h.config.TokenExchangeClients = Options{
+		"frontend": {"secret": "frontend-secret"},
+		"orders":   {"secret": "orders-secret", "audiences": "orders"},
+		"gateway":  {"origin": "htpasswd", "audiences": "orders;billing"},
+	}
+	token, err := h.createToken(model.UserInfo{
+		Sub: "bob", Email: "bob@example.com", Name: "Bob", Origin: "simple", Groups: []string{"users"},
+		Expiry: time.Now().Add(time.Hour).Unix()})
+	NoError(t, err)
+	return h, token
+}
+
+func exchangeToken(h *Handler, params url.Values, header ...string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/token", params.Encode(), append([]string{TypeForm}, header...)...))
+	response := map[string]interface{}{}
+	_ = json.Unmarshal(recorder.Body.Bytes(), &response)
+	return recorder, response
+}
+
+func exchangeParams(subjectToken string) url.Values {
+	return url.Values{
+		"grant_type":         {grantTypeTokenExchange},
+		"subject_token":      {subjectToken},
+		"subject_token_type": {tokenTypeJwt},
+		"audience":           {"orders"},
+		"client_id":          {"frontend"},
+		"client_secret":      {"frontend-secret"},
+	}
+}
+
+func TestHandler_TokenExchange(t *testing.T) {
+	h, subjectToken := tokenExchangeTestHandler(t)
+	h.config.TokenExchangeClaims = "email,groups"
+
+	recorder, response := exchangeToken(h, exchangeParams(subjectToken))
+	Equal(t, 200, recorder.Code)
+	Equal(t, "no-store", recorder.Header().Get("Cache-Control"))
+	Equal(t, tokenTypeJwt, response["issued_token_type"])
+	Equal(t, "Bearer", response["token_type"])
+	InDelta(t, (5 * time.Minute).Seconds(), response["expires_in"], 2)
+
+	claims, err := tokenAsMap(response["access_token"].(string))
+	NoError(t, err)
+	Equal(t, "bob", claims["sub"])
+	Equal(t, "orders", claims["aud"])
+	Equal(t, "bob@example.com", claims["email"])
+	Equal(t, []interface{}{"users"}, claims["groups"])
+	Equal(t, map[string]interface{}{"sub": "frontend"}, claims["act"])
+	NotContains(t, claims, "name")
+	NotContains(t, claims, "origin")
+	InDelta(t, time.Now().Add(5*time.Minute).Unix(), claims["exp"], 2)
+	NotEmpty(t, claims["jti"])
+
+	// the exchanged token is no login token for logsrv
+	_, valid := h.GetToken(req("GET", "/context/login", "", "Authorization: Bearer "+response["access_token"].(string)))
+	False(t, valid)
+
+	// a second exchange by the service of the audience nests the actors
+	ordersToken := response["access_token"].(string)
+	params := exchangeParams(ordersToken)
+	params.Set("audience", "billing")
+	params.Set("client_id", "orders")
+	params.Set("client_secret", "orders-secret")
+	recorder, response = exchangeToken(h, params)
+	Equal(t, 200, recorder.Code)
+	claims, err = tokenAsMap(response["access_token"].(string))
+	NoError(t, err)
+	Equal(t, map[string]interface{}{"sub": "orders", "act": map[string]interface{}{"sub": "frontend"}}, claims["act"])
+
+	// other clients can not exchange the token of the audience
+	params = exchangeParams(ordersToken)
+	params.Set("audience", "billing")
+	recorder, response = exchangeToken(h, params)
+	Equal(t, 400, recorder.Code)
+	Equal(t, "invalid_grant", response["error"])
+}
+
+func TestHandler_TokenExchange_BasicAuth(t *testing.T) {
+	h, subjectToken := tokenExchangeTestHandler(t)
+	params := exchangeParams(subjectToken)
+	params.Del("client_id")
+	params.Del("client_secret")
+
+	recorder, response := exchangeToken(h, params, "Authorization: Basic "+base64.StdEncoding.EncodeToString([]byte("frontend:frontend-secret")))
+	Equal(t, 200, recorder.Code)
+	claims, err := tokenAsMap(response["access_token"].(string))
+	NoError(t, err)
+	Equal(t, map[string]interface{}{"sub": "frontend"}, claims["act"])
+
+	recorder, response = exchangeToken(h, params, "Authorization: Basic "+base64.StdEncoding.EncodeToString([]byte("frontend:wrong")))
+	Equal(t, 401, recorder.Code)
+	Equal(t, "invalid_client", response["error"])
+}
+
+func TestHandler_TokenExchange_ActorToken(t *testing.T) {
+	h, subjectToken := tokenExchangeTestHandler(t)
+	actorToken, err := h.createToken(model.UserInfo{Sub: "gateway", Origin: "htpasswd", Expiry: time.Now().Add(time.Hour).Unix()})
+	NoError(t, err)
+
+	params := exchangeParams(subjectToken)
+	params.Del("client_id")
+	params.Del("client_secret")
+	params.Set("actor_token", actorToken)
+	params.Set("actor_token_type", tokenTypeAccessToken)
+	recorder, response := exchangeToken(h, params)
+	Equal(t, 200, recorder.Code)
+	exchangedToken := response["access_token"].(string)
+	claims, err := tokenAsMap(exchangedToken)
+	NoError(t, err)
+	Equal(t, map[string]interface{}{"sub": "gateway"}, claims["act"])
+
+	// the actor is configured for the audience, so it may exchange the token again
+	params.Set("subject_token", exchangedToken)
+	params.Set("audience", "billing")
+	recorder, _ = exchangeToken(h, params)
+	Equal(t, 200, recorder.Code)
+
+	// exchanged tokens are no actor tokens
+	params.Set("subject_token", subjectToken)
+	params.Set("actor_token", exchangedToken)
+	recorder, response = exchangeToken(h, params)
+	Equal(t, 401, recorder.Code)
+	Equal(t, "invalid_client", response["error"])
+
+	params.Set("actor_token", "invalid")
+	recorder, response = exchangeToken(h, params)
+	Equal(t, 401, recorder.Code)
+	Equal(t, "invalid_client", response["error"])
+
+	params.Set("actor_token", actorToken)
+	params.Set("actor_token_type", "urn:ietf:params:oauth:token-type:saml2")
+	recorder, response = exchangeToken(h, params)
+	Equal(t, 400, recorder.Code)
+	Equal(t, "invalid_request", response["error"])
+}
+
+func TestHandler_TokenExchange_ActorTokenOfNoClient(t *testing.T) {
+	h, subjectToken := tokenExchangeTestHandler(t)
+	params := exchangeParams(subjectToken)
+	params.Del("client_id")
+	params.Del("client_secret")
+	params.Set("actor_token_type", tokenTypeJwt)
+	for name, actor := range map[string]model.UserInfo{
+		"user with the name of a client of another origin": {Sub: "gateway", Origin: "github"},
+		"client without origin":                            {Sub: "frontend", Origin: "htpasswd"},
+		"user without client":                              {Sub: "bob", Origin: "simple"},
+	} {
+		actor.Expiry = time.Now().Add(time.Hour).Unix()
+		actorToken, err := h.createToken(actor)
+		NoError(t, err)
+		params.Set("actor_token", actorToken)
+		recorder, response := exchangeToken(h, params)
+		Equal(t, 401, recorder.Code, name)
+		Equal(t, "invalid_client", response["error"], name)
+	}
+}
+
+func TestHandler_TokenExchange_ShorterThanSubject(t *testing.T) {
+	h, _ := tokenExchangeTestHandler(t)
+	subjectToken, err := h.createToken(model.UserInfo{Sub: "bob", Expiry: time.Now().Add(time.Minute).Unix()})
+	NoError(t, err)
+
+	recorder, response := exchangeToken(h, exchangeParams(subjectToken))
+	Equal(t, 200, recorder.Code)
+	claims, err := tokenAsMap(response["access_token"].(string))
+	NoError(t, err)
+	InDelta(t, time.Now().Add(time.Minute).Unix(), claims["exp"], 2)
+}
+
+func TestHandler_TokenExchange_Errors(t *testing.T) {
+	h, subjectToken := tokenExchangeTestHandler(t)
+	revokedToken, err := h.createToken(model.UserInfo{Sub: "alice", Expiry: time.Now().Add(time.Hour).Unix()})
+	NoError(t, err)
+	revoked, _, _ := h.verifyToken(revokedToken)
+	NoError(t, h.revokeToken(revoked))
+
+	testCases := []struct {
+		name   string
+		param  string
+		value  string
+		status int
+		error  string
+	}{
+		{"grant type", "grant_type", "password", 400, "unsupported_grant_type"},
+		{"subject token type", "subject_token_type", "urn:ietf:params:oauth:token-type:saml2", 400, "invalid_request"},
+		{"audience", "audience", "unknown", 400, "invalid_target"},
+		{"invalid subject", "subject_token", "invalid", 400, "invalid_request"},
+		{"revoked subject", "subject_token", revokedToken, 400, "invalid_request"},
+		{"no client", "client_id", "", 401, "invalid_client"},
+		{"unknown client", "client_id", "unknown", 401, "invalid_client"},
+		{"wrong secret", "client_secret", "wrong", 401, "invalid_client"},
+		{"client without secret", "client_id", "gateway", 401, "invalid_client"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			params := exchangeParams(subjectToken)
+			params.Set(tc.param, tc.value)
+			recorder, response := exchangeToken(h, params)
+			Equal(t, tc.status, recorder.Code)
+			Equal(t, tc.error, response["error"])
+		})
+	}
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/token", ""))
+	Equal(t, 400, recorder.Code)
+
+	// disabled without audiences
+	h.config.TokenExchangeAudiences = ""
+	recorder, _ = exchangeToken(h, exchangeParams(subjectToken))
+	Equal(t, 404, recorder.Code)
+}
@@ -0,0 +1,65 @@
+package login
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Sources of the jwt in a request, which are searched in the order of the config option token-lookup
+const (
+	tokenSourceCookie = "cookie"
+	tokenSourceHeader = "header"
+)
+
+const bearerPrefix = "bearer "
+
+// Parses the order of the token sources, e.g. cookie,header
+// Without an order, the cookie is searched first.
+func tokenLookup(order string) ([]string, error) {
+	sources := splitList(order)
+	if len(sources) == 0 {
+		return []string{tokenSourceCookie, tokenSourceHeader}, nil
+	}
+	for _, s := range sources {
+		if s != tokenSourceCookie && s != tokenSourceHeader {
+			return nil, fmt.Errorf("unknown source %q in token-lookup, has to be %v or %v", s, tokenSourceCookie, tokenSourceHeader)
+		}
+	}
+	return sources, nil
+}
+
+// Returns the jwt of the first source of the token lookup, which contains one,
+// and the name of this source. The source is empty, if the request has no jwt.
+func (h *Handler) tokenFromRequest(r *http.Request) (tokenString, source string) {
+	// the order is checked on the creation of the handler
+	sources, _ := tokenLookup(h.config.TokenLookup)
+	for _, source := range sources {
+		switch source {
+		case tokenSourceCookie:
+			if c, err := r.Cookie(h.config.CookieName); err == nil && c.Value != "" {
+				if !h.usesServerSessions() {
+					return c.Value, source
+				}
+				// the cookie holds the id of a server session
+				if token := h.serverSessionToken(c.Value); token != "" {
+					return token, source
+				}
+			}
+		case tokenSourceHeader:
+			if token := BearerToken(r); token != "" {
+				return token, source
+			}
+		}
+	}
+	return "", ""
+}
+
+// Returns the token of an 'Authorization: Bearer <token>' header or an empty string
+func BearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if len(auth) <= len(bearerPrefix) || !strings.EqualFold(auth[:len(bearerPrefix)], bearerPrefix) {
+		return ""
+	}
+	return strings.TrimSpace(auth[len(bearerPrefix):])
+}
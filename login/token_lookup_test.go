@@ -0,0 +1,110 @@
+package login
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pchchv/logsrv/model"
+	. "github.com/stretchr/testify/assert"
+)
+
+func Test_tokenLookup(t *testing.T) {
+	sources, err := tokenLookup("header, cookie")
+	NoError(t, err)
+	Equal(t, []string{tokenSourceHeader, tokenSourceCookie}, sources)
+	sources, err = tokenLookup("")
+	NoError(t, err)
+	Equal(t, []string{tokenSourceCookie, tokenSourceHeader}, sources)
+	_, err = tokenLookup("cookie,query")
+	Error(t, err)
+	_, err = NewHandler(&Config{Backends: Options{"simple": {"bob": "secret"}}, TokenLookup: "query"})
+	Error(t, err)
+}
+
+func Test_BearerToken(t *testing.T) {
+	tests := []struct {
+		header   string
+		expected string
+	}{
+		{"Bearer abc.def.ghi", "abc.def.ghi"},
+		{"bearer abc.def.ghi ", "abc.def.ghi"},
+		{"Basic Ym9iOnNlY3JldA==", ""},
+		{"Bearer ", ""},
+		{"", ""},
+	}
+	for _, test := range tests {
+		r := &http.Request{Header: http.Header{"Authorization": {test.header}}}
+		Equal(t, test.expected, BearerToken(r), test.header)
+	}
+}
+
+func TestHandler_GetToken_Lookup(t *testing.T) {
+	h := testHandler()
+	cookieToken, err := h.createToken(model.UserInfo{Sub: "bob", Expiry: time.Now().Add(time.Minute).Unix()})
+	NoError(t, err)
+	headerToken, err := h.createToken(model.UserInfo{Sub: "alice", Expiry: time.Now().Add(time.Minute).Unix()})
+	NoError(t, err)
+	both := &http.Request{Header: http.Header{
+		"Cookie":        {h.config.CookieName + "=" + cookieToken},
+		"Authorization": {"Bearer " + headerToken},
+	}}
+	headerOnly := &http.Request{Header: http.Header{"Authorization": {"Bearer " + headerToken}}}
+	tests := []struct {
+		lookup   string
+		r        *http.Request
+		expected string
+	}{
+		{"cookie,header", both, "bob"},
+		{"header,cookie", both, "alice"},
+		{"cookie,header", headerOnly, "alice"},
+		{"header", both, "alice"},
+		{"cookie", headerOnly, ""},
+	}
+	for _, test := range tests {
+		h.config.TokenLookup = test.lookup
+		userInfo, valid := h.GetToken(test.r)
+		Equal(t, test.expected != "", valid, test.lookup)
+		Equal(t, test.expected, userInfo.Sub, test.lookup)
+	}
+}
+
+func TestHandler_BearerToken(t *testing.T) {
+	h := testHandler()
+	token, err := h.createToken(model.UserInfo{Sub: "bob", Origin: "simple", Expiry: time.Now().Add(time.Minute).Unix()})
+	NoError(t, err)
+	bearer := "Authorization: Bearer " + token
+	// user info
+	recorder := call(req("GET", "/context/login", "", "Accept: application/json", bearer))
+	Equal(t, 200, recorder.Code)
+	userInfo := model.UserInfo{}
+	NoError(t, json.Unmarshal(recorder.Body.Bytes(), &userInfo))
+	Equal(t, "bob", userInfo.Sub)
+	// refresh
+	recorder = call(req("POST", "/context/login", "", AcceptJwt, bearer))
+	Equal(t, 200, recorder.Code)
+	claims, err := tokenAsMap(recorder.Body.String())
+	NoError(t, err)
+	Equal(t, "bob", claims["sub"])
+	Equal(t, float64(1), claims["refs"])
+	// invalid tokens are not accepted
+	recorder = call(req("GET", "/context/login", "", "Accept: application/json", "Authorization: Bearer foo"))
+	Equal(t, 403, recorder.Code)
+}
+
+func TestHandler_Revoke_BearerToken(t *testing.T) {
+	h := testHandler()
+	token, err := h.createToken(model.UserInfo{Sub: "bob", Expiry: time.Now().Add(time.Minute).Unix()})
+	NoError(t, err)
+	bearer := "Authorization: Bearer " + token
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/revoke", "", TypeForm, bearer))
+	Equal(t, 200, recorder.Code)
+	// the cookie is kept, because the token was not taken from it
+	Equal(t, 0, len(readSetCookies(recorder.Header())))
+	True(t, h.HasRevokedToken(req("GET", "/context/login", "", bearer)))
+	_, valid := h.GetToken(req("GET", "/context/login", "", bearer))
+	False(t, valid)
+}
@@ -0,0 +1,117 @@
+package login
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pchchv/logsrv/model"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	totpPeriod = 30
+	totpDigits = 6
+	// Accepted time steps before and after the current one, to allow for clock drift
+	totpSkew = 1
+
+	amrPassword = "pwd"
+	amrOTP      = "otp"
+)
+
+// Holds the TOTP secrets of the users.
+// The secrets are taken from the totp_secret of the user file entries
+// and from the TOTP file, which maps usernames to secrets.
+type totpSecrets struct {
+	userFile *userClaimsFile
+	secrets  map[string]string
+}
+
+func newTOTPSecrets(config *Config) (*totpSecrets, error) {
+	s := &totpSecrets{
+		secrets: map[string]string{},
+	}
+	// the user endpoint replaces the user file for the claims, but the secrets are still used
+	userFile, err := newUserClaimsFile(config.UserFile)
+	if err != nil {
+		return nil, err
+	}
+	s.userFile = userFile
+	if config.TOTPFile != "" {
+		b, err := os.ReadFile(config.TOTPFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't read totp file %v", config.TOTPFile)
+		}
+		if err := yaml.Unmarshal(b, &s.secrets); err != nil {
+			return nil, errors.Wrapf(err, "can't parse totp file %v", config.TOTPFile)
+		}
+	}
+	for _, entry := range userFile.entries() {
+		if _, err := decodeTOTPSecret(entry.TOTPSecret); entry.TOTPSecret != "" && err != nil {
+			return nil, errors.Wrapf(err, "invalid totp secret in user file for %v", entry.Sub)
+		}
+	}
+	for username, secret := range s.secrets {
+		if _, err := decodeTOTPSecret(secret); err != nil {
+			return nil, errors.Wrapf(err, "invalid totp secret in totp file for %v", username)
+		}
+	}
+	return s, nil
+}
+
+// Returns the secret of the user or an empty string, if the user has no second factor
+func (s *totpSecrets) secret(userInfo model.UserInfo) string {
+	if s == nil {
+		return ""
+	}
+	if secret, exist := s.secrets[userInfo.Sub]; exist {
+		return secret
+	}
+	for _, entry := range s.userFile.entries() {
+		if entry.TOTPSecret != "" && match(userInfo, entry) {
+			return entry.TOTPSecret
+		}
+	}
+	return ""
+}
+
+// Decodes a base32 secret, as shown by the authenticator apps, e.g. with spaces and without padding
+func decodeTOTPSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.Replace(secret, " ", "", -1))
+	return base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.TrimRight(secret, "="))
+}
+
+// Checks the code against the secret and returns the matching time step.
+// The time step is needed to reject a replay of the code.
+func validateTOTP(secret, code string, now time.Time) (int64, bool) {
+	key, err := decodeTOTPSecret(secret)
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if subtle.ConstantTimeCompare([]byte(hotp(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// Computes the HOTP value (RFC 4226) of the counter, which is the time step for TOTP (RFC 6238)
+func hotp(key []byte, counter int64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0xf
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
@@ -0,0 +1,102 @@
+package login
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/pchchv/logsrv/model"
+	. "github.com/stretchr/testify/assert"
+)
+
+// base32 of the RFC 6238 test secret "12345678901234567890"
+const testTOTPSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func Test_validateTOTP_RFC6238(t *testing.T) {
+	// the RFC lists 8 digit codes, the 6 digit codes are the last digits
+	for unix, code := range map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1111111111: "050471",
+		1234567890: "005924",
+		2000000000: "279037",
+	} {
+		step, valid := validateTOTP(testTOTPSecret, code, time.Unix(unix, 0))
+		True(t, valid, unix)
+		Equal(t, unix/totpPeriod, step)
+	}
+}
+
+func Test_validateTOTP_Skew(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+	_, valid := validateTOTP(testTOTPSecret, "081804", now.Add(totpPeriod*time.Second))
+	True(t, valid)
+	_, valid = validateTOTP(testTOTPSecret, "081804", now.Add(-totpPeriod*time.Second))
+	True(t, valid)
+	_, valid = validateTOTP(testTOTPSecret, "081804", now.Add(3*totpPeriod*time.Second))
+	False(t, valid)
+}
+
+func Test_validateTOTP_Invalid(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+	for _, test := range [][]string{
+		{testTOTPSecret, "081805"},
+		{testTOTPSecret, "81804"},
+		{testTOTPSecret, ""},
+		{"", "081804"},
+		{"not base32!", "081804"},
+	} {
+		_, valid := validateTOTP(test[0], test[1], now)
+		False(t, valid, test)
+	}
+	// secrets are accepted as shown by the authenticator apps
+	_, valid := validateTOTP("gezd gnbv gy3t qojq gezd gnbv gy3t qojq", "081804", now)
+	True(t, valid)
+}
+
+func Test_newTOTPSecrets(t *testing.T) {
+	userFile := writeTempFile(`
+- sub: bob
+  origin: simple
+  totp_secret: ` + testTOTPSecret + `
+- sub: alice
+  claims:
+    role: admin
+`)
+	defer os.Remove(userFile)
+	totpFile := writeTempFile("marvin: JBSWY3DPEHPK3PXP\n")
+	defer os.Remove(totpFile)
+	s, err := newTOTPSecrets(&Config{UserFile: userFile, TOTPFile: totpFile})
+	NoError(t, err)
+	Equal(t, testTOTPSecret, s.secret(model.UserInfo{Sub: "bob", Origin: "simple"}))
+	Equal(t, "", s.secret(model.UserInfo{Sub: "bob", Origin: "htpasswd"}))
+	Equal(t, "", s.secret(model.UserInfo{Sub: "alice", Origin: "simple"}))
+	Equal(t, "JBSWY3DPEHPK3PXP", s.secret(model.UserInfo{Sub: "marvin", Origin: "htpasswd"}))
+	var none *totpSecrets
+	Equal(t, "", none.secret(model.UserInfo{Sub: "bob"}))
+}
+
+func Test_newTOTPSecrets_Errors(t *testing.T) {
+	_, err := newTOTPSecrets(&Config{TOTPFile: "notfound"})
+	Error(t, err)
+	invalidYAML := writeTempFile("- bob")
+	defer os.Remove(invalidYAML)
+	_, err = newTOTPSecrets(&Config{TOTPFile: invalidYAML})
+	Error(t, err)
+	invalidSecret := writeTempFile("bob: not-base32")
+	defer os.Remove(invalidSecret)
+	_, err = newTOTPSecrets(&Config{TOTPFile: invalidSecret})
+	Error(t, err)
+}
+
+func writeTempFile(content string) string {
+	f, err := os.CreateTemp("", "")
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(content); err != nil {
+		panic(err)
+	}
+	return f.Name()
+}
@@ -1,6 +1,7 @@
 package login
 
 import (
+	"encoding/json"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
@@ -8,23 +9,52 @@ import (
 	"github.com/pkg/errors"
 )
 
+// Returned by the claims providers for users, which must not get a token
+var errUserRejected = errors.New("user rejected")
+
 type customClaims map[string]interface{}
 
 type UserClaims interface {
 	Claims(userInfo model.UserInfo) (jwt.Claims, error)
 }
 
+// Checks exp and nbf, which are int64 for issued tokens, but float64 or json.Number for decoded ones
 func (custom customClaims) Valid() error {
-	if exp, ok := custom["exp"]; ok {
-		if exp, ok := exp.(int64); ok {
-			if exp < time.Now().Unix() {
-				return errors.New("token expired")
-			}
+	now := time.Now().Unix()
+	if v, exist := custom["exp"]; exist {
+		exp, ok := numericDate(v)
+		if !ok || exp < now {
+			return errors.New("token expired")
+		}
+	}
+	if v, exist := custom["nbf"]; exist {
+		nbf, ok := numericDate(v)
+		if !ok || nbf > now {
+			return errors.New("token not valid yet")
 		}
 	}
 	return nil
 }
 
+func numericDate(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return n, true
+	case int:
+		return int64(n), true
+	case float64:
+		return int64(n), true
+	case json.Number:
+		i, err := n.Int64()
+		if err != nil {
+			f, err := n.Float64()
+			return int64(f), err == nil
+		}
+		return i, true
+	}
+	return 0, false
+}
+
 func (custom customClaims) merge(values map[string]interface{}) {
 	for k, v := range values {
 		custom[k] = v
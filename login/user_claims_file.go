@@ -2,6 +2,7 @@ package login
 
 import (
 	"os"
+	"sync"
 
 	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/pchchv/logsrv/model"
@@ -10,17 +11,24 @@ import (
 )
 
 type userFileEntry struct {
-	Sub    string                 `yaml:"sub"`
-	Origin string                 `yaml:"origin"`
-	Email  string                 `yaml:"email"`
-	Domain string                 `yaml:"domain"`
-	Groups []string               `yaml:"groups"`
-	Claims map[string]interface{} `yaml:"claims"`
+	Sub    string                 `yaml:"sub,omitempty" json:"sub,omitempty"`
+	Origin string                 `yaml:"origin,omitempty" json:"origin,omitempty"`
+	Email  string                 `yaml:"email,omitempty" json:"email,omitempty"`
+	Domain string                 `yaml:"domain,omitempty" json:"domain,omitempty"`
+	Groups []string               `yaml:"groups,omitempty" json:"groups,omitempty"`
+	Claims map[string]interface{} `yaml:"claims,omitempty" json:"claims,omitempty"`
+	// Base32 encoded TOTP secret, which enables the second factor for matching users
+	TOTPSecret string `yaml:"totp_secret,omitempty" json:"totp_secret,omitempty"`
 }
 
+// Returned for entries, which are rejected by the user file
+var errInvalidUserFileEntry = errors.New("invalid user file entry")
+
 type userClaimsFile struct {
 	userFile        string
 	userFileEntries []userFileEntry
+	// guards the entries against changes by the admin api
+	mu sync.RWMutex
 }
 
 func newUserClaimsFile(file string) (*userClaimsFile, error) {
@@ -47,9 +55,42 @@ func (c *userClaimsFile) parseUserFile() error {
 	return nil
 }
 
+// Returns the current entries, which must not be modified
+func (c *userClaimsFile) entries() []userFileEntry {
+	if c == nil {
+		return nil
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.userFileEntries
+}
+
+// Replaces the entries and writes them atomically to the user file
+func (c *userClaimsFile) setEntries(entries []userFileEntry) error {
+	if c.userFile == "" {
+		return errors.New("no user file configured")
+	}
+	for _, entry := range entries {
+		if _, err := decodeTOTPSecret(entry.TOTPSecret); entry.TOTPSecret != "" && err != nil {
+			return errors.Wrapf(errInvalidUserFileEntry, "invalid totp secret for %v", entry.Sub)
+		}
+	}
+	b, err := yaml.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := WriteFileAtomically(c.userFile, b); err != nil {
+		return errors.Wrapf(err, "can't write user file %v", c.userFile)
+	}
+	c.userFileEntries = entries
+	return nil
+}
+
 // Returns a map of the token claims for a user
 func (c *userClaimsFile) Claims(userInfo model.UserInfo) (jwt.Claims, error) {
-	for _, entry := range c.userFileEntries {
+	for _, entry := range c.entries() {
 		if match(userInfo, entry) {
 			claims := customClaims(userInfo.AsMap())
 			claims.merge(entry.Claims)
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/pchchv/logsrv/metrics"
 	"github.com/pchchv/logsrv/model"
 	"github.com/pkg/errors"
 )
@@ -30,6 +31,16 @@ func newUserClaimsProvider(url, auth string, timeout time.Duration) (*userClaims
 }
 
 func (provider *userClaimsProvider) Claims(userInfo model.UserInfo) (jwt.Claims, error) {
+	metrics.UserEndpointCalls.Inc()
+	claims, err := provider.claims(userInfo)
+	if err != nil && err != errUserRejected {
+		// a rejection of the user is an answer of the endpoint and no error
+		metrics.UserEndpointErrors.Inc()
+	}
+	return claims, err
+}
+
+func (provider *userClaimsProvider) claims(userInfo model.UserInfo) (jwt.Claims, error) {
 	claimsURL := provider.buildURL(userInfo)
 	req, _ := http.NewRequest(http.MethodGet, claimsURL, nil)
 	if provider.auth != "" {
@@ -49,6 +60,9 @@ func (provider *userClaimsProvider) Claims(userInfo model.UserInfo) (jwt.Claims,
 	if resp.StatusCode == http.StatusNotFound {
 		return customClaims(userInfo.AsMap()), nil
 	}
+	if resp.StatusCode == http.StatusForbidden {
+		return nil, errUserRejected
+	}
 	if resp.StatusCode != http.StatusOK {
 		return nil, errors.Errorf("bad http response code %d", resp.StatusCode)
 	}
@@ -94,3 +108,20 @@ func validateURL(s string) error {
 	_, err := url.Parse(s)
 	return errors.Wrap(err, "invalid claims provider url")
 }
+
+// Checks, that the endpoint answers without a server error
+func (provider *userClaimsProvider) CheckHealth() error {
+	req, _ := http.NewRequest(http.MethodGet, provider.url, nil)
+	if provider.auth != "" {
+		req.Header.Add("Authorization", "Bearer "+provider.auth)
+	}
+	resp, err := provider.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return errors.Errorf("bad http response code %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -7,7 +7,9 @@ import (
 	"testing"
 	"time"
 
+	"github.com/pchchv/logsrv/metrics"
 	"github.com/pchchv/logsrv/model"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -111,8 +113,11 @@ func Test_userClaimsProvider_Claims_NotFound(t *testing.T) {
 func Test_userClaimsProvider_Claims_EndpointNotReachable(t *testing.T) {
 	provider, err := newUserClaimsProvider("http://not-exists.example.com", token, time.Millisecond)
 	require.NoError(t, err)
+	calls, errors := testutil.ToFloat64(metrics.UserEndpointCalls), testutil.ToFloat64(metrics.UserEndpointErrors)
 	_, err = provider.Claims(aUserInfo)
 	assert.Error(t, err)
+	assert.Equal(t, calls+1, testutil.ToFloat64(metrics.UserEndpointCalls))
+	assert.Equal(t, errors+1, testutil.ToFloat64(metrics.UserEndpointErrors))
 }
 
 func Test_userClaimsProvider_Claims_Errors(t *testing.T) {
@@ -176,3 +181,18 @@ func assertQueryValue(t *testing.T, name, expectedValue string, u *url.URL) {
 	require.NoError(t, err)
 	assert.Equal(t, expectedValue, value)
 }
+
+func Test_userClaimsProvider_CheckHealth(t *testing.T) {
+	mock := createMockServer(
+		mockResponse{url: endpointPath, status: http.StatusNotFound},
+		mockResponse{url: "/failing", status: http.StatusInternalServerError},
+	)
+	defer mock.Close()
+	provider, err := newUserClaimsProvider(mock.URL+endpointPath, token, time.Minute)
+	require.NoError(t, err)
+	assert.NoError(t, provider.CheckHealth())
+	assert.Equal(t, "Bearer "+token, mock.requests[0].Header.Get("Authorization"))
+	provider, err = newUserClaimsProvider(mock.URL+"/failing", token, time.Minute)
+	require.NoError(t, err)
+	assert.Error(t, provider.CheckHealth())
+}
@@ -0,0 +1,25 @@
+package login
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func Test_customClaims_Valid(t *testing.T) {
+	future := time.Now().Add(time.Minute).Unix()
+	past := time.Now().Add(-time.Minute).Unix()
+
+	NoError(t, customClaims{"sub": "bob"}.Valid())
+	NoError(t, customClaims{"exp": future}.Valid())
+	Error(t, customClaims{"exp": past}.Valid())
+	// decoded claims have float64 or json.Number values
+	NoError(t, customClaims{"exp": float64(future)}.Valid())
+	Error(t, customClaims{"exp": float64(past)}.Valid())
+	Error(t, customClaims{"exp": json.Number("1")}.Valid())
+	Error(t, customClaims{"exp": "tomorrow"}.Valid())
+	Error(t, customClaims{"exp": future, "nbf": float64(future)}.Valid())
+	NoError(t, customClaims{"exp": future, "nbf": past}.Valid())
+}
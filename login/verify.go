@@ -0,0 +1,152 @@
+package login
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pchchv/logsrv/logging"
+	"github.com/pchchv/logsrv/model"
+)
+
+const verifyPath = "/verify"
+
+// Requirements on the user of a token, where each list is satisfied by any of its values.
+// Empty lists have no requirement.
+type AccessRequirements struct {
+	Groups  []string
+	Domains []string
+	Origins []string
+}
+
+// Reads the requirements from the comma separated query parameters groups, domain and origin
+func requirementsFromQuery(query url.Values) AccessRequirements {
+	return AccessRequirements{
+		Groups:  splitList(query.Get("groups")),
+		Domains: splitList(query.Get("domain")),
+		Origins: splitList(query.Get("origin")),
+	}
+}
+
+// Reads the requirements from options in the form groups=a,b domain=example.com origin=github
+func ParseAccessRequirements(opts []string) (AccessRequirements, error) {
+	req := AccessRequirements{}
+	for _, opt := range opts {
+		pair := strings.SplitN(opt, "=", 2)
+		if len(pair) != 2 || len(splitList(pair[1])) == 0 {
+			return AccessRequirements{}, fmt.Errorf("requirement has to be in the form key=value1,value2, but was %v", opt)
+		}
+		switch pair[0] {
+		case "groups":
+			req.Groups = append(req.Groups, splitList(pair[1])...)
+		case "domain":
+			req.Domains = append(req.Domains, splitList(pair[1])...)
+		case "origin":
+			req.Origins = append(req.Origins, splitList(pair[1])...)
+		default:
+			return AccessRequirements{}, fmt.Errorf("unknown requirement %v, has to be groups, domain or origin", pair[0])
+		}
+	}
+	return req, nil
+}
+
+// Allows checks the user of a token against the requirements
+func (req AccessRequirements) Allows(userInfo model.UserInfo) bool {
+	if len(req.Domains) > 0 && !contains(req.Domains, userInfo.Domain) {
+		return false
+	}
+	if len(req.Origins) > 0 && !contains(req.Origins, userInfo.Origin) {
+		return false
+	}
+	if len(req.Groups) > 0 {
+		for _, group := range userInfo.Groups {
+			if contains(req.Groups, group) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// Answers subrequests of reverse proxies, e.g. nginx auth_request, Traefik ForwardAuth or Envoy ext_authz,
+// whether the original request is authenticated.
+// Authenticated requests get the user as X-Auth-* headers, other requests get 401
+// with a link to the login form in X-Auth-Redirect, which leads back to the original URL.
+func (h *Handler) handleVerify(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	userInfo, valid := h.GetToken(r)
+	if !valid {
+		w.Header().Set("X-Auth-Redirect", h.loginURLFor(originalURL(r)))
+		w.Header().Set("Content-Type", contentTypePlain)
+		w.WriteHeader(401)
+		fmt.Fprint(w, "Unauthorized")
+		return
+	}
+	if !requirementsFromQuery(r.URL.Query()).Allows(userInfo) {
+		logging.Application(r.Header).
+			WithField("username", userInfo.Sub).Info("access denied by verify requirements")
+		w.Header().Set("Content-Type", contentTypePlain)
+		w.WriteHeader(403)
+		fmt.Fprint(w, "Forbidden")
+		return
+	}
+	w.Header().Set("X-Auth-User", userInfo.Sub)
+	if userInfo.Email != "" {
+		w.Header().Set("X-Auth-Email", userInfo.Email)
+	}
+	if len(userInfo.Groups) > 0 {
+		w.Header().Set("X-Auth-Groups", strings.Join(userInfo.Groups, ","))
+	}
+	w.WriteHeader(200)
+}
+
+// Returns the url of the login form, which redirects to the target after the login
+func (h *Handler) loginURLFor(target string) string {
+	if target == "" {
+		return h.config.LoginPath
+	}
+	return h.config.LoginPath + "?" + url.Values{h.config.RedirectQueryParameter: {target}}.Encode()
+}
+
+// Reconstructs the url of the request, which the proxy verifies.
+// The proxies pass it in different headers:
+// X-Original-URL (nginx ingress), X-Forwarded-Proto/-Host/-Uri (Traefik) or X-Original-URI (nginx).
+func originalURL(r *http.Request) string {
+	if u := r.Header.Get("X-Original-URL"); u != "" {
+		return u
+	}
+	uri := r.Header.Get("X-Forwarded-Uri")
+	if uri == "" {
+		uri = r.Header.Get("X-Original-URI")
+	}
+	host := r.Header.Get("X-Forwarded-Host")
+	if host == "" {
+		return uri
+	}
+	proto := r.Header.Get("X-Forwarded-Proto")
+	if proto == "" {
+		proto = "https"
+	}
+	return proto + "://" + host + uri
+}
+
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
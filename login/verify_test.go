@@ -0,0 +1,135 @@
+package login
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/pchchv/logsrv/model"
+	. "github.com/stretchr/testify/assert"
+)
+
+func verifyRequest(h *Handler, query string, userInfo *model.UserInfo, header ...string) *httptest.ResponseRecorder {
+	r := req("GET", "/context/login/verify"+query, "", header...)
+	if userInfo != nil {
+		userInfo.Expiry = time.Now().Add(time.Minute).Unix()
+		token, err := h.createToken(*userInfo)
+		if err != nil {
+			panic(err)
+		}
+		r.Header.Set("Cookie", h.config.CookieName+"="+token)
+	}
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, r)
+	return recorder
+}
+
+func TestHandler_Verify(t *testing.T) {
+	h := testHandler()
+	recorder := verifyRequest(h, "", &model.UserInfo{
+		Sub:    "bob",
+		Email:  "bob@example.org",
+		Groups: []string{"admins", "developers"},
+	})
+	Equal(t, 200, recorder.Code)
+	Equal(t, "bob", recorder.Header().Get("X-Auth-User"))
+	Equal(t, "bob@example.org", recorder.Header().Get("X-Auth-Email"))
+	Equal(t, "admins,developers", recorder.Header().Get("X-Auth-Groups"))
+	Empty(t, recorder.Header().Get("Set-Cookie"))
+
+	recorder = verifyRequest(h, "", &model.UserInfo{Sub: "alice"})
+	Equal(t, 200, recorder.Code)
+	Equal(t, "alice", recorder.Header().Get("X-Auth-User"))
+	_, exist := recorder.Header()["X-Auth-Email"]
+	False(t, exist)
+}
+
+func TestHandler_Verify_Unauthenticated(t *testing.T) {
+	h := testHandler()
+	for header, expected := range map[string]string{
+		"X-Original-URI: /app?x=1":                     "/app?x=1",
+		"X-Original-URL: https://app.example.org/?x=1": "https://app.example.org/?x=1",
+		"": "",
+	} {
+		headers := []string{}
+		if header != "" {
+			headers = append(headers, header)
+		}
+		recorder := verifyRequest(h, "", nil, headers...)
+		Equal(t, 401, recorder.Code)
+		redirect := recorder.Header().Get("X-Auth-Redirect")
+		if expected == "" {
+			Equal(t, "/context/login", redirect)
+			continue
+		}
+		u, err := url.Parse(redirect)
+		NoError(t, err)
+		Equal(t, "/context/login", u.Path)
+		Equal(t, expected, u.Query().Get(h.config.RedirectQueryParameter))
+	}
+}
+
+func Test_originalURL(t *testing.T) {
+	r := req("GET", "/login/verify", "",
+		"X-Forwarded-Proto: http",
+		"X-Forwarded-Host: app.example.org",
+		"X-Forwarded-Uri: /path?x=1")
+	Equal(t, "http://app.example.org/path?x=1", originalURL(r))
+	r = req("GET", "/login/verify", "",
+		"X-Forwarded-Host: app.example.org",
+		"X-Original-URI: /path")
+	Equal(t, "https://app.example.org/path", originalURL(r))
+}
+
+func TestHandler_Verify_Requirements(t *testing.T) {
+	h := testHandler()
+	bob := model.UserInfo{Sub: "bob", Domain: "example.org", Origin: "github", Groups: []string{"developers"}}
+	for query, expected := range map[string]int{
+		"?groups=admins,developers":             200,
+		"?groups=admins":                        403,
+		"?domain=example.org":                   200,
+		"?domain=other.org,example.org":         200,
+		"?domain=other.org":                     403,
+		"?groups=developers&domain=other.org":   403,
+		"?groups=developers&domain=example.org": 200,
+		"?groups=":                              200,
+		"?origin=github,google":                 200,
+		"?origin=simple":                        403,
+	} {
+		userInfo := bob
+		recorder := verifyRequest(h, query, &userInfo)
+		Equal(t, expected, recorder.Code, query)
+	}
+}
+
+func Test_ParseAccessRequirements(t *testing.T) {
+	req, err := ParseAccessRequirements([]string{"groups=admins,ops", "domain=example.org", "origin=github", "groups=dev"})
+	NoError(t, err)
+	Equal(t, AccessRequirements{
+		Groups:  []string{"admins", "ops", "dev"},
+		Domains: []string{"example.org"},
+		Origins: []string{"github"},
+	}, req)
+	req, err = ParseAccessRequirements(nil)
+	NoError(t, err)
+	True(t, req.Allows(model.UserInfo{Sub: "bob"}))
+	for _, opt := range []string{"groups", "groups=", "role=admin"} {
+		_, err := ParseAccessRequirements([]string{opt})
+		Error(t, err, opt)
+	}
+}
+
+func TestHandler_Verify_RevokedToken(t *testing.T) {
+	h := testHandler()
+	userInfo := model.UserInfo{Sub: "bob", Expiry: time.Now().Add(time.Minute).Unix()}
+	token, err := h.createToken(userInfo)
+	NoError(t, err)
+	revoked, _, _ := h.verifyToken(token)
+	NoError(t, h.revokeToken(revoked))
+	r := req("GET", "/context/login/verify", "")
+	r.Header.Set("Cookie", h.config.CookieName+"="+token)
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, r)
+	Equal(t, 401, recorder.Code)
+}
@@ -6,12 +6,15 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"sync/atomic"
 	"syscall"
 
 	_ "github.com/pchchv/logsrv/htpasswd"
 	_ "github.com/pchchv/logsrv/httpupstream"
+	_ "github.com/pchchv/logsrv/ldap"
 	"github.com/pchchv/logsrv/logging"
 	"github.com/pchchv/logsrv/login"
+	"github.com/pchchv/logsrv/metrics"
 	_ "github.com/pchchv/logsrv/osiam"
 )
 
@@ -22,17 +25,38 @@ func main() {
 	if err := logging.Set(config.LogLevel, config.TextLogging); err != nil {
 		exit(nil, err)
 	}
+	if err := logging.SetTrustedProxies(config.TrustedProxies); err != nil {
+		exit(nil, err)
+	}
+	if config.AuditLog != "" {
+		auditLog, err := logging.OpenAuditLog(config.AuditLog, config.AuditLogMaxSize, config.AuditLogMaxBackups)
+		if err != nil {
+			exit(nil, err)
+		}
+		if err := logging.SetAuditLog(auditLog); err != nil {
+			exit(nil, err)
+		}
+	}
 	logging.AccessLogCookiesBlacklist = append(logging.AccessLogCookiesBlacklist, config.CookieName)
-	configToLog := *config
-	configToLog.JwtSecret = "..."
-	logging.LifecycleStart(appName, configToLog)
+	logging.LifecycleStart(appName, config.Redacted())
 	h, err := login.NewHandler(config)
 	if err != nil {
 		exit(nil, err)
 	}
-	handlerChain := logging.NewLogMiddleware(h)
+	current := &reloadableHandler{cookieName: config.CookieName}
+	current.handler.Store(h)
+	handlerChain := logging.NewLogMiddleware(withMetrics(current, config.MetricsPath))
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := current.reload(os.Args[1:]); err != nil {
+				logging.Logger.WithError(err).Error("config not reloaded, keeping the current config")
+			}
+		}
+	}()
 	port := config.Port
 	if port != "" {
 		port = fmt.Sprintf(":%s", port)
@@ -54,6 +78,56 @@ func main() {
 		panic(err)
 	}
 	ctxCancel()
+	if err := current.handler.Load().(*login.Handler).Close(); err != nil {
+		logging.Logger.WithError(err).Error("error closing the store")
+	}
+	_ = logging.SetAuditLog(nil) // flushes and closes the audit log, errors can not be reported anymore
+}
+
+// Serves the requests by the current login handler, which is replaced atomically on a reload.
+// Requests in flight are finished by the handler, which they started with.
+type reloadableHandler struct {
+	handler atomic.Value
+	// the cookie is hidden in the access log, which can not be changed at runtime
+	cookieName string
+}
+
+func (rh *reloadableHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rh.handler.Load().(*login.Handler).ServeHTTP(w, r)
+}
+
+// Reads the config again and replaces the handler.
+// Host, port, grace period, logging, the trusted proxies, the audit log, the cookie name and the metrics path can not be changed by a reload.
+func (rh *reloadableHandler) reload(args []string) error {
+	config, err := login.ReadConfigFromArgs(args)
+	if err != nil {
+		return err
+	}
+	if config.CookieName != rh.cookieName {
+		return fmt.Errorf("the cookie name can not be changed by a reload")
+	}
+	h, err := rh.handler.Load().(*login.Handler).Reload(config)
+	if err != nil {
+		return err
+	}
+	rh.handler.Store(h)
+	logging.Logger.Info("reloaded config")
+	return nil
+}
+
+// Serves the prometheus metrics on the path, if set, and all other requests by the next handler
+func withMetrics(next http.Handler, path string) http.Handler {
+	if path == "" {
+		return next
+	}
+	metricsHandler := metrics.Handler()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == path {
+			metricsHandler.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
 }
 
 var exit = func(signal os.Signal, err error) {
@@ -3,12 +3,14 @@ package main
 import (
 	"io"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
+	"github.com/pchchv/logsrv/login"
 	. "github.com/stretchr/testify/assert"
 )
 
@@ -40,3 +42,61 @@ func Test_BasicEndToEnd(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func Test_reloadableHandler_Reload(t *testing.T) {
+	file, err := os.CreateTemp("", "*.yml")
+	NoError(t, err)
+	defer os.Remove(file.Name())
+	writeFile := func(content string) {
+		NoError(t, os.WriteFile(file.Name(), []byte(content), 0600))
+	}
+	writeFile("jwt:\n  secret: theSecret\nbackends:\n  simple:\n    bob: secret\n")
+	args := []string{"-config", file.Name()}
+	config, err := login.ReadConfigFromArgs(args)
+	NoError(t, err)
+	h, err := login.NewHandler(config)
+	NoError(t, err)
+	current := &reloadableHandler{cookieName: config.CookieName}
+	current.handler.Store(h)
+	Equal(t, 200, loginStatus(current, "bob", "secret"))
+	Equal(t, 403, loginStatus(current, "alice", "secret"))
+
+	writeFile("jwt:\n  secret: theSecret\nbackends:\n  simple:\n    alice: secret\n")
+	NoError(t, current.reload(args))
+	Equal(t, 403, loginStatus(current, "bob", "secret"))
+	Equal(t, 200, loginStatus(current, "alice", "secret"))
+
+	// an invalid config keeps the current handler
+	writeFile("backends: []\n")
+	Error(t, current.reload(args))
+	Equal(t, 200, loginStatus(current, "alice", "secret"))
+	writeFile("cookie:\n  name: other\nbackends:\n  simple:\n    bob: secret\n")
+	Error(t, current.reload(args))
+	Equal(t, 200, loginStatus(current, "alice", "secret"))
+}
+
+func loginStatus(h http.Handler, username, password string) int {
+	r := httptest.NewRequest("POST", "/login", strings.NewReader("username="+username+"&password="+password))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, r)
+	return recorder.Code
+}
+
+func Test_withMetrics(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(204)
+	})
+	h := withMetrics(next, "/metrics")
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	Equal(t, 200, recorder.Code)
+	Contains(t, recorder.Body.String(), "logsrv_max_refreshes_reached_total")
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, httptest.NewRequest("GET", "/login", nil))
+	Equal(t, 204, recorder.Code)
+	// disabled
+	recorder = httptest.NewRecorder()
+	withMetrics(next, "").ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	Equal(t, 204, recorder.Code)
+}
@@ -0,0 +1,119 @@
+// Package metrics provides the prometheus metrics of logsrv
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "logsrv"
+
+// Outcomes of a login
+const (
+	OutcomeSuccess   = "success"
+	OutcomeFailure   = "failure"
+	OutcomeError     = "error"
+	OutcomeThrottled = "throttled"
+)
+
+// Types of token refreshes
+const (
+	RefreshJwt   = "jwt"
+	RefreshToken = "refresh_token"
+)
+
+var (
+	// Registry holds the metrics of logsrv, separated from the default registry of embedding applications, e.g. caddy
+	Registry = prometheus.NewRegistry()
+
+	// Logins counts the logins by the backend or oauth provider and the outcome.
+	// Failed password logins are counted with the provider "password", because all backends are tried.
+	Logins = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "logins_total",
+		Help:      "Logins by backend or oauth provider and outcome",
+	}, []string{"provider", "outcome"})
+
+	// AuthenticateDuration observes the latency of Backend.Authenticate per backend
+	AuthenticateDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "backend_authenticate_duration_seconds",
+		Help:      "Latency of the authentication against a backend",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"backend"})
+
+	// OauthTokenExchangeFailures counts the failed exchanges of an authorization code by provider
+	OauthTokenExchangeFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "oauth_token_exchange_failures_total",
+		Help:      "Failed token exchanges of the oauth flow by provider",
+	}, []string{"provider"})
+
+	// TokenRefreshes counts the issued tokens by refresh, where type is jwt for a refresh of the jwt cookie
+	// and refresh_token for an exchange of a refresh token
+	TokenRefreshes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "token_refreshes_total",
+		Help:      "Refreshed tokens by type of the refresh",
+	}, []string{"type"})
+
+	// MaxRefreshesReached counts the refreshes, which are rejected by the maximum of refreshes
+	MaxRefreshesReached = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "max_refreshes_reached_total",
+		Help:      "Refreshes rejected by reaching the maximum of jwt refreshes",
+	})
+
+	// UserEndpointCalls counts the calls of the user claims endpoint
+	UserEndpointCalls = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "user_endpoint_calls_total",
+		Help:      "Calls of the user claims endpoint",
+	})
+
+	// UserEndpointErrors counts the failed calls of the user claims endpoint
+	UserEndpointErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "user_endpoint_errors_total",
+		Help:      "Failed calls of the user claims endpoint",
+	})
+
+	// HTTPRequests counts the http requests by method and status code
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by method and status code",
+	}, []string{"method", "code"})
+
+	// HTTPRequestDuration observes the latency of the http requests by method
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of the HTTP requests by method",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		Logins,
+		AuthenticateDuration,
+		OauthTokenExchangeFailures,
+		TokenRefreshes,
+		MaxRefreshesReached,
+		UserEndpointCalls,
+		UserEndpointErrors,
+		HTTPRequests,
+		HTTPRequestDuration,
+	)
+}
+
+// Handler serves the metrics in the prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,18 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestHandler(t *testing.T) {
+	Logins.WithLabelValues("simple", OutcomeSuccess).Inc()
+	recorder := httptest.NewRecorder()
+	Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	Equal(t, 200, recorder.Code)
+	Contains(t, recorder.Body.String(), `logsrv_logins_total{outcome="success",provider="simple"}`)
+	Contains(t, recorder.Body.String(), "logsrv_max_refreshes_reached_total 0")
+	Contains(t, recorder.Body.String(), "go_goroutines")
+}
@@ -1,6 +1,7 @@
 package model
 
 import (
+	"encoding/json"
 	"errors"
 	"time"
 )
@@ -17,14 +18,56 @@ type UserInfo struct {
 	Refreshes int      `json:"refs,omitempty"`
 	Domain    string   `json:"domain,omitempty"`
 	Groups    []string `json:"groups,omitempty"`
+	ID        string   `json:"jti,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	// Authentication methods of the login (RFC 8176), e.g. pwd and otp
+	Amr       []string `json:"amr,omitempty"`
+	Issuer    string   `json:"iss,omitempty"`
+	Audience  Audience `json:"aud,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
 }
 
-// Checks the expiration of the token
+// The aud claim, which is a single string or a list of strings (RFC 7519, section 4.1.3)
+type Audience []string
+
+func (a Audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+func (a *Audience) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+// Checks the expiration and the not before time of the token
 // Allows user information to be used as a Claim for jwt-go
 func (u UserInfo) Valid() error {
-	if u.Expiry < time.Now().Unix() {
+	return u.ValidAt(time.Now(), 0)
+}
+
+// Checks the times of the token with a leeway for the clock skew between servers
+func (u UserInfo) ValidAt(now time.Time, leeway time.Duration) error {
+	if u.Expiry < now.Add(-leeway).Unix() {
 		return errors.New("token expired")
 	}
+	if u.NotBefore > now.Add(leeway).Unix() {
+		return errors.New("token not valid yet")
+	}
+	if u.IssuedAt > now.Add(leeway).Unix() {
+		return errors.New("token issued in the future")
+	}
 	return nil
 }
 
@@ -56,5 +99,23 @@ func (u UserInfo) AsMap() map[string]interface{} {
 	if len(u.Groups) > 0 {
 		m["groups"] = u.Groups
 	}
+	if u.ID != "" {
+		m["jti"] = u.ID
+	}
+	if u.IssuedAt != 0 {
+		m["iat"] = u.IssuedAt
+	}
+	if len(u.Amr) > 0 {
+		m["amr"] = u.Amr
+	}
+	if u.Issuer != "" {
+		m["iss"] = u.Issuer
+	}
+	if len(u.Audience) > 0 {
+		m["aud"] = u.Audience
+	}
+	if u.NotBefore != 0 {
+		m["nbf"] = u.NotBefore
+	}
 	return m
 }
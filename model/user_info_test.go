@@ -12,6 +12,31 @@ func Test_UserInfo_Valid(t *testing.T) {
 	Error(t, UserInfo{Expiry: 0}.Valid())
 	Error(t, UserInfo{Expiry: time.Now().Add(-1 * time.Second).Unix()}.Valid())
 	NoError(t, UserInfo{Expiry: time.Now().Add(time.Second).Unix()}.Valid())
+	Error(t, UserInfo{Expiry: time.Now().Add(time.Minute).Unix(), NotBefore: time.Now().Add(time.Minute).Unix()}.Valid())
+	Error(t, UserInfo{Expiry: time.Now().Add(time.Minute).Unix(), IssuedAt: time.Now().Add(time.Minute).Unix()}.Valid())
+}
+
+func Test_UserInfo_ValidAt_Leeway(t *testing.T) {
+	now := time.Now()
+	expired := UserInfo{Expiry: now.Add(-10 * time.Second).Unix()}
+	Error(t, expired.ValidAt(now, 5*time.Second))
+	NoError(t, expired.ValidAt(now, 30*time.Second))
+	early := UserInfo{Expiry: now.Add(time.Minute).Unix(), NotBefore: now.Add(10 * time.Second).Unix(), IssuedAt: now.Add(10 * time.Second).Unix()}
+	Error(t, early.ValidAt(now, 5*time.Second))
+	NoError(t, early.ValidAt(now, 30*time.Second))
+}
+
+func Test_UserInfo_Audience(t *testing.T) {
+	u := UserInfo{}
+	NoError(t, json.Unmarshal([]byte(`{"aud": "logsrv"}`), &u))
+	Equal(t, Audience{"logsrv"}, u.Audience)
+	NoError(t, json.Unmarshal([]byte(`{"aud": ["logsrv", "api"]}`), &u))
+	Equal(t, Audience{"logsrv", "api"}, u.Audience)
+	Error(t, json.Unmarshal([]byte(`{"aud": 42}`), &u))
+
+	b, err := json.Marshal(UserInfo{Audience: Audience{"logsrv"}})
+	NoError(t, err)
+	Equal(t, `{"sub":"","aud":"logsrv"}`, string(b))
 }
 
 func Test_UserInfo_AsMap(t *testing.T) {
@@ -25,6 +50,12 @@ func Test_UserInfo_AsMap(t *testing.T) {
 		Refreshes: 42,
 		Domain:    `json:"domain,omitempty"`,
 		Groups:    []string{`json:"groups,omitempty"`},
+		ID:        `json:"jti,omitempty"`,
+		IssuedAt:  1234,
+		Amr:       []string{"pwd", "otp"},
+		Issuer:    `json:"iss,omitempty"`,
+		Audience:  Audience{"logsrv", "api"},
+		NotBefore: 1234,
 	}
 	givenJson, _ := json.Marshal(u.AsMap())
 	given := UserInfo{}
@@ -0,0 +1,220 @@
+package oauth2
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/pchchv/logsrv/model"
+)
+
+var (
+	azureLoginURL = "https://login.microsoftonline.com"
+	azureGraphAPI = "https://graph.microsoft.com/v1.0"
+)
+
+const (
+	azureDefaultScopes = "openid profile email User.Read"
+	azureGroupsScope   = "GroupMember.Read.All"
+	// upper bound of the group pages, so that a misbehaving server can not keep the login busy
+	azureMaxGroupPages = 100
+	// the tenant of the personal Microsoft accounts
+	azureConsumersTenantID = "9188040d-6c67-4c5b-b112-36a304b66dad"
+)
+
+var (
+	// Tenants are guids, verified domains or one of common, organizations and consumers
+	azureTenantPattern = regexp.MustCompile(`^[a-zA-Z0-9.-]+$`)
+	// The tid claim of the id token is always a guid
+	azureTenantIDPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+)
+
+func init() {
+	RegisterProvider(providerAzure)
+}
+
+// Microsoft Entra ID (Azure AD) provider.
+// The endpoints depend on the tenant of the configured instance.
+var providerAzure = Provider{
+	Name:          "azure",
+	DefaultScopes: azureDefaultScopes,
+	UseNonce:      true,
+	SupportsPKCE:  true,
+	Configure:     configureAzure,
+	GetUserInfo: func(token TokenInfo) (model.UserInfo, string, error) {
+		return model.UserInfo{}, "", errors.New("azure provider is not configured")
+	},
+}
+
+// Used for parsing the profile of the Graph API
+type AzureUser struct {
+	ID                string `json:"id"`
+	UserPrincipalName string `json:"userPrincipalName"`
+	DisplayName       string `json:"displayName"`
+	Mail              string `json:"mail"`
+}
+
+// Used for parsing a page of the group memberships of the Graph API
+type AzureGroupPage struct {
+	Value []struct {
+		ID          string `json:"id"`
+		DisplayName string `json:"displayName"`
+	} `json:"value"`
+	NextLink string `json:"@odata.nextLink"`
+}
+
+// Verifies the id token and fetches the profile and the groups of a user of the allowed Entra ID tenants
+type azureInstance struct {
+	name     string
+	clientID string
+	// the ids of the tenants, whose users may log in
+	allowedTenants []string
+	// id for the object ids of the groups in the user info or empty for no groups
+	groups     string
+	keys       *jwkSet
+	httpClient *http.Client
+}
+
+func configureAzure(name string, opts map[string]string) (Provider, error) {
+	tenant := opts["tenant"]
+	if tenant == "" {
+		return Provider{}, fmt.Errorf("missing parameter tenant for azure provider %v", name)
+	}
+	if !azureTenantPattern.MatchString(tenant) {
+		return Provider{}, fmt.Errorf("invalid tenant %q for azure provider %v", tenant, name)
+	}
+	allowedTenants, err := azureAllowedTenants(tenant, opts["allowed_tenants"])
+	if err != nil {
+		return Provider{}, fmt.Errorf("%v for azure provider %v", err, name)
+	}
+	httpClient := &http.Client{Timeout: defaultTimeout}
+	instance := &azureInstance{
+		name:           name,
+		clientID:       opts["client_id"],
+		allowedTenants: allowedTenants,
+		groups:         opts["groups"],
+		keys:           &jwkSet{url: fmt.Sprintf("%v/%v/discovery/v2.0/keys", azureLoginURL, tenant), httpClient: httpClient},
+		httpClient:     httpClient,
+	}
+	scopes := azureDefaultScopes
+	// display names are not unique and users may create groups, so that only the ids identify a group
+	switch instance.groups {
+	case "":
+	case "id":
+		scopes += " " + azureGroupsScope
+	default:
+		return Provider{}, fmt.Errorf("invalid value %q for parameter groups of azure provider %v, has to be id", instance.groups, name)
+	}
+	return Provider{
+		Name:          name,
+		AuthURL:       fmt.Sprintf("%v/%v/oauth2/v2.0/authorize", azureLoginURL, tenant),
+		TokenURL:      fmt.Sprintf("%v/%v/oauth2/v2.0/token", azureLoginURL, tenant),
+		DefaultScopes: scopes,
+		UseNonce:      true,
+		SupportsPKCE:  true,
+		GetUserInfo:   instance.getUserInfo,
+	}, nil
+}
+
+// Returns the ids of the tenants, whose users may log in.
+// Without the ';' separated allowed_tenants, only the tenant itself is allowed, if it is an id.
+// The multi tenant values common and organizations and the domains of tenants need the explicit list.
+func azureAllowedTenants(tenant, allowedTenants string) ([]string, error) {
+	if allowedTenants == "" {
+		switch {
+		case azureTenantIDPattern.MatchString(strings.ToLower(tenant)):
+			return []string{strings.ToLower(tenant)}, nil
+		case tenant == "consumers":
+			return []string{azureConsumersTenantID}, nil
+		}
+		return nil, fmt.Errorf("missing parameter allowed_tenants with the tenant ids for tenant %v", tenant)
+	}
+	ids := []string{}
+	for _, id := range strings.Split(allowedTenants, ";") {
+		id = strings.ToLower(strings.TrimSpace(id))
+		if !azureTenantIDPattern.MatchString(id) {
+			return nil, fmt.Errorf("invalid tenant id %q in allowed_tenants", id)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func (a *azureInstance) getUserInfo(token TokenInfo) (model.UserInfo, string, error) {
+	claims, err := verifyIDToken(a.keys, token.IDToken, a.clientID, token.Nonce)
+	if err != nil {
+		return model.UserInfo{}, "", err
+	}
+	// the keys are shared by all tenants, so the tenant has to be checked on every login
+	tid := stringClaim(claims, "tid")
+	if !a.isAllowedTenant(tid) {
+		return model.UserInfo{}, "", fmt.Errorf("azure user of tenant %q is not allowed", tid)
+	}
+	if claims["iss"] != fmt.Sprintf("%v/%v/v2.0", azureLoginURL, tid) {
+		return model.UserInfo{}, "", fmt.Errorf("invalid id token: wrong issuer %v", claims["iss"])
+	}
+	au := AzureUser{}
+	b, err := getJSON(a.httpClient, azureGraphAPI+"/me?$select=id,userPrincipalName,displayName,mail", token.AccessToken, &au)
+	if err != nil {
+		return model.UserInfo{}, "", fmt.Errorf("error on azure get user info: %v", err)
+	}
+	if au.ID == "" || au.ID != stringClaim(claims, "oid") {
+		return model.UserInfo{}, "", errors.New("azure user info does not match the id token")
+	}
+	// the userPrincipalName may change and be reused by another user, the object id not
+	userInfo := model.UserInfo{
+		Sub:    au.ID,
+		Name:   au.DisplayName,
+		Email:  au.Mail,
+		Origin: a.name,
+	}
+	if a.groups == "" {
+		return userInfo, string(b), nil
+	}
+	groups, rawGroups, err := a.getGroups(token.AccessToken)
+	if err != nil {
+		return model.UserInfo{}, "", err
+	}
+	userInfo.Groups = groups
+	return userInfo, `{"user":` + string(b) + `,"groups":` + rawGroups + `}`, nil
+}
+
+func (a *azureInstance) isAllowedTenant(tid string) bool {
+	for _, id := range a.allowedTenants {
+		if id == tid {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the transitive group memberships, i.e. also the groups of nested groups, of all pages
+func (a *azureInstance) getGroups(accessToken string) ([]string, string, error) {
+	groups := []string{}
+	raw := []json.RawMessage{}
+	url := azureGraphAPI + "/me/transitiveMemberOf/microsoft.graph.group?$select=id,displayName&$top=999"
+	for page := 0; url != ""; page++ {
+		if page == azureMaxGroupPages {
+			return nil, "", fmt.Errorf("more than %v pages of azure groups", azureMaxGroupPages)
+		}
+		// the access token must not be sent to other servers
+		if !strings.HasPrefix(url, azureGraphAPI+"/") {
+			return nil, "", fmt.Errorf("azure groups next link %q is not on the graph api", url)
+		}
+		p := AzureGroupPage{}
+		b, err := getJSON(a.httpClient, url, accessToken, &p)
+		if err != nil {
+			return nil, "", fmt.Errorf("error on azure get groups: %v", err)
+		}
+		for _, g := range p.Value {
+			groups = append(groups, g.ID)
+		}
+		raw = append(raw, b)
+		url = p.NextLink
+	}
+	rawGroups, err := json.Marshal(raw)
+	return groups, string(rawGroups), err
+}
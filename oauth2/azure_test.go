@@ -0,0 +1,252 @@
+package oauth2
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pchchv/logsrv/model"
+	. "github.com/stretchr/testify/assert"
+)
+
+const (
+	azureTestTenantID      = "72f988bf-86f1-41af-91ab-2d7cd011db47"
+	azureTestOtherTenantID = "f8cdef31-a31e-4b4a-93e4-5f571e91255a"
+	azureTestObjectID      = "87d349ed-44d7-43e1-9a83-5f2406dee5bd"
+)
+
+var azureTestUserResponse = `{
+	"@odata.context": "https://graph.microsoft.com/v1.0/$metadata#users/$entity",
+	"id": "87d349ed-44d7-43e1-9a83-5f2406dee5bd",
+	"userPrincipalName": "marvin@contoso.onmicrosoft.com",
+	"displayName": "Marvin",
+	"mail": "marvin@contoso.com"
+}`
+
+func newAzureTestGraph(t *testing.T) *httptest.Server {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/me":
+			_, _ = w.Write([]byte(azureTestUserResponse))
+		case r.URL.Path == "/me/transitiveMemberOf/microsoft.graph.group" && r.URL.Query().Get("$skiptoken") == "":
+			_, _ = w.Write([]byte(`{"value":[{"id":"g1","displayName":"admins"},{"id":"g2","displayName":"developers"}],` +
+				`"@odata.nextLink":"` + server.URL + `/me/transitiveMemberOf/microsoft.graph.group?$skiptoken=page2"}`))
+		case r.URL.Path == "/me/transitiveMemberOf/microsoft.graph.group":
+			_, _ = w.Write([]byte(`{"value":[{"id":"g3","displayName":"nested"}]}`))
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	azureGraphAPI = server.URL
+	return server
+}
+
+// Serves the keys of every tenant and sets the login url to the test server
+func newAzureTestLogin(t *testing.T) *testIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	NoError(t, err)
+	login := &testIssuer{key: key}
+	login.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/discovery/v2.0/keys") {
+			w.WriteHeader(404)
+			return
+		}
+		writeJSON(w, map[string]interface{}{"keys": []JSONWebKey{{
+			Kid: "key1",
+			Kty: "RSA",
+			Use: "sig",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	}))
+	azureLoginURL = login.URL
+	return login
+}
+
+// Returns the token info of a login of the test user to the tenant
+func azureTestTokenInfo(t *testing.T, login *testIssuer, tid string, claims jwt.MapClaims) TokenInfo {
+	defaults := jwt.MapClaims{
+		"iss": login.URL + "/" + tid + "/v2.0",
+		"tid": tid,
+		"oid": azureTestObjectID,
+	}
+	for k, v := range claims {
+		defaults[k] = v
+	}
+	return TokenInfo{
+		AccessToken: "secret",
+		IDToken:     login.idToken(t, "key1", defaults),
+		Nonce:       "the-nonce",
+	}
+}
+
+// Restores the urls of the Microsoft endpoints, which are replaced by test servers
+func restoreAzureURLs(loginURL, graphAPI string) {
+	azureLoginURL = loginURL
+	azureGraphAPI = graphAPI
+}
+
+func Test_Azure_Configure(t *testing.T) {
+	p, err := configureAzure("azure", map[string]string{"tenant": azureTestTenantID})
+	NoError(t, err)
+	Equal(t, "azure", p.Name)
+	Equal(t, "https://login.microsoftonline.com/"+azureTestTenantID+"/oauth2/v2.0/authorize", p.AuthURL)
+	Equal(t, "https://login.microsoftonline.com/"+azureTestTenantID+"/oauth2/v2.0/token", p.TokenURL)
+	Equal(t, "openid profile email User.Read", p.DefaultScopes)
+	True(t, p.UseNonce)
+	True(t, p.SupportsPKCE)
+
+	// single tenant by domain with groups
+	p, err = configureAzure("contoso", map[string]string{
+		"tenant":          "contoso.onmicrosoft.com",
+		"allowed_tenants": azureTestTenantID,
+		"groups":          "id",
+	})
+	NoError(t, err)
+	Equal(t, "contoso", p.Name)
+	Equal(t, "https://login.microsoftonline.com/contoso.onmicrosoft.com/oauth2/v2.0/authorize", p.AuthURL)
+	Equal(t, "https://login.microsoftonline.com/contoso.onmicrosoft.com/oauth2/v2.0/token", p.TokenURL)
+	Equal(t, "openid profile email User.Read GroupMember.Read.All", p.DefaultScopes)
+
+	// multiple tenants with group ids
+	p, err = configureAzure("azure", map[string]string{
+		"tenant":          "organizations",
+		"allowed_tenants": azureTestTenantID + ";" + azureTestOtherTenantID,
+		"groups":          "id",
+	})
+	NoError(t, err)
+	Equal(t, "openid profile email User.Read GroupMember.Read.All", p.DefaultScopes)
+
+	_, err = configureAzure("azure", map[string]string{"tenant": "consumers"})
+	NoError(t, err)
+
+	for _, opts := range []map[string]string{
+		{},
+		{"tenant": "contoso/../other"},
+		{"tenant": "common"},
+		{"tenant": "organizations"},
+		{"tenant": "contoso.onmicrosoft.com"},
+		{"tenant": "common", "allowed_tenants": "contoso.onmicrosoft.com"},
+		{"tenant": azureTestTenantID, "groups": "true"},
+		// display names are not unique
+		{"tenant": azureTestTenantID, "groups": "name"},
+	} {
+		_, err = configureAzure("azure", opts)
+		Error(t, err, "%v", opts)
+	}
+}
+
+func Test_Azure_Manager(t *testing.T) {
+	m := NewManager()
+	NoError(t, m.AddConfig("entra", map[string]string{
+		"provider":        "azure",
+		"tenant":          "organizations",
+		"allowed_tenants": azureTestTenantID,
+		"client_id":       "client42",
+	}))
+	cfg, err := m.GetConfigFromRequest(httptest.NewRequest("GET", "/login/entra", nil))
+	NoError(t, err)
+	Equal(t, "https://login.microsoftonline.com/organizations/oauth2/v2.0/authorize", cfg.AuthURL)
+	True(t, cfg.PKCE)
+}
+
+func Test_Azure_GetUserInfo(t *testing.T) {
+	defer restoreAzureURLs(azureLoginURL, azureGraphAPI)
+	login := newAzureTestLogin(t)
+	defer login.Close()
+	server := newAzureTestGraph(t)
+	defer server.Close()
+
+	p, err := configureAzure("azure", map[string]string{"tenant": azureTestTenantID, "client_id": "client42"})
+	NoError(t, err)
+	u, rawJSON, err := p.GetUserInfo(azureTestTokenInfo(t, login, azureTestTenantID, nil))
+	NoError(t, err)
+	Equal(t, model.UserInfo{
+		Sub:    azureTestObjectID,
+		Name:   "Marvin",
+		Email:  "marvin@contoso.com",
+		Origin: "azure",
+	}, u)
+	Equal(t, azureTestUserResponse, rawJSON)
+
+	_, _, err = providerAzure.GetUserInfo(azureTestTokenInfo(t, login, azureTestTenantID, nil))
+	Error(t, err)
+}
+
+func Test_Azure_GetUserInfo_Tenants(t *testing.T) {
+	defer restoreAzureURLs(azureLoginURL, azureGraphAPI)
+	login := newAzureTestLogin(t)
+	defer login.Close()
+	server := newAzureTestGraph(t)
+	defer server.Close()
+
+	p, err := configureAzure("azure", map[string]string{
+		"tenant":          "organizations",
+		"allowed_tenants": azureTestTenantID + ";" + azureTestOtherTenantID,
+		"client_id":       "client42",
+	})
+	NoError(t, err)
+	u, _, err := p.GetUserInfo(azureTestTokenInfo(t, login, azureTestOtherTenantID, nil))
+	NoError(t, err)
+	Equal(t, azureTestObjectID, u.Sub)
+
+	outsider := "0a1b2c3d-0000-4000-8000-000000000000"
+	for name, tokenInfo := range map[string]TokenInfo{
+		"no id token":          {AccessToken: "secret", Nonce: "the-nonce"},
+		"other tenant":         azureTestTokenInfo(t, login, outsider, nil),
+		"no tenant":            azureTestTokenInfo(t, login, azureTestTenantID, jwt.MapClaims{"tid": ""}),
+		"issuer of outsider":   azureTestTokenInfo(t, login, azureTestTenantID, jwt.MapClaims{"iss": login.URL + "/" + outsider + "/v2.0"}),
+		"other client":         azureTestTokenInfo(t, login, azureTestTenantID, jwt.MapClaims{"aud": "other"}),
+		"other object id":      azureTestTokenInfo(t, login, azureTestTenantID, jwt.MapClaims{"oid": "e2a1d4c6-0000-4000-8000-000000000000"}),
+		"nonce does not match": azureTestTokenInfo(t, login, azureTestTenantID, jwt.MapClaims{"nonce": "other"}),
+	} {
+		_, _, err := p.GetUserInfo(tokenInfo)
+		Error(t, err, name)
+	}
+}
+
+func Test_Azure_GetUserInfo_Groups(t *testing.T) {
+	defer restoreAzureURLs(azureLoginURL, azureGraphAPI)
+	login := newAzureTestLogin(t)
+	defer login.Close()
+	server := newAzureTestGraph(t)
+	defer server.Close()
+
+	p, err := configureAzure("azure", map[string]string{"tenant": azureTestTenantID, "client_id": "client42", "groups": "id"})
+	NoError(t, err)
+	u, rawJSON, err := p.GetUserInfo(azureTestTokenInfo(t, login, azureTestTenantID, nil))
+	NoError(t, err)
+	Equal(t, []string{"g1", "g2", "g3"}, u.Groups)
+	Contains(t, rawJSON, `"userPrincipalName": "marvin@contoso.onmicrosoft.com"`)
+	Contains(t, rawJSON, `{"id":"g3","displayName":"nested"}`)
+}
+
+func Test_Azure_GetUserInfo_ForeignNextLink(t *testing.T) {
+	defer restoreAzureURLs(azureLoginURL, azureGraphAPI)
+	login := newAzureTestLogin(t)
+	defer login.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/me" {
+			_, _ = w.Write([]byte(azureTestUserResponse))
+			return
+		}
+		_, _ = w.Write([]byte(`{"value":[],"@odata.nextLink":"https://example.com/steal"}`))
+	}))
+	defer server.Close()
+	azureGraphAPI = server.URL
+
+	p, err := configureAzure("azure", map[string]string{"tenant": azureTestTenantID, "client_id": "client42", "groups": "id"})
+	NoError(t, err)
+	_, _, err = p.GetUserInfo(azureTestTokenInfo(t, login, azureTestTenantID, nil))
+	Error(t, err)
+}
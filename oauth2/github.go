@@ -25,9 +25,10 @@ type GithubUser struct {
 }
 
 var providerGithub = Provider{
-	Name:     "github",
-	AuthURL:  "https://github.com/login/oauth/authorize",
-	TokenURL: "https://github.com/login/oauth/access_token",
+	Name:         "github",
+	AuthURL:      "https://github.com/login/oauth/authorize",
+	TokenURL:     "https://github.com/login/oauth/access_token",
+	SupportsPKCE: true,
 	GetUserInfo: func(token TokenInfo) (model.UserInfo, string, error) {
 		gu := GithubUser{}
 		url := githubAPI + "/user"
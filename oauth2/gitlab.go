@@ -29,9 +29,10 @@ type GitlabGroup struct {
 }
 
 var providerGitlab = Provider{
-	Name:     "gitlab",
-	AuthURL:  "https://gitlab.com/oauth/authorize",
-	TokenURL: "https://gitlab.com/oauth/token",
+	Name:         "gitlab",
+	AuthURL:      "https://gitlab.com/oauth/authorize",
+	TokenURL:     "https://gitlab.com/oauth/token",
+	SupportsPKCE: true,
 	GetUserInfo: func(token TokenInfo) (model.UserInfo, string, error) {
 		gu := GitlabUser{}
 		url := fmt.Sprintf("%v/user?access_token=%v", gitlabAPI, token.AccessToken)
@@ -30,6 +30,7 @@ var providerGoogle = Provider{
 	AuthURL:       "https://accounts.google.com/o/oauth2/v2/auth",
 	TokenURL:      "https://www.googleapis.com/oauth2/v4/token",
 	DefaultScopes: "email profile",
+	SupportsPKCE:  true,
 	GetUserInfo: func(token TokenInfo) (model.UserInfo, string, error) {
 		gu := GoogleUser{}
 		url := fmt.Sprintf("%v?access_token=%v", googleUserinfoEndpoint, token.AccessToken)
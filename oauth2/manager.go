@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 
 	"github.com/pchchv/logsrv/model"
@@ -41,14 +42,19 @@ func (manager *Manager) Handle(w http.ResponseWriter, r *http.Request) (
 	userInfo model.UserInfo,
 	err error,
 ) {
+	cfg, err := manager.GetConfigFromRequest(r)
 	if r.FormValue("error") != "" {
+		if err == nil {
+			deleteFlowCookies(cfg, w)
+		}
 		return false, false, model.UserInfo{}, fmt.Errorf("error: %v", r.FormValue("error"))
 	}
-	cfg, err := manager.GetConfigFromRequest(r)
 	if err != nil {
 		return false, false, model.UserInfo{}, err
 	}
 	if r.FormValue("code") != "" {
+		// the callback ends the flow, whether it succeeds or fails
+		deleteFlowCookies(cfg, w)
 		tokenInfo, err := manager.authenticate(cfg, r)
 		if err != nil {
 			return false, false, model.UserInfo{}, err
@@ -86,23 +92,45 @@ func (manager *Manager) getConfigNameFromPath(path string) string {
 }
 
 // AddConfig for a provider
-func (manager *Manager) AddConfig(providerName string, opts map[string]string) error {
+// The configuration is registered by its name, which is also the name of the provider,
+// unless a different provider is given by the option "provider".
+// This allows multiple named instances of a provider, e.g. for OpenID Connect.
+func (manager *Manager) AddConfig(name string, opts map[string]string) error {
+	providerName := name
+	if p, exist := opts["provider"]; exist {
+		providerName = p
+	}
 	p, exist := GetProvider(providerName)
 	if !exist {
 		return fmt.Errorf("no provider for name %v", providerName)
 	}
+	if p.Configure != nil {
+		var err error
+		p, err = p.Configure(name, opts)
+		if err != nil {
+			return err
+		}
+	}
 	cfg := Config{
 		Provider: p,
 		AuthURL:  p.AuthURL,
 		TokenURL: p.TokenURL,
+		PKCE:     p.SupportsPKCE,
+	}
+	if pkce, exist := opts["pkce"]; exist {
+		var err error
+		if cfg.PKCE, err = strconv.ParseBool(pkce); err != nil {
+			return fmt.Errorf("invalid value for parameter pkce: %v", pkce)
+		}
 	}
 	clientID, exist := opts["client_id"]
 	if !exist {
 		return fmt.Errorf("missing parameter client_id")
 	}
 	cfg.ClientID = clientID
+	// without a secret, the client is a public client, which has to use PKCE
 	clientSecret, exist := opts["client_secret"]
-	if !exist {
+	if !exist && !cfg.PKCE {
 		return fmt.Errorf("missing parameter client_secret")
 	}
 	cfg.ClientSecret = clientSecret
@@ -114,7 +142,7 @@ func (manager *Manager) AddConfig(providerName string, opts map[string]string) e
 	if redirectURI, exist := opts["redirect_uri"]; exist {
 		cfg.RedirectURI = redirectURI
 	}
-	manager.configs[providerName] = cfg
+	manager.configs[name] = cfg
 	return nil
 }
 
@@ -107,13 +107,24 @@ func Test_Manager_NoAauthOnWrongCode(t *testing.T) {
 	}
 	// callback
 	r, _ := http.NewRequest("GET", "http://example.com/login/"+exampleProvider.Name+"?code=xyz", nil)
-	startedFlow, authenticated, userInfo, err := m.Handle(httptest.NewRecorder(), r)
+	recorder := httptest.NewRecorder()
+	startedFlow, authenticated, userInfo, err := m.Handle(recorder, r)
 	EqualError(t, err, "code not valid")
 	False(t, startedFlow)
 	False(t, authenticated)
 	Equal(t, model.UserInfo{}, userInfo)
 	True(t, authenticateCalled)
 	False(t, getUserInfoCalled)
+	// the cookies of the flow are expired on a failed callback as well
+	cookies := map[string]*http.Cookie{}
+	for _, c := range recorder.Result().Cookies() {
+		cookies[c.Name] = c
+	}
+	for _, name := range []string{stateCookieName, nonceCookieName, pkceCookieName} {
+		NotNil(t, cookies[name], name)
+		Equal(t, -1, cookies[name].MaxAge, name)
+		Equal(t, "/login/"+exampleProvider.Name, cookies[name].Path, name)
+	}
 }
 
 func Test_Manager_getConfig_ErrorCase(t *testing.T) {
@@ -153,9 +164,75 @@ func Test_Manager_AddConfig_ErrorCases(t *testing.T) {
 	EqualError(t,
 		m.AddConfig("github", map[string]string{
 			"client_id": "foo",
+			"pkce":      "false",
 		}),
 		"missing parameter client_secret",
 	)
+	EqualError(t,
+		m.AddConfig("github", map[string]string{
+			"client_id": "foo",
+			"pkce":      "maybe",
+		}),
+		"invalid value for parameter pkce: maybe",
+	)
+}
+
+func Test_Manager_AddConfig_PKCE(t *testing.T) {
+	m := NewManager()
+	// public client without secret
+	NoError(t, m.AddConfig("github", map[string]string{"client_id": "foo"}))
+	True(t, m.GetConfigs()["github"].PKCE)
+	Equal(t, "", m.GetConfigs()["github"].ClientSecret)
+	// providers without PKCE support
+	NoError(t, m.AddConfig("facebook", map[string]string{"client_id": "foo", "client_secret": "bar"}))
+	False(t, m.GetConfigs()["facebook"].PKCE)
+	EqualError(t, m.AddConfig("facebook", map[string]string{"client_id": "foo"}), "missing parameter client_secret")
+	// explicit opt-in
+	NoError(t, m.AddConfig("facebook", map[string]string{"client_id": "foo", "pkce": "true"}))
+	True(t, m.GetConfigs()["facebook"].PKCE)
+}
+
+func Test_Manager_AddConfig_NamedInstances(t *testing.T) {
+	var configuredNames []string
+	exampleProvider := Provider{
+		Name:     "example",
+		AuthURL:  "https://example.com/login/oauth/authorize",
+		TokenURL: "https://example.com/login/oauth/access_token",
+		Configure: func(name string, opts map[string]string) (Provider, error) {
+			configuredNames = append(configuredNames, name)
+			if opts["issuer"] == "" {
+				return Provider{}, errors.New("missing parameter issuer")
+			}
+			return Provider{Name: name, AuthURL: opts["issuer"] + "/auth", TokenURL: opts["issuer"] + "/token"}, nil
+		},
+	}
+	RegisterProvider(exampleProvider)
+	defer UnRegisterProvider(exampleProvider.Name)
+	m := NewManager()
+	for _, name := range []string{"first", "second"} {
+		NoError(t, m.AddConfig(name, map[string]string{
+			"provider":      exampleProvider.Name,
+			"issuer":        "https://" + name + ".example.com",
+			"client_id":     "foo",
+			"client_secret": "bar",
+		}))
+	}
+	Equal(t, []string{"first", "second"}, configuredNames)
+	Equal(t, 2, len(m.GetConfigs()))
+	r, _ := http.NewRequest("GET", "http://example.com/login/second", nil)
+	cfg, err := m.GetConfigFromRequest(r)
+	NoError(t, err)
+	Equal(t, "https://second.example.com/auth", cfg.AuthURL)
+	Equal(t, "https://second.example.com/token", cfg.TokenURL)
+	Equal(t, "second", cfg.Provider.Name)
+	EqualError(t,
+		m.AddConfig("third", map[string]string{
+			"provider":      exampleProvider.Name,
+			"client_id":     "foo",
+			"client_secret": "bar",
+		}),
+		"missing parameter issuer",
+	)
 }
 
 func Test_Manager_redirectUriFromRequest(t *testing.T) {
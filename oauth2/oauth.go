@@ -3,6 +3,8 @@ package oauth2
 import (
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
@@ -11,6 +13,8 @@ import (
 	"net/url"
 	"strings"
 	"time"
+
+	"github.com/pchchv/logsrv/metrics"
 )
 
 // Describes a typical 3-legged OAuth2 flow,
@@ -30,6 +34,8 @@ type Config struct {
 	Scope string
 	// The OAuth provider
 	Provider Provider
+	// Use PKCE (RFC 7636) with the S256 challenge method
+	PKCE bool
 }
 
 // Represents the credentials used to authorize
@@ -42,6 +48,10 @@ type TokenInfo struct {
 	TokenType string `json:"token_type,omitempty"`
 	// Scopes for this tolen
 	Scope string `json:"scope,omitempty"`
+	// OpenID Connect id token
+	IDToken string `json:"id_token,omitempty"`
+	// Nonce sent with the authorization request, if the provider uses a nonce
+	Nonce string `json:"-"`
 }
 
 // Represents an oauth error response in json form
@@ -51,6 +61,8 @@ type JSONError struct {
 
 const (
 	stateCookieName = "oauthState"
+	nonceCookieName = "oauthNonce"
+	pkceCookieName  = "oauthPkce"
 	defaultTimeout  = 5 * time.Second
 )
 
@@ -68,18 +80,53 @@ func StartFlow(cfg Config, w http.ResponseWriter) error {
 		return err
 	}
 	values.Set("state", state)
-	http.SetCookie(w, &http.Cookie{
-		Name:     stateCookieName,
-		MaxAge:   60 * 10, // 10 minutes
-		Value:    values.Get("state"),
-		HttpOnly: true,
-	})
+	setFlowCookie(w, cfg, stateCookieName, state, 60*10) // 10 minutes
+	if cfg.PKCE {
+		// 64 hex characters are a valid code verifier
+		verifier, err := randStringBytes(32)
+		if err != nil {
+			return err
+		}
+		values.Set("code_challenge", pkceChallenge(verifier))
+		values.Set("code_challenge_method", "S256")
+		setFlowCookie(w, cfg, pkceCookieName, verifier, 60*10) // 10 minutes
+	}
+	if cfg.Provider.UseNonce {
+		nonce, err := randStringBytes(32)
+		if err != nil {
+			return err
+		}
+		values.Set("nonce", nonce)
+		setFlowCookie(w, cfg, nonceCookieName, nonce, 60*10) // 10 minutes
+	}
 	targetURL := cfg.AuthURL + "?" + values.Encode()
 	w.Header().Set("Location", targetURL)
 	w.WriteHeader(http.StatusFound)
 	return nil
 }
 
+// The cookies of a flow are only sent to the callback path of the redirect uri
+func setFlowCookie(w http.ResponseWriter, cfg Config, name, value string, maxAge int) {
+	path := "/"
+	if u, err := url.Parse(cfg.RedirectURI); err == nil && u.Path != "" {
+		path = u.Path
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		MaxAge:   maxAge,
+		HttpOnly: true,
+	})
+}
+
+// Expires the state, nonce and pkce cookies, which are used by one callback only
+func deleteFlowCookies(cfg Config, w http.ResponseWriter) {
+	for _, name := range []string{stateCookieName, nonceCookieName, pkceCookieName} {
+		setFlowCookie(w, cfg, name, "", -1)
+	}
+}
+
 // Authenticate after coming back from the oauth flow
 // Verify the state parameter against the state cookie from the request
 func Authenticate(cfg Config, r *http.Request) (TokenInfo, error) {
@@ -95,13 +142,40 @@ func Authenticate(cfg Config, r *http.Request) (TokenInfo, error) {
 	if code == "" {
 		return TokenInfo{}, fmt.Errorf("error: no auth code provided")
 	}
-	return getAccessToken(cfg, state, code)
+	nonce := ""
+	if cfg.Provider.UseNonce {
+		nonceCookie, err := r.Cookie(nonceCookieName)
+		if err != nil || nonceCookie.Value == "" {
+			return TokenInfo{}, fmt.Errorf("error: no oauth nonce cookie")
+		}
+		nonce = nonceCookie.Value
+	}
+	verifier := ""
+	if cfg.PKCE {
+		pkceCookie, err := r.Cookie(pkceCookieName)
+		if err != nil || pkceCookie.Value == "" {
+			return TokenInfo{}, fmt.Errorf("error: no oauth pkce cookie")
+		}
+		verifier = pkceCookie.Value
+	}
+	tokenInfo, err := getAccessToken(cfg, code, verifier)
+	if err != nil {
+		metrics.OauthTokenExchangeFailures.WithLabelValues(cfg.Provider.Name).Inc()
+	}
+	tokenInfo.Nonce = nonce
+	return tokenInfo, err
 }
 
-func getAccessToken(cfg Config, state, code string) (TokenInfo, error) {
+func getAccessToken(cfg Config, code, verifier string) (TokenInfo, error) {
 	values := url.Values{}
 	values.Set("client_id", cfg.ClientID)
-	values.Set("client_secret", cfg.ClientSecret)
+	// public clients have no secret and authenticate by the code verifier only
+	if cfg.ClientSecret != "" {
+		values.Set("client_secret", cfg.ClientSecret)
+	}
+	if verifier != "" {
+		values.Set("code_verifier", verifier)
+	}
 	values.Set("code", code)
 	values.Set("redirect_uri", cfg.RedirectURI)
 	values.Set("grant_type", "authorization_code")
@@ -126,7 +200,7 @@ func getAccessToken(cfg Config, state, code string) (TokenInfo, error) {
 	jsonError := JSONError{}
 	err = json.Unmarshal(body, &jsonError)
 	if err != nil {
-		panic(err)
+		return TokenInfo{}, fmt.Errorf("error on parsing oauth token: %v", err)
 	}
 	if jsonError.Error != "" {
 		return TokenInfo{}, fmt.Errorf("error: got %q on token exchange", jsonError.Error)
@@ -142,6 +216,12 @@ func getAccessToken(cfg Config, state, code string) (TokenInfo, error) {
 	return tokenInfo, nil
 }
 
+// Returns the S256 code challenge for the verifier
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
 func randStringBytes(n int) (string, error) {
 	b := make([]byte, n)
 	_, err := rand.Read(b)
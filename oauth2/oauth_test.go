@@ -178,3 +178,71 @@ func Test_Authentication_TokenParseError(t *testing.T) {
 	Error(t, err)
 	Equal(t, "error on parsing oauth token: unexpected end of JSON input", err.Error())
 }
+
+func Test_StartFlow_Nonce(t *testing.T) {
+	cfg := testConfig
+	cfg.Provider = Provider{UseNonce: true}
+	resp := httptest.NewRecorder()
+	NoError(t, StartFlow(cfg, resp))
+	var nonceCookie *http.Cookie
+	for _, c := range resp.Result().Cookies() {
+		if c.Name == nonceCookieName {
+			nonceCookie = c
+		}
+	}
+	NotNil(t, nonceCookie)
+	location, err := url.Parse(resp.Header().Get("Location"))
+	NoError(t, err)
+	Equal(t, nonceCookie.Value, location.Query().Get("nonce"))
+	// the callback is rejected without the nonce cookie
+	request, _ := http.NewRequest("GET", "http://localhost/callback?code=theCode&state=theState", nil)
+	request.Header.Set("Cookie", "oauthState=theState")
+	_, err = Authenticate(cfg, request)
+	EqualError(t, err, "error: no oauth nonce cookie")
+}
+
+func Test_PKCE_Flow(t *testing.T) {
+	cfg := testConfig
+	cfg.ClientSecret = ""
+	cfg.PKCE = true
+	resp := httptest.NewRecorder()
+	NoError(t, StartFlow(cfg, resp))
+	var verifier string
+	for _, c := range resp.Result().Cookies() {
+		if c.Name == pkceCookieName {
+			verifier = c.Value
+			True(t, c.HttpOnly)
+			// the verifier is only sent to the callback
+			Equal(t, "/callback", c.Path)
+		}
+	}
+	True(t, len(verifier) >= 43 && len(verifier) <= 128)
+	location, err := url.Parse(resp.Header().Get("Location"))
+	NoError(t, err)
+	Equal(t, "S256", location.Query().Get("code_challenge_method"))
+	Equal(t, pkceChallenge(verifier), location.Query().Get("code_challenge"))
+	// mock a server for token exchange, which checks the verifier
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		NoError(t, r.ParseForm())
+		Equal(t, verifier, r.PostForm.Get("code_verifier"))
+		_, hasSecret := r.PostForm["client_secret"]
+		False(t, hasSecret)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"the-access-token"}`))
+	}))
+	defer server.Close()
+	cfg.TokenURL = server.URL
+	request, _ := http.NewRequest("GET", "http://localhost/callback?code=theCode&state=theState", nil)
+	request.Header.Set("Cookie", "oauthState=theState; "+pkceCookieName+"="+verifier)
+	tokenInfo, err := Authenticate(cfg, request)
+	NoError(t, err)
+	Equal(t, "the-access-token", tokenInfo.AccessToken)
+	// the callback is rejected without the verifier cookie
+	request.Header.Set("Cookie", "oauthState=theState")
+	_, err = Authenticate(cfg, request)
+	EqualError(t, err, "error: no oauth pkce cookie")
+}
+
+func Test_pkceChallenge_RFC7636Example(t *testing.T) {
+	Equal(t, "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM", pkceChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"))
+}
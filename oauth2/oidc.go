@@ -0,0 +1,324 @@
+package oauth2
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pchchv/logsrv/model"
+)
+
+const (
+	oidcDiscoveryPath = "/.well-known/openid-configuration"
+	oidcDefaultScopes = "openid profile email"
+)
+
+func init() {
+	RegisterProvider(providerOIDC)
+}
+
+// Generic OpenID Connect provider.
+// The endpoints are discovered from the issuer for every configured instance.
+var providerOIDC = Provider{
+	Name:          "oidc",
+	DefaultScopes: oidcDefaultScopes,
+	UseNonce:      true,
+	SupportsPKCE:  true,
+	Configure:     configureOIDC,
+	GetUserInfo: func(token TokenInfo) (model.UserInfo, string, error) {
+		return model.UserInfo{}, "", errors.New("oidc provider is not configured")
+	},
+}
+
+// Used for parsing the discovery document
+type OIDCDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JwksURI               string `json:"jwks_uri"`
+}
+
+// Verifies id tokens and maps the claims of an OpenID Connect provider instance
+type oidcInstance struct {
+	name          string
+	issuer        string
+	clientID      string
+	userinfoURL   string
+	usernameClaim string
+	groupsClaim   string
+	keys          *jwkSet
+	httpClient    *http.Client
+}
+
+func configureOIDC(name string, opts map[string]string) (Provider, error) {
+	issuer := strings.TrimSuffix(opts["issuer"], "/")
+	if issuer == "" {
+		return Provider{}, fmt.Errorf("missing parameter issuer for oidc provider %v", name)
+	}
+	httpClient := &http.Client{Timeout: defaultTimeout}
+	d := OIDCDiscovery{}
+	if _, err := getJSON(httpClient, issuer+oidcDiscoveryPath, "", &d); err != nil {
+		return Provider{}, fmt.Errorf("error on oidc discovery for %v: %v", name, err)
+	}
+	if strings.TrimSuffix(d.Issuer, "/") != issuer {
+		return Provider{}, fmt.Errorf("oidc discovery for %v returned issuer %q, expected %q", name, d.Issuer, issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JwksURI == "" {
+		return Provider{}, fmt.Errorf("oidc discovery for %v misses the authorization, token or jwks endpoint", name)
+	}
+	instance := &oidcInstance{
+		name:          name,
+		issuer:        d.Issuer,
+		clientID:      opts["client_id"],
+		userinfoURL:   d.UserinfoEndpoint,
+		usernameClaim: "sub",
+		groupsClaim:   "groups",
+		keys:          &jwkSet{url: d.JwksURI, httpClient: httpClient},
+		httpClient:    httpClient,
+	}
+	if v, exist := opts["username_claim"]; exist {
+		instance.usernameClaim = v
+	}
+	if v, exist := opts["groups_claim"]; exist {
+		instance.groupsClaim = v
+	}
+	return Provider{
+		Name:          name,
+		AuthURL:       d.AuthorizationEndpoint,
+		TokenURL:      d.TokenEndpoint,
+		DefaultScopes: oidcDefaultScopes,
+		UseNonce:      true,
+		SupportsPKCE:  true,
+		GetUserInfo:   instance.getUserInfo,
+	}, nil
+}
+
+func (o *oidcInstance) getUserInfo(token TokenInfo) (model.UserInfo, string, error) {
+	claims, err := verifyIDToken(o.keys, token.IDToken, o.clientID, token.Nonce)
+	if err != nil {
+		return model.UserInfo{}, "", err
+	}
+	if claims["iss"] != o.issuer {
+		return model.UserInfo{}, "", fmt.Errorf("invalid id token: wrong issuer %v", claims["iss"])
+	}
+	if o.userinfoURL != "" {
+		userinfo := map[string]interface{}{}
+		if _, err := getJSON(o.httpClient, o.userinfoURL, token.AccessToken, &userinfo); err != nil {
+			return model.UserInfo{}, "", fmt.Errorf("error on oidc get user info: %v", err)
+		}
+		if userinfo["sub"] != claims["sub"] {
+			return model.UserInfo{}, "", errors.New("oidc user info subject does not match the id token")
+		}
+		for k, v := range userinfo {
+			claims[k] = v
+		}
+	}
+	b, err := json.Marshal(claims)
+	if err != nil {
+		return model.UserInfo{}, "", err
+	}
+	// an unverified email may belong to another user
+	emailVerified := claims["email_verified"] == true
+	if o.usernameClaim == "email" && !emailVerified {
+		return model.UserInfo{}, "", errors.New("oidc username claim email is not verified")
+	}
+	userInfo := model.UserInfo{
+		Sub:     stringClaim(claims, o.usernameClaim),
+		Name:    stringClaim(claims, "name"),
+		Picture: stringClaim(claims, "picture"),
+		Origin:  o.name,
+	}
+	if emailVerified {
+		userInfo.Email = stringClaim(claims, "email")
+	}
+	if userInfo.Sub == "" {
+		userInfo.Sub = stringClaim(claims, "sub")
+	}
+	if groups, ok := claims[o.groupsClaim].([]interface{}); ok {
+		for _, g := range groups {
+			if s, ok := g.(string); ok {
+				userInfo.Groups = append(userInfo.Groups, s)
+			}
+		}
+	}
+	return userInfo, string(b), nil
+}
+
+// Verifies signature, audience, expiry and nonce of an id token and returns its claims.
+// The issuer has to be checked by the caller.
+func verifyIDToken(keys *jwkSet, idToken, clientID, nonce string) (jwt.MapClaims, error) {
+	if idToken == "" {
+		return nil, errors.New("error: no id_token on token exchange")
+	}
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(idToken, claims, func(t *jwt.Token) (interface{}, error) {
+		switch t.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA:
+		default:
+			return nil, fmt.Errorf("unsupported id token algorithm %v", t.Method.Alg())
+		}
+		kid, _ := t.Header["kid"].(string)
+		return keys.key(kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid id token: %v", err)
+	}
+	if !containsAudience(claims["aud"], clientID) {
+		return nil, fmt.Errorf("invalid id token: not issued for client %v", clientID)
+	}
+	if exp, ok := claims["exp"].(float64); !ok || int64(exp) < time.Now().Unix() {
+		return nil, errors.New("invalid id token: expired or no expiry")
+	}
+	if claims["nonce"] != nonce {
+		return nil, errors.New("invalid id token: nonce does not match")
+	}
+	return claims, nil
+}
+
+func containsAudience(aud interface{}, clientID string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == clientID
+	case []interface{}:
+		for _, a := range aud {
+			if a == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func stringClaim(claims map[string]interface{}, name string) string {
+	s, _ := claims[name].(string)
+	return s
+}
+
+// Fetches a json document, optionally with a bearer token, and returns the raw body
+func getJSON(httpClient *http.Client, url, bearerToken string, v interface{}) ([]byte, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+bearerToken)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("got http status %v on %v", resp.StatusCode, url)
+	}
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return nil, fmt.Errorf("error parsing %v: %v", url, err)
+	}
+	return b, nil
+}
+
+// Public keys of an issuer, loaded from the jwks uri.
+// The keys are reloaded, when a token is signed by an unknown key.
+type jwkSet struct {
+	url        string
+	httpClient *http.Client
+	mu         sync.Mutex
+	keys       map[string]interface{}
+}
+
+// Used for parsing the json web keys
+type JSONWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (s *jwkSet) key(kid string) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if k, exist := s.keys[kid]; exist {
+		return k, nil
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	if k, exist := s.keys[kid]; exist {
+		return k, nil
+	}
+	return nil, fmt.Errorf("no key with kid %q in %v", kid, s.url)
+}
+
+func (s *jwkSet) load() error {
+	jwks := struct {
+		Keys []JSONWebKey `json:"keys"`
+	}{}
+	if _, err := getJSON(s.httpClient, s.url, "", &jwks); err != nil {
+		return err
+	}
+	keys := map[string]interface{}{}
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		k, err := jwk.publicKey()
+		if err != nil {
+			return err
+		}
+		keys[jwk.Kid] = k
+	}
+	s.keys = keys
+	return nil
+}
+
+func (jwk JSONWebKey) publicKey() (interface{}, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid jwk %v: %v", jwk.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid jwk %v: %v", jwk.Kid, err)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+		curve, exist := curves[jwk.Crv]
+		if !exist {
+			return nil, fmt.Errorf("invalid jwk %v: unsupported curve %v", jwk.Kid, jwk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid jwk %v: %v", jwk.Kid, err)
+		}
+		y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid jwk %v: %v", jwk.Kid, err)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+	return nil, fmt.Errorf("invalid jwk %v: unsupported key type %v", jwk.Kid, jwk.Kty)
+}
@@ -0,0 +1,215 @@
+package oauth2
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pchchv/logsrv/model"
+	. "github.com/stretchr/testify/assert"
+)
+
+// Mock of an OpenID Connect issuer with discovery, jwks and userinfo endpoints
+type testIssuer struct {
+	*httptest.Server
+	key      *rsa.PrivateKey
+	userinfo map[string]interface{}
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	NoError(t, err)
+	issuer := &testIssuer{
+		key: key,
+		userinfo: map[string]interface{}{
+			"sub":                "1234",
+			"preferred_username": "marvin",
+			"email":              "marvin@example.org",
+			"email_verified":     true,
+			"groups":             []string{"admins", "developers"},
+		},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(oidcDiscoveryPath, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, OIDCDiscovery{
+			Issuer:                issuer.URL,
+			AuthorizationEndpoint: issuer.URL + "/auth",
+			TokenEndpoint:         issuer.URL + "/token",
+			UserinfoEndpoint:      issuer.URL + "/userinfo",
+			JwksURI:               issuer.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]interface{}{"keys": []JSONWebKey{{
+			Kid: "key1",
+			Kty: "RSA",
+			Use: "sig",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer the-access-token" {
+			w.WriteHeader(401)
+			return
+		}
+		writeJSON(w, issuer.userinfo)
+	})
+	issuer.Server = httptest.NewServer(mux)
+	return issuer
+}
+
+func (i *testIssuer) idToken(t *testing.T, kid string, claims jwt.MapClaims) string {
+	defaults := jwt.MapClaims{
+		"iss":   i.URL,
+		"sub":   "1234",
+		"aud":   "client42",
+		"exp":   time.Now().Add(time.Minute).Unix(),
+		"nonce": "the-nonce",
+		"name":  "Marvin",
+	}
+	for k, v := range claims {
+		defaults[k] = v
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, defaults)
+	token.Header["kid"] = kid
+	s, err := token.SignedString(i.key)
+	NoError(t, err)
+	return s
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func Test_OIDC_Configure(t *testing.T) {
+	issuer := newTestIssuer(t)
+	defer issuer.Close()
+	p, err := configureOIDC("keycloak", map[string]string{"issuer": issuer.URL + "/", "client_id": "client42"})
+	NoError(t, err)
+	Equal(t, "keycloak", p.Name)
+	Equal(t, issuer.URL+"/auth", p.AuthURL)
+	Equal(t, issuer.URL+"/token", p.TokenURL)
+	Equal(t, "openid profile email", p.DefaultScopes)
+	True(t, p.UseNonce)
+	_, err = configureOIDC("keycloak", map[string]string{})
+	Error(t, err)
+	// the discovered issuer has to match the configured one
+	_, err = configureOIDC("keycloak", map[string]string{"issuer": issuer.URL + "/other"})
+	Error(t, err)
+}
+
+func Test_OIDC_GetUserInfo(t *testing.T) {
+	issuer := newTestIssuer(t)
+	defer issuer.Close()
+	p, err := configureOIDC("keycloak", map[string]string{"issuer": issuer.URL, "client_id": "client42"})
+	NoError(t, err)
+	userInfo, rawJSON, err := p.GetUserInfo(TokenInfo{
+		AccessToken: "the-access-token",
+		IDToken:     issuer.idToken(t, "key1", nil),
+		Nonce:       "the-nonce",
+	})
+	NoError(t, err)
+	Equal(t, model.UserInfo{
+		Sub:    "1234",
+		Name:   "Marvin",
+		Email:  "marvin@example.org",
+		Groups: []string{"admins", "developers"},
+		Origin: "keycloak",
+	}, userInfo)
+	Contains(t, rawJSON, `"preferred_username":"marvin"`)
+}
+
+func Test_OIDC_GetUserInfo_ClaimMapping(t *testing.T) {
+	issuer := newTestIssuer(t)
+	defer issuer.Close()
+	issuer.userinfo["roles"] = []string{"viewer"}
+	p, err := configureOIDC("keycloak", map[string]string{
+		"issuer":         issuer.URL,
+		"client_id":      "client42",
+		"username_claim": "email",
+		"groups_claim":   "roles",
+	})
+	NoError(t, err)
+	userInfo, _, err := p.GetUserInfo(TokenInfo{
+		AccessToken: "the-access-token",
+		IDToken:     issuer.idToken(t, "key1", nil),
+		Nonce:       "the-nonce",
+	})
+	NoError(t, err)
+	Equal(t, "marvin@example.org", userInfo.Sub)
+	Equal(t, []string{"viewer"}, userInfo.Groups)
+
+	// an unverified email is no username
+	issuer.userinfo["email_verified"] = false
+	_, _, err = p.GetUserInfo(TokenInfo{
+		AccessToken: "the-access-token",
+		IDToken:     issuer.idToken(t, "key1", nil),
+		Nonce:       "the-nonce",
+	})
+	Error(t, err)
+}
+
+func Test_OIDC_GetUserInfo_UnverifiedEmail(t *testing.T) {
+	issuer := newTestIssuer(t)
+	defer issuer.Close()
+	delete(issuer.userinfo, "email_verified")
+	p, err := configureOIDC("keycloak", map[string]string{"issuer": issuer.URL, "client_id": "client42"})
+	NoError(t, err)
+	userInfo, _, err := p.GetUserInfo(TokenInfo{
+		AccessToken: "the-access-token",
+		IDToken:     issuer.idToken(t, "key1", nil),
+		Nonce:       "the-nonce",
+	})
+	NoError(t, err)
+	Equal(t, "1234", userInfo.Sub)
+	Empty(t, userInfo.Email)
+}
+
+func Test_OIDC_GetUserInfo_InvalidIDToken(t *testing.T) {
+	issuer := newTestIssuer(t)
+	defer issuer.Close()
+	p, err := configureOIDC("keycloak", map[string]string{"issuer": issuer.URL, "client_id": "client42"})
+	NoError(t, err)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	NoError(t, err)
+	forged := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss": issuer.URL, "sub": "1234", "aud": "client42", "nonce": "the-nonce", "exp": time.Now().Add(time.Minute).Unix(),
+	})
+	forged.Header["kid"] = "key1"
+	forgedToken, err := forged.SignedString(otherKey)
+	NoError(t, err)
+	hmacToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"iss": issuer.URL, "sub": "1234", "aud": "client42", "nonce": "the-nonce", "exp": time.Now().Add(time.Minute).Unix(),
+	}).SignedString([]byte("secret"))
+	NoError(t, err)
+	for name, idToken := range map[string]string{
+		"missing":       "",
+		"forged":        forgedToken,
+		"hmac":          hmacToken,
+		"unknown kid":   issuer.idToken(t, "unknown", nil),
+		"wrong issuer":  issuer.idToken(t, "key1", jwt.MapClaims{"iss": "https://evil.example.com"}),
+		"wrong aud":     issuer.idToken(t, "key1", jwt.MapClaims{"aud": []string{"other"}}),
+		"expired":       issuer.idToken(t, "key1", jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()}),
+		"wrong nonce":   issuer.idToken(t, "key1", jwt.MapClaims{"nonce": "replayed"}),
+		"other subject": issuer.idToken(t, "key1", jwt.MapClaims{"sub": "5678"}),
+	} {
+		_, _, err := p.GetUserInfo(TokenInfo{AccessToken: "the-access-token", IDToken: idToken, Nonce: "the-nonce"})
+		Error(t, err, name)
+	}
+	// audience as list
+	_, _, err = p.GetUserInfo(TokenInfo{
+		AccessToken: "the-access-token",
+		IDToken:     issuer.idToken(t, "key1", jwt.MapClaims{"aud": []string{"other", "client42"}}),
+		Nonce:       "the-nonce",
+	})
+	NoError(t, err)
+}
@@ -16,6 +16,14 @@ type Provider struct {
 	// Provider specific Implementation for fetching the user information
 	// Possible keys in the returned map are: username, email, name
 	GetUserInfo func(token TokenInfo) (u model.UserInfo, rawUserJson string, err error)
+	// Send a nonce with the authorization request, which is passed to GetUserInfo by TokenInfo.Nonce
+	UseNonce bool
+	// The provider supports PKCE (RFC 7636), so it is used by default
+	SupportsPKCE bool
+	// Optional hook for providers, which need instance specific settings,
+	// e.g. endpoints discovered from an issuer url.
+	// It returns the provider to use for the named configuration with the given options.
+	Configure func(name string, opts map[string]string) (Provider, error)
 }
 
 var provider = map[string]Provider{}
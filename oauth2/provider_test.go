@@ -22,11 +22,19 @@ func Test_ProviderRegistration(t *testing.T) {
 	gitlab, exist := GetProvider("gitlab")
 	NotNil(t, gitlab)
 	True(t, exist)
+	oidc, exist := GetProvider("oidc")
+	NotNil(t, oidc)
+	True(t, exist)
+	azure, exist := GetProvider("azure")
+	NotNil(t, azure)
+	True(t, exist)
 	list := ProviderList()
-	Equal(t, 5, len(list))
+	Equal(t, 7, len(list))
 	Contains(t, list, "github")
 	Contains(t, list, "google")
 	Contains(t, list, "bitbucket")
 	Contains(t, list, "facebook")
 	Contains(t, list, "gitlab")
+	Contains(t, list, "oidc")
+	Contains(t, list, "azure")
 }
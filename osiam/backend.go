@@ -61,3 +61,8 @@ func (b *Backend) Authenticate(username, password string) (bool, model.UserInfo,
 	}
 	return true, userInfo, nil
 }
+
+// Checks, that OSIAM is reachable
+func (b *Backend) CheckHealth() error {
+	return b.client.CheckHealth()
+}
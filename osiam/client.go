@@ -7,8 +7,12 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 )
 
+// The timeout of a health check, so that a hanging endpoint does not block the check
+var healthCheckTimeout = 5 * time.Second
+
 // Wrapper for the osiam API
 type Client struct {
 	Endpoint     string
@@ -78,3 +82,17 @@ func (c *Client) GetTokenByPassword(username, password string, scopes ...string)
 func isJSON(contentType string) bool {
 	return strings.HasPrefix(contentType, "application/json")
 }
+
+// Checks, that the OSIAM endpoint is reachable and answers without a server error
+func (c *Client) CheckHealth() error {
+	client := &http.Client{Timeout: healthCheckTimeout}
+	res, err := client.Get(c.Endpoint)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("Osiam endpoint answered with http status %v", res.StatusCode)
+	}
+	return nil
+}
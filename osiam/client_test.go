@@ -127,3 +127,31 @@ func osiamMockHandler(w http.ResponseWriter, r *http.Request) {
                 "access_token" : "59f39ef8-1dc3-4c0d-8dea-c9597ef0a8ef",
                 "scope" : "ME"}`)
 }
+
+func TestClient_CheckHealth(t *testing.T) {
+	status := http.StatusNotFound
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	client := NewClient(server.URL, "example-client", "secret")
+	NoError(t, client.CheckHealth())
+	status = http.StatusServiceUnavailable
+	Error(t, client.CheckHealth())
+	server.Close()
+	Error(t, client.CheckHealth())
+}
+
+func TestClient_CheckHealth_Timeout(t *testing.T) {
+	defer func(timeout time.Duration) { healthCheckTimeout = timeout }(healthCheckTimeout)
+	healthCheckTimeout = 50 * time.Millisecond
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+	client := NewClient(server.URL, "example-client", "secret")
+	start := time.Now()
+	Error(t, client.CheckHealth())
+	Less(t, time.Since(start), time.Second)
+}
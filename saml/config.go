@@ -0,0 +1,184 @@
+package saml
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	gosaml "github.com/crewjam/saml"
+	"github.com/crewjam/saml/samlsp"
+)
+
+const (
+	// Signature method for the authentication requests, if the service provider has a key
+	signatureMethodRSASHA256 = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+	defaultTimeout           = 5 * time.Second
+)
+
+// Default names of the attributes, which are mapped into the user info.
+// The first attribute found, by its name or friendly name, is used.
+var (
+	defaultEmailAttributes  = []string{"email", "mail", "emailAddress", "urn:oid:0.9.2342.19200300.100.1.3"}
+	defaultNameAttributes   = []string{"displayName", "name", "cn", "urn:oid:2.16.840.1.113730.3.1.241"}
+	defaultGroupsAttributes = []string{"groups", "memberOf", "eduPersonAffiliation", "urn:oid:1.3.6.1.4.1.5923.1.1.1.1"}
+)
+
+// Configuration of a SAML 2.0 service provider for one identity provider
+type Config struct {
+	// Name of the configuration, which is the last segment of its path and the origin of the users
+	Name string
+	// Binding of the authentication request (gosaml.HTTPRedirectBinding or gosaml.HTTPPostBinding)
+	Binding string
+	// Entity id and assertion consumer service url, which are derived from the base url, if not set.
+	// They are never taken from the request, because its host headers are set by the client.
+	EntityID string
+	AcsURL   string
+	// Attribute for the username, the NameID of the subject, if empty
+	SubAttribute     string
+	EmailAttributes  []string
+	NameAttributes   []string
+	GroupsAttributes []string
+	// The service provider with its urls and the metadata of the identity provider
+	ServiceProvider gosaml.ServiceProvider
+}
+
+// Creates the configuration from the options.
+// The urls of the service provider are given by base_url or by entity_id and acs_url.
+// The metadata of the identity provider is read from the file idp_metadata_file or fetched from idp_metadata_url.
+func NewConfig(name string, opts map[string]string) (Config, error) {
+	cfg := Config{
+		Name:             name,
+		EntityID:         opts["entity_id"],
+		AcsURL:           opts["acs_url"],
+		SubAttribute:     opts["sub_attribute"],
+		EmailAttributes:  attributeNames(opts["email_attribute"], defaultEmailAttributes),
+		NameAttributes:   attributeNames(opts["name_attribute"], defaultNameAttributes),
+		GroupsAttributes: attributeNames(opts["groups_attribute"], defaultGroupsAttributes),
+	}
+	if err := cfg.configureURLs(opts["base_url"]); err != nil {
+		return Config{}, err
+	}
+	idpMetadata, err := readIDPMetadata(opts)
+	if err != nil {
+		return Config{}, fmt.Errorf("error on reading the idp metadata for saml config %v: %v", name, err)
+	}
+	cfg.ServiceProvider.IDPMetadata = idpMetadata
+	if v, exist := opts["allow_idp_initiated"]; exist {
+		if cfg.ServiceProvider.AllowIDPInitiated, err = strconv.ParseBool(v); err != nil {
+			return Config{}, fmt.Errorf("invalid value for parameter allow_idp_initiated: %v", v)
+		}
+	}
+	if err := cfg.configureKeyPair(opts["cert"], opts["key"]); err != nil {
+		return Config{}, err
+	}
+	switch opts["binding"] {
+	case "":
+		cfg.Binding = gosaml.HTTPRedirectBinding
+		if cfg.ServiceProvider.GetSSOBindingLocation(gosaml.HTTPRedirectBinding) == "" {
+			cfg.Binding = gosaml.HTTPPostBinding
+		}
+	case "redirect":
+		cfg.Binding = gosaml.HTTPRedirectBinding
+	case "post":
+		cfg.Binding = gosaml.HTTPPostBinding
+	default:
+		return Config{}, fmt.Errorf("invalid value for parameter binding: %v, has to be redirect or post", opts["binding"])
+	}
+	if cfg.ServiceProvider.GetSSOBindingLocation(cfg.Binding) == "" {
+		return Config{}, fmt.Errorf("the idp of saml config %v has no single sign on service for the binding %v", name, cfg.Binding)
+	}
+	return cfg, nil
+}
+
+// Sets the urls of the service provider. The base url is the external url of the configuration,
+// e.g. https://auth.example.com/login/saml/corp, with the metadata and the assertion consumer service below it.
+// The urls are fixed, so that an assertion for another service provider of the identity provider
+// can not pass the audience and destination checks by forged host headers.
+func (cfg *Config) configureURLs(baseURL string) error {
+	if baseURL != "" {
+		base, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+		if err != nil || !base.IsAbs() {
+			return fmt.Errorf("invalid value for parameter base_url of saml config %v: %v", cfg.Name, baseURL)
+		}
+		cfg.ServiceProvider.MetadataURL = *base.JoinPath(metadataPath)
+		if cfg.EntityID == "" {
+			cfg.EntityID = cfg.ServiceProvider.MetadataURL.String()
+		}
+		if cfg.AcsURL == "" {
+			cfg.AcsURL = base.JoinPath(acsPath).String()
+		}
+	}
+	if cfg.EntityID == "" || cfg.AcsURL == "" {
+		return fmt.Errorf("saml config %v needs the parameter base_url or the parameters entity_id and acs_url", cfg.Name)
+	}
+	acsURL, err := url.Parse(cfg.AcsURL)
+	if err != nil || !acsURL.IsAbs() {
+		return fmt.Errorf("invalid value for parameter acs_url of saml config %v: %v", cfg.Name, cfg.AcsURL)
+	}
+	cfg.ServiceProvider.EntityID = cfg.EntityID
+	cfg.ServiceProvider.AcsURL = *acsURL
+	return nil
+}
+
+func readIDPMetadata(opts map[string]string) (*gosaml.EntityDescriptor, error) {
+	if file, exist := opts["idp_metadata_file"]; exist {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		return samlsp.ParseMetadata(b)
+	}
+	metadataURL, exist := opts["idp_metadata_url"]
+	if !exist {
+		return nil, fmt.Errorf("missing parameter idp_metadata_url or idp_metadata_file")
+	}
+	u, err := url.Parse(metadataURL)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+	return samlsp.FetchMetadata(ctx, &http.Client{Timeout: defaultTimeout}, *u)
+}
+
+// Loads the optional key pair of the service provider,
+// which is used for signing the authentication requests and decrypting the assertions.
+func (cfg *Config) configureKeyPair(certFile, keyFile string) error {
+	if certFile == "" && keyFile == "" {
+		return nil
+	}
+	if certFile == "" || keyFile == "" {
+		return fmt.Errorf("the parameters cert and key have to be set both")
+	}
+	keyPair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	key, ok := keyPair.PrivateKey.(*rsa.PrivateKey)
+	if !ok {
+		return fmt.Errorf("the key of saml config %v has to be an rsa key", cfg.Name)
+	}
+	cert, err := x509.ParseCertificate(keyPair.Certificate[0])
+	if err != nil {
+		return err
+	}
+	cfg.ServiceProvider.Key = key
+	cfg.ServiceProvider.Certificate = cert
+	cfg.ServiceProvider.SignatureMethod = signatureMethodRSASHA256
+	return nil
+}
+
+func attributeNames(option string, defaults []string) []string {
+	if option == "" {
+		return defaults
+	}
+	return []string{option}
+}
@@ -0,0 +1,109 @@
+package saml
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	gosaml "github.com/crewjam/saml"
+	. "github.com/stretchr/testify/assert"
+)
+
+func writeTempFile(t *testing.T, content []byte) string {
+	f, err := os.CreateTemp("", "")
+	NoError(t, err)
+	defer f.Close()
+	_, err = f.Write(content)
+	NoError(t, err)
+	t.Cleanup(func() { os.Remove(f.Name()) })
+	return f.Name()
+}
+
+func Test_NewConfig(t *testing.T) {
+	i := newTestIDP(t)
+	cfg, err := NewConfig("corp", map[string]string{"idp_metadata_url": i.URL + "/metadata", "base_url": "https://auth.example.com/login/saml/corp/"})
+	NoError(t, err)
+	Equal(t, "corp", cfg.Name)
+	Equal(t, "https://auth.example.com/login/saml/corp/metadata", cfg.ServiceProvider.EntityID)
+	Equal(t, "https://auth.example.com/login/saml/corp/metadata", cfg.ServiceProvider.MetadataURL.String())
+	Equal(t, "https://auth.example.com/login/saml/corp/acs", cfg.ServiceProvider.AcsURL.String())
+	Equal(t, gosaml.HTTPRedirectBinding, cfg.Binding)
+	Equal(t, "", cfg.SubAttribute)
+	Equal(t, defaultEmailAttributes, cfg.EmailAttributes)
+	Equal(t, []string{"memberOf"}, attributeNames("memberOf", defaultGroupsAttributes))
+	Equal(t, i.URL+"/metadata", cfg.ServiceProvider.IDPMetadata.EntityID)
+	False(t, cfg.ServiceProvider.AllowIDPInitiated)
+	Nil(t, cfg.ServiceProvider.Key)
+	Empty(t, cfg.ServiceProvider.SignatureMethod)
+}
+
+func Test_NewConfig_MetadataFile(t *testing.T) {
+	i := newTestIDP(t)
+	cfg, err := NewConfig("corp", map[string]string{
+		"idp_metadata_file":   writeMetadataFile(t, i),
+		"entity_id":           "urn:logsrv",
+		"acs_url":             "https://auth.example.com/login/saml/corp/acs",
+		"binding":             "post",
+		"allow_idp_initiated": "true",
+	})
+	NoError(t, err)
+	Equal(t, gosaml.HTTPPostBinding, cfg.Binding)
+	True(t, cfg.ServiceProvider.AllowIDPInitiated)
+	Equal(t, "urn:logsrv", cfg.ServiceProvider.EntityID)
+	Equal(t, "https://auth.example.com/login/saml/corp/acs", cfg.ServiceProvider.AcsURL.String())
+}
+
+func writeMetadataFile(t *testing.T, i *testIDP) string {
+	w := httptest.NewRecorder()
+	i.idp.ServeMetadata(w, httptest.NewRequest("GET", "/metadata", nil))
+	return writeTempFile(t, w.Body.Bytes())
+}
+
+func Test_NewConfig_Errors(t *testing.T) {
+	i := newTestIDP(t)
+	metadataURL := i.URL + "/metadata"
+	baseURL := "https://auth.example.com/login/saml/corp"
+	for _, opts := range []map[string]string{
+		{"base_url": baseURL},
+		{"base_url": baseURL, "idp_metadata_url": i.URL + "/unknown"},
+		{"base_url": baseURL, "idp_metadata_file": "/does/not/exist"},
+		{"base_url": baseURL, "idp_metadata_url": metadataURL, "binding": "artifact"},
+		{"base_url": baseURL, "idp_metadata_url": metadataURL, "allow_idp_initiated": "maybe"},
+		{"base_url": baseURL, "idp_metadata_url": metadataURL, "cert": "/cert.pem"},
+		{"base_url": baseURL, "idp_metadata_url": metadataURL, "cert": "/cert.pem", "key": "/key.pem"},
+		// the urls of the service provider have to be configured
+		{"idp_metadata_url": metadataURL},
+		{"idp_metadata_url": metadataURL, "entity_id": "urn:logsrv"},
+		{"idp_metadata_url": metadataURL, "acs_url": baseURL + "/acs"},
+		{"idp_metadata_url": metadataURL, "entity_id": "urn:logsrv", "acs_url": "/login/saml/corp/acs"},
+		{"idp_metadata_url": metadataURL, "base_url": "/login/saml/corp"},
+	} {
+		_, err := NewConfig("corp", opts)
+		Error(t, err, "%v", opts)
+	}
+}
+
+func Test_Manager_SignedRequests(t *testing.T) {
+	i := newTestIDP(t)
+	key, cert := testKeyPair(t)
+	certFile := writeTempFile(t, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+	keyFile := writeTempFile(t, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+	m := newTestManager(t, i, map[string]string{"cert": certFile, "key": keyFile})
+	Equal(t, cert, m.GetConfigs()["corp"].ServiceProvider.Certificate)
+	w := httptest.NewRecorder()
+	_, _, _, err := m.Handle(w, httptest.NewRequest("GET", testLoginURL, nil))
+	NoError(t, err)
+	location, err := url.Parse(w.Header().Get("Location"))
+	NoError(t, err)
+	Equal(t, signatureMethodRSASHA256, location.Query().Get("SigAlg"))
+	NotEmpty(t, location.Query().Get("Signature"))
+	// the certificate is published in the metadata
+	w = httptest.NewRecorder()
+	_, _, _, err = m.Handle(w, httptest.NewRequest("GET", testLoginURL+"/metadata", nil))
+	NoError(t, err)
+	True(t, strings.Contains(w.Body.String(), `AuthnRequestsSigned="true"`), w.Body.String())
+}
@@ -0,0 +1,254 @@
+package saml
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	gosaml "github.com/crewjam/saml"
+	"github.com/pchchv/logsrv/model"
+)
+
+const (
+	// Path segment of the configurations, e.g. /login/saml/<name>
+	samlPath = "saml"
+	// Endpoints of a configuration below its path
+	acsPath      = "acs"
+	metadataPath = "metadata"
+
+	requestCookieName = "samlRequest"
+	contentTypeHTML   = "text/html; charset=utf-8"
+	contentTypeXML    = "application/samlmetadata+xml"
+)
+
+// Returned, if the response of the identity provider is not valid,
+// e.g. not signed by the identity provider, expired or not for this service provider.
+type ResponseError struct {
+	Err error
+}
+
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("invalid saml response: %v", e.Err)
+}
+
+func (e *ResponseError) Unwrap() error {
+	return e.Err
+}
+
+// Responsible for handling user requests in the SAML flow
+// Must pick the right configuration, start the authentication request and consume the assertion
+type Manager struct {
+	configs map[string]Config
+}
+
+// NewManager creates a new Manager
+func NewManager() *Manager {
+	return &Manager{
+		configs: map[string]Config{},
+	}
+}
+
+// Managing the SAML flow.
+// Dependent on the path, the metadata of the service provider is served,
+// the authentication request is sent to the identity provider
+// or the assertion of the identity provider is consumed.
+// Return parameters:
+//
+//	responded - true, if the response was written, e.g. the metadata or the start of the flow
+//	authenticated - if the authentication was successful or not
+//	userInfo - the user info from the assertion in case of a successful authentication
+//	err - an error, a *ResponseError for an invalid response of the identity provider
+func (manager *Manager) Handle(w http.ResponseWriter, r *http.Request) (
+	responded bool,
+	authenticated bool,
+	userInfo model.UserInfo,
+	err error,
+) {
+	cfg, err := manager.GetConfigFromRequest(r)
+	if err != nil {
+		return false, false, model.UserInfo{}, err
+	}
+	basePath, _, endpoint := splitPath(r.URL.Path)
+	switch endpoint {
+	case metadataPath:
+		if err := writeMetadata(cfg, w); err != nil {
+			return false, false, model.UserInfo{}, err
+		}
+		return true, false, model.UserInfo{}, nil
+	case acsPath:
+		userInfo, err := consumeAssertion(cfg, r)
+		if err != nil {
+			return false, false, model.UserInfo{}, err
+		}
+		setRequestCookie(w, cfg, basePath, "", -1)
+		return false, true, userInfo, nil
+	}
+	if err := startFlow(cfg, w, basePath); err != nil {
+		return false, false, model.UserInfo{}, err
+	}
+	return true, false, model.UserInfo{}, nil
+}
+
+// Returns the SAML configuration matching the current path
+func (manager *Manager) GetConfigFromRequest(r *http.Request) (Config, error) {
+	_, name, _ := splitPath(r.URL.Path)
+	cfg, exist := manager.configs[name]
+	if name == "" || !exist {
+		return Config{}, fmt.Errorf("no saml configuration for %v", r.URL.Path)
+	}
+	return cfg, nil
+}
+
+// AddConfig for an identity provider, registered by the name
+func (manager *Manager) AddConfig(name string, opts map[string]string) error {
+	cfg, err := NewConfig(name, opts)
+	if err != nil {
+		return err
+	}
+	manager.configs[name] = cfg
+	return nil
+}
+
+// GetConfigs of the manager
+func (manager *Manager) GetConfigs() map[string]Config {
+	return manager.configs
+}
+
+// Sends the authentication request to the identity provider by the configured binding.
+// The id of the request is stored in a cookie, to verify the response against it.
+func startFlow(cfg Config, w http.ResponseWriter, basePath string) error {
+	sp := &cfg.ServiceProvider
+	req, err := sp.MakeAuthenticationRequest(sp.GetSSOBindingLocation(cfg.Binding), cfg.Binding, gosaml.HTTPPostBinding)
+	if err != nil {
+		return err
+	}
+	if cfg.Binding == gosaml.HTTPRedirectBinding {
+		redirectURL, err := req.Redirect("", sp)
+		if err != nil {
+			return err
+		}
+		setRequestCookie(w, cfg, basePath, req.ID, 60*10) // 10 minutes
+		w.Header().Set("Location", redirectURL.String())
+		w.WriteHeader(http.StatusFound)
+		return nil
+	}
+	setRequestCookie(w, cfg, basePath, req.ID, 60*10) // 10 minutes
+	w.Header().Set("Content-Type", contentTypeHTML)
+	_, err = w.Write(req.Post(""))
+	return err
+}
+
+// Verifies the assertion posted by the identity provider against its metadata and maps it to the user info
+func consumeAssertion(cfg Config, r *http.Request) (model.UserInfo, error) {
+	if r.Method != http.MethodPost {
+		return model.UserInfo{}, &ResponseError{Err: fmt.Errorf("method %v is not allowed", r.Method)}
+	}
+	if err := r.ParseForm(); err != nil {
+		return model.UserInfo{}, &ResponseError{Err: err}
+	}
+	requestIDs := []string{}
+	if c, err := r.Cookie(requestCookieName); err == nil && c.Value != "" {
+		requestIDs = append(requestIDs, c.Value)
+	}
+	assertion, err := cfg.ServiceProvider.ParseResponse(r, requestIDs)
+	if err != nil {
+		// the error of crewjam/saml hides the reason
+		if invalidResponse, ok := err.(*gosaml.InvalidResponseError); ok {
+			err = invalidResponse.PrivateErr
+		}
+		return model.UserInfo{}, &ResponseError{Err: err}
+	}
+	return cfg.userInfo(assertion)
+}
+
+// Maps the subject and the attributes of the assertion into the user info
+func (cfg Config) userInfo(assertion *gosaml.Assertion) (model.UserInfo, error) {
+	userInfo := model.UserInfo{
+		Email:  firstAttributeValue(assertion, cfg.EmailAttributes),
+		Name:   firstAttributeValue(assertion, cfg.NameAttributes),
+		Groups: attributeValues(assertion, cfg.GroupsAttributes),
+		Origin: cfg.Name,
+	}
+	if cfg.SubAttribute != "" {
+		userInfo.Sub = firstAttributeValue(assertion, []string{cfg.SubAttribute})
+	} else if assertion.Subject != nil && assertion.Subject.NameID != nil {
+		userInfo.Sub = assertion.Subject.NameID.Value
+	}
+	if userInfo.Sub == "" {
+		return model.UserInfo{}, &ResponseError{Err: errors.New("the assertion has no subject")}
+	}
+	return userInfo, nil
+}
+
+// Returns the values of the first attribute, which matches one of the names by its name or friendly name
+func attributeValues(assertion *gosaml.Assertion, names []string) []string {
+	for _, name := range names {
+		for _, statement := range assertion.AttributeStatements {
+			for _, attribute := range statement.Attributes {
+				if attribute.Name != name && attribute.FriendlyName != name {
+					continue
+				}
+				values := []string{}
+				for _, v := range attribute.Values {
+					if v.Value != "" {
+						values = append(values, v.Value)
+					}
+				}
+				if len(values) > 0 {
+					return values
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func firstAttributeValue(assertion *gosaml.Assertion, names []string) string {
+	if values := attributeValues(assertion, names); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func writeMetadata(cfg Config, w http.ResponseWriter) error {
+	b, err := xml.MarshalIndent(cfg.ServiceProvider.Metadata(), "", "  ")
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", contentTypeXML)
+	_, err = w.Write(b)
+	return err
+}
+
+// The identity provider posts the response cross site,
+// so the cookie needs SameSite=None, which is only accepted for secure cookies.
+func setRequestCookie(w http.ResponseWriter, cfg Config, basePath, requestID string, maxAge int) {
+	cookie := &http.Cookie{
+		Name:     requestCookieName,
+		Value:    requestID,
+		Path:     basePath,
+		MaxAge:   maxAge,
+		HttpOnly: true,
+	}
+	if cfg.ServiceProvider.AcsURL.Scheme == "https" {
+		cookie.Secure = true
+		cookie.SameSite = http.SameSiteNoneMode
+	}
+	http.SetCookie(w, cookie)
+}
+
+// Splits the path into the path of the configuration, its name and the endpoint,
+// e.g. /login/saml/corp/acs into /login/saml/corp, corp and acs
+func splitPath(p string) (basePath, name, endpoint string) {
+	parts := strings.Split(strings.TrimSuffix(p, "/"), "/")
+	n := len(parts)
+	if n >= 3 && parts[n-3] == samlPath && (parts[n-1] == acsPath || parts[n-1] == metadataPath) {
+		return strings.Join(parts[:n-1], "/"), parts[n-2], parts[n-1]
+	}
+	if n >= 2 && parts[n-2] == samlPath {
+		return strings.Join(parts, "/"), parts[n-1], ""
+	}
+	return "", "", ""
+}
@@ -0,0 +1,332 @@
+package saml
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"html"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	gosaml "github.com/crewjam/saml"
+	"github.com/crewjam/saml/logger"
+	"github.com/pchchv/logsrv/model"
+	. "github.com/stretchr/testify/assert"
+)
+
+const testLoginURL = "http://logsrv.example/login/saml/corp"
+
+var formValueRegexp = regexp.MustCompile(`name="(SAMLRequest|SAMLResponse|RelayState)" value="([^"]*)"`)
+var formActionRegexp = regexp.MustCompile(`action="([^"]*)"`)
+
+// In-process identity provider, which authenticates every request as the session user
+type testIDP struct {
+	*httptest.Server
+	idp     *gosaml.IdentityProvider
+	session *gosaml.Session
+	// metadata of the service provider
+	sp *gosaml.EntityDescriptor
+}
+
+func newTestIDP(t *testing.T) *testIDP {
+	key, cert := testKeyPair(t)
+	i := &testIDP{
+		session: &gosaml.Session{
+			ID:             "session-id",
+			NameID:         "bob",
+			UserEmail:      "bob@example.com",
+			UserCommonName: "Bob",
+			Groups:         []string{"admin", "dev"},
+			CustomAttributes: []gosaml.Attribute{
+				{Name: "email", Values: []gosaml.AttributeValue{{Value: "bob@example.org"}}},
+			},
+		},
+	}
+	mux := http.NewServeMux()
+	i.Server = httptest.NewServer(mux)
+	base, _ := url.Parse(i.URL)
+	i.idp = &gosaml.IdentityProvider{
+		Key:                     key,
+		Certificate:             cert,
+		Logger:                  logger.DefaultLogger,
+		MetadataURL:             *base.JoinPath("metadata"),
+		SSOURL:                  *base.JoinPath("sso"),
+		ServiceProviderProvider: i,
+		SessionProvider:         i,
+	}
+	mux.HandleFunc("/metadata", i.idp.ServeMetadata)
+	mux.HandleFunc("/sso", i.idp.ServeSSO)
+	t.Cleanup(i.Close)
+	return i
+}
+
+func (i *testIDP) GetServiceProvider(r *http.Request, serviceProviderID string) (*gosaml.EntityDescriptor, error) {
+	return i.sp, nil
+}
+
+func (i *testIDP) GetSession(w http.ResponseWriter, r *http.Request, req *gosaml.IdpAuthnRequest) *gosaml.Session {
+	return i.session
+}
+
+func testKeyPair(t *testing.T) (*rsa.PrivateKey, *x509.Certificate) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	NoError(t, err)
+	return key, cert
+}
+
+func newTestManager(t *testing.T, i *testIDP, opts map[string]string) *Manager {
+	m := NewManager()
+	if opts == nil {
+		opts = map[string]string{}
+	}
+	opts["idp_metadata_url"] = i.URL + "/metadata"
+	opts["base_url"] = testLoginURL
+	NoError(t, m.AddConfig("corp", opts))
+	cfg, err := m.GetConfigFromRequest(httptest.NewRequest("GET", testLoginURL, nil))
+	NoError(t, err)
+	i.sp = cfg.ServiceProvider.Metadata()
+	return m
+}
+
+func formValues(body string) url.Values {
+	values := url.Values{}
+	for _, m := range formValueRegexp.FindAllStringSubmatch(body, -1) {
+		values.Set(m[1], html.UnescapeString(m[2]))
+	}
+	return values
+}
+
+// Sends the authentication request to the identity provider and returns the response form of the identity provider
+func loginAtIDP(t *testing.T, authnRequest *http.Request) url.Values {
+	resp, err := http.DefaultClient.Do(authnRequest)
+	NoError(t, err)
+	defer resp.Body.Close()
+	Equal(t, 200, resp.StatusCode)
+	b, err := io.ReadAll(resp.Body)
+	NoError(t, err)
+	body := string(b)
+	action := formActionRegexp.FindStringSubmatch(body)
+	NotNil(t, action)
+	Equal(t, testLoginURL+"/acs", html.UnescapeString(action[1]))
+	return formValues(body)
+}
+
+func acsRequest(form url.Values, cookies []*http.Cookie) *http.Request {
+	r := httptest.NewRequest("POST", testLoginURL+"/acs", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for _, c := range cookies {
+		r.AddCookie(c)
+	}
+	return r
+}
+
+func Test_Manager_RedirectBinding(t *testing.T) {
+	i := newTestIDP(t)
+	m := newTestManager(t, i, nil)
+	// start flow
+	w := httptest.NewRecorder()
+	responded, authenticated, userInfo, err := m.Handle(w, httptest.NewRequest("GET", testLoginURL, nil))
+	NoError(t, err)
+	True(t, responded)
+	False(t, authenticated)
+	Equal(t, model.UserInfo{}, userInfo)
+	Equal(t, 302, w.Code)
+	location := w.Header().Get("Location")
+	True(t, strings.HasPrefix(location, i.URL+"/sso?SAMLRequest="), location)
+	cookies := w.Result().Cookies()
+	Equal(t, 1, len(cookies))
+	Equal(t, requestCookieName, cookies[0].Name)
+	Equal(t, "/login/saml/corp", cookies[0].Path)
+	NotEmpty(t, cookies[0].Value)
+	// login at the identity provider
+	authnRequest, _ := http.NewRequest("GET", location, nil)
+	form := loginAtIDP(t, authnRequest)
+	// consume the assertion
+	w = httptest.NewRecorder()
+	responded, authenticated, userInfo, err = m.Handle(w, acsRequest(form, cookies))
+	NoError(t, err)
+	False(t, responded)
+	True(t, authenticated)
+	Equal(t, model.UserInfo{
+		Sub:    "bob",
+		Email:  "bob@example.org",
+		Name:   "Bob",
+		Groups: []string{"admin", "dev"},
+		Origin: "corp",
+	}, userInfo)
+	// the request cookie is deleted
+	Equal(t, requestCookieName, w.Result().Cookies()[0].Name)
+	Equal(t, -1, w.Result().Cookies()[0].MaxAge)
+}
+
+func Test_Manager_PostBinding(t *testing.T) {
+	i := newTestIDP(t)
+	m := newTestManager(t, i, map[string]string{"binding": "post", "sub_attribute": "email", "groups_attribute": "none"})
+	w := httptest.NewRecorder()
+	responded, _, _, err := m.Handle(w, httptest.NewRequest("GET", testLoginURL, nil))
+	NoError(t, err)
+	True(t, responded)
+	Equal(t, 200, w.Code)
+	Equal(t, contentTypeHTML, w.Header().Get("Content-Type"))
+	action := formActionRegexp.FindStringSubmatch(w.Body.String())
+	NotNil(t, action)
+	Equal(t, i.URL+"/sso", action[1])
+	authnRequest, _ := http.NewRequest("POST", i.URL+"/sso", strings.NewReader(formValues(w.Body.String()).Encode()))
+	authnRequest.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	form := loginAtIDP(t, authnRequest)
+	_, authenticated, userInfo, err := m.Handle(httptest.NewRecorder(), acsRequest(form, w.Result().Cookies()))
+	NoError(t, err)
+	True(t, authenticated)
+	Equal(t, "bob@example.org", userInfo.Sub)
+	Nil(t, userInfo.Groups)
+}
+
+func Test_Manager_InvalidResponses(t *testing.T) {
+	i := newTestIDP(t)
+	m := newTestManager(t, i, nil)
+	w := httptest.NewRecorder()
+	_, _, _, err := m.Handle(w, httptest.NewRequest("GET", testLoginURL, nil))
+	NoError(t, err)
+	cookies := w.Result().Cookies()
+	authnRequest, _ := http.NewRequest("GET", w.Header().Get("Location"), nil)
+	form := loginAtIDP(t, authnRequest)
+	tests := []struct {
+		name    string
+		form    url.Values
+		cookies []*http.Cookie
+	}{
+		{"no request cookie", form, nil},
+		{"other request id", form, []*http.Cookie{{Name: requestCookieName, Value: "id-other"}}},
+		{"no response", url.Values{}, cookies},
+		{"tampered response", url.Values{"SAMLResponse": {tamper(t, form.Get("SAMLResponse"))}}, cookies},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			responded, authenticated, _, err := m.Handle(httptest.NewRecorder(), acsRequest(test.form, test.cookies))
+			False(t, responded)
+			False(t, authenticated)
+			var responseErr *ResponseError
+			True(t, errors.As(err, &responseErr), "%v", err)
+		})
+	}
+	// the assertion consumer service accepts posts only
+	_, authenticated, _, err := m.Handle(httptest.NewRecorder(), httptest.NewRequest("GET", testLoginURL+"/acs", nil))
+	False(t, authenticated)
+	IsType(t, &ResponseError{}, err)
+}
+
+func Test_Manager_UntrustedIDP(t *testing.T) {
+	i := newTestIDP(t)
+	m := newTestManager(t, i, nil)
+	w := httptest.NewRecorder()
+	_, _, _, err := m.Handle(w, httptest.NewRequest("GET", testLoginURL, nil))
+	NoError(t, err)
+	// the assertion is signed by another key, than the one of the metadata
+	i.idp.Key, i.idp.Certificate = testKeyPair(t)
+	authnRequest, _ := http.NewRequest("GET", w.Header().Get("Location"), nil)
+	form := loginAtIDP(t, authnRequest)
+	_, authenticated, _, err := m.Handle(httptest.NewRecorder(), acsRequest(form, w.Result().Cookies()))
+	False(t, authenticated)
+	IsType(t, &ResponseError{}, err)
+}
+
+// Replaces the subject of the response, which invalidates the signature
+func tamper(t *testing.T, response string) string {
+	b, err := base64.StdEncoding.DecodeString(response)
+	NoError(t, err)
+	tampered := strings.Replace(string(b), ">bob<", ">alice<", 1)
+	NotEqual(t, string(b), tampered)
+	return base64.StdEncoding.EncodeToString([]byte(tampered))
+}
+
+func Test_Manager_Metadata(t *testing.T) {
+	i := newTestIDP(t)
+	m := newTestManager(t, i, map[string]string{"entity_id": "urn:logsrv"})
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "http://logsrv.example/login/saml/corp/metadata", nil)
+	// the urls are not taken from the headers of the client
+	r.Header.Set("X-Forwarded-Proto", "https")
+	r.Header.Set("X-Forwarded-Host", "evil.example.com")
+	responded, authenticated, _, err := m.Handle(w, r)
+	NoError(t, err)
+	True(t, responded)
+	False(t, authenticated)
+	Equal(t, contentTypeXML, w.Header().Get("Content-Type"))
+	metadata := gosaml.EntityDescriptor{}
+	NoError(t, xml.Unmarshal(w.Body.Bytes(), &metadata))
+	Equal(t, "urn:logsrv", metadata.EntityID)
+	Equal(t, 1, len(metadata.SPSSODescriptors))
+	acs := metadata.SPSSODescriptors[0].AssertionConsumerServices
+	Equal(t, testLoginURL+"/acs", acs[0].Location)
+	Equal(t, gosaml.HTTPPostBinding, acs[0].Binding)
+}
+
+func Test_Manager_UnknownConfig(t *testing.T) {
+	m := NewManager()
+	for _, p := range []string{"/login/saml/corp", "/login/corp", "/login/saml", "/login/saml/corp/acs"} {
+		_, err := m.GetConfigFromRequest(httptest.NewRequest("GET", p, nil))
+		Error(t, err, p)
+		responded, authenticated, _, err := m.Handle(httptest.NewRecorder(), httptest.NewRequest("GET", p, nil))
+		False(t, responded)
+		False(t, authenticated)
+		Error(t, err, p)
+	}
+}
+
+func Test_splitPath(t *testing.T) {
+	tests := []struct {
+		path, basePath, name, endpoint string
+	}{
+		{"/login/saml/corp", "/login/saml/corp", "corp", ""},
+		{"/login/saml/corp/", "/login/saml/corp", "corp", ""},
+		{"/login/saml/corp/acs", "/login/saml/corp", "corp", "acs"},
+		{"/login/saml/corp/metadata", "/login/saml/corp", "corp", "metadata"},
+		{"/login/saml/acs", "/login/saml/acs", "acs", ""},
+		{"/login/saml/corp/other", "", "", ""},
+		{"/login/corp", "", "", ""},
+	}
+	for _, test := range tests {
+		basePath, name, endpoint := splitPath(test.path)
+		Equal(t, test.basePath, basePath, test.path)
+		Equal(t, test.name, name, test.path)
+		Equal(t, test.endpoint, endpoint, test.path)
+	}
+}
+
+func Test_setRequestCookie_Secure(t *testing.T) {
+	w := httptest.NewRecorder()
+	cfg := Config{}
+	cfg.ServiceProvider.AcsURL = url.URL{Scheme: "https", Host: "auth.example.com", Path: "/login/saml/corp/acs"}
+	setRequestCookie(w, cfg, "/login/saml/corp", "id-42", 600)
+	c := w.Result().Cookies()[0]
+	True(t, c.Secure)
+	True(t, c.HttpOnly)
+	Equal(t, http.SameSiteNoneMode, c.SameSite)
+	Equal(t, "id-42", c.Value)
+
+	w = httptest.NewRecorder()
+	cfg.ServiceProvider.AcsURL.Scheme = "http"
+	setRequestCookie(w, cfg, "/login/saml/corp", "id-42", 600)
+	False(t, w.Result().Cookies()[0].Secure)
+}